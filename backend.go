@@ -9,6 +9,7 @@ import (
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
@@ -19,6 +20,10 @@ type NatsBackend struct {
 	*framework.Backend
 	lock   sync.RWMutex
 	client *NatsClient
+
+	// keyed locks serializing issue mutations, see locks.go
+	operatorLocks []*locksutil.LockEntry
+	accountLocks  []*locksutil.LockEntry
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -33,7 +38,10 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 // for Vault. It must include each path
 // and the secrets it will store.
 func backend() *NatsBackend {
-	var b = NatsBackend{}
+	var b = NatsBackend{
+		operatorLocks: locksutil.CreateLocks(),
+		accountLocks:  locksutil.CreateLocks(),
+	}
 
 	b.Backend = &framework.Backend{
 		Help: strings.TrimSpace(backendHelp),
@@ -49,13 +57,24 @@ func backend() *NatsBackend {
 			pathJWT(&b),
 			pathIssue(&b),
 			pathCreds(&b),
+			pathActivation(&b),
+			pathConfig(&b),
+			pathTombstone(&b),
+			pathImport(&b),
+			pathExport(&b),
+			pathBackup(&b),
+			pathApply(&b),
+			pathSchema(&b),
+			pathDoctor(&b),
 			[]*framework.Path{},
 		),
 		Secrets: []*framework.Secret{
 			// b.hashiCupsToken(),
 		},
 		BackendType:       logical.TypeLogical,
+		InitializeFunc:    b.initialize,
 		Invalidate:        b.invalidate,
+		WALRollback:       b.walRollback,
 		WALRollbackMinAge: 30 * time.Second,
 		PeriodicFunc:      b.periodicFunc,
 	}
@@ -189,52 +208,75 @@ func (b *NatsBackend) periodicFunc(ctx context.Context, sys *logical.Request) er
 		return err
 	}
 	for _, operator := range operators {
-		operatorIssue, err := readOperatorIssue(ctx, sys.Storage, IssueOperatorParameters{
-			Operator: operator,
-		})
+		err = b.periodicRefreshOperator(ctx, sys.Storage, operator)
 		if err != nil {
 			return err
 		}
-		if operatorIssue != nil {
-			b.Logger().Debug(fmt.Sprintf("Periodic: operator %s selected for auto sync to account server", operator))
-			accountNames, err := listAccountIssues(ctx, sys.Storage, operator)
+	}
+	return b.purgeTombstones(ctx, sys.Storage)
+}
+
+// periodicRefreshOperator repairs the accounts and users of an operator and
+// syncs the accounts to the account server. The operator is locked meanwhile
+// so the refresh can't overwrite concurrent updates of its issues.
+func (b *NatsBackend) periodicRefreshOperator(ctx context.Context, storage logical.Storage, operator string) error {
+	defer b.lockOperator(operator)()
+
+	operatorIssue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return err
+	}
+	if operatorIssue == nil {
+		return nil
+	}
+
+	err = advanceOperatorSigningKeyRotation(ctx, storage, operatorIssue)
+	if err != nil {
+		b.Logger().Info(err.Error())
+	}
+
+	b.Logger().Debug(fmt.Sprintf("Periodic: operator %s selected for auto sync to account server", operator))
+	accountNames, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return err
+	}
+	for _, account := range accountNames {
+		if err = b.periodicRefreshAccountIssues(ctx, storage, operator); err != nil {
+			b.Logger().Info(err.Error())
+		}
+		if err = b.periodicRefreshUserIssues(ctx, storage, operator, account); err != nil {
+			b.Logger().Info(err.Error())
+		}
+		if err = periodicAdvanceAccountSigningKeyRotation(ctx, storage, operator, account); err != nil {
+			b.Logger().Info(err.Error())
+		}
+
+		if operatorIssue.SyncAccountServer {
+			b.Logger().Debug(fmt.Sprintf("Periodic: account %s in operator %s syncing to acount server", account, operator))
+			accountIssue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+				Operator: operator,
+				Account:  account,
+			})
 			if err != nil {
+				b.Logger().Info(err.Error())
+				continue
+			}
+			if accountIssue == nil {
+				b.Logger().Info(fmt.Sprintf("account issue for %s/%s does not exist", operator, account))
+				continue
+			}
+			if err = refreshAccountResolver(ctx, storage, accountIssue, AccountResolverActionPush); err != nil {
 				return err
 			}
-			for _, account := range accountNames {
-				if err = b.periodicRefreshAccountIssues(ctx, sys.Storage, operator); err != nil {
-					b.Logger().Info(err.Error())
-				}
-				if err = b.periodicRefreshUserIssues(ctx, sys.Storage, operator, account); err != nil {
-					b.Logger().Info(err.Error())
-				}
-
-				if operatorIssue.SyncAccountServer {
-					b.Logger().Debug(fmt.Sprintf("Periodic: account %s in operator %s syncing to acount server", account, operator))
-					accountIssue, err := readAccountIssue(ctx, sys.Storage, IssueAccountParameters{
-						Operator: operator,
-						Account:  account,
-					})
-					if err != nil {
-						b.Logger().Info(err.Error())
-						continue
-					}
-					if accountIssue == nil {
-						b.Logger().Info(fmt.Sprintf("account issue for %s/%s does not exist", operator, account))
-						continue
-					}
-					if err = refreshAccountResolver(ctx, sys.Storage, accountIssue, AccountResolverActionPush); err != nil {
-						return err
-					}
-					_, err = storeAccountIssueUpdate(ctx, sys.Storage, accountIssue)
-					if err != nil {
-						return err
-					}
-				} else {
-					b.Logger().Info(fmt.Sprintf("Periodic: operator %s not configured for auto syncing to account server. Skipping.", operator))
-					continue
-				}
+			_, err = storeAccountIssueUpdate(ctx, storage, accountIssue)
+			if err != nil {
+				return err
 			}
+		} else {
+			b.Logger().Info(fmt.Sprintf("Periodic: operator %s not configured for auto syncing to account server. Skipping.", operator))
+			continue
 		}
 	}
 	return nil
@@ -278,6 +320,10 @@ func (b *NatsBackend) periodicRefreshUserIssues(ctx context.Context, storage log
 				return err
 			}
 		}
+
+		if err := rotateUserNkeyIfDue(ctx, storage, issue); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -31,6 +31,11 @@ func getTestBackend(tb testing.TB) (*NatsBackend, logical.Storage) {
 	if err != nil {
 		tb.Fatal(err)
 	}
+	// vault initializes the backend once it is mounted
+	err = b.Initialize(context.Background(), &logical.InitializationRequest{Storage: config.StorageView})
+	if err != nil {
+		tb.Fatal(err)
+	}
 
 	return b.(*NatsBackend), config.StorageView
 }
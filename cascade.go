@@ -0,0 +1,425 @@
+package natsbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+)
+
+// cascadeField deletes the issues below an operator or account together with it
+var cascadeField = &framework.FieldSchema{
+	Type:        framework.TypeBool,
+	Description: "Delete all issues below together with this one (default: false)",
+	Required:    false,
+}
+
+// dryRunField only reports what a delete would remove
+var dryRunField = &framework.FieldSchema{
+	Type:        framework.TypeBool,
+	Description: "Only list the storage entries the delete would remove (default: false)",
+	Required:    false,
+}
+
+// cascadeStep is a single deletion of a cascading delete
+type cascadeStep struct {
+	// keys are the storage entries removed by the step
+	keys []string
+	run  func() error
+}
+
+// cascadePlan lists the steps of a cascading delete in the order they run
+type cascadePlan []cascadeStep
+
+// keys returns all storage entries removed by the plan
+func (p cascadePlan) keys() []string {
+	keys := []string{}
+	for _, step := range p {
+		keys = append(keys, step.keys...)
+	}
+	return keys
+}
+
+func (p cascadePlan) run() error {
+	for _, step := range p {
+		err := step.run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planOperatorCascade plans the deletion of the operator with all its links,
+// accounts and users. Links are removed without updating the accounts, which
+// are deleted anyway. The users are removed without revocation, deleting the
+// account from the account server invalidates them. The system account goes
+// last, as it is needed to push the deletions of the other accounts. Nkeys
+// and JWTs of the operator without issue are removed before the operator.
+func planOperatorCascade(ctx context.Context, storage logical.Storage, operator string) (cascadePlan, error) {
+	plan := cascadePlan{}
+
+	links, err := listLinkIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		path := getLinkIssuePath(operator, link)
+		plan = append(plan, cascadeStep{
+			keys: []string{path},
+			run: func() error {
+				return deleteFromStorage(ctx, storage, path)
+			},
+		})
+	}
+
+	accounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	hasSysAccount := false
+	for _, account := range accounts {
+		if account == DefaultSysAccountName {
+			hasSysAccount = true
+			continue
+		}
+		steps, err := planAccountDeletion(ctx, storage, operator, account)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, steps...)
+	}
+	if hasSysAccount {
+		steps, err := planAccountDeletion(ctx, storage, operator, DefaultSysAccountName)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, steps...)
+	}
+
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return nil, err
+	}
+	operatorSteps := cascadePlan{}
+	if issue != nil {
+		keys, err := operatorIssueKeys(ctx, storage, issue)
+		if err != nil {
+			return nil, err
+		}
+		operatorSteps = append(operatorSteps, cascadeStep{
+			keys: keys,
+			run: func() error {
+				return deleteOperatorIssue(ctx, storage, IssueOperatorParameters{
+					Operator: operator,
+				})
+			},
+		})
+	}
+
+	plan, err = plan.withRemainingKeys(ctx, storage, operatorSteps, getOperatorNkeyPath(operator), getOperatorJWTPath(operator))
+	if err != nil {
+		return nil, err
+	}
+	return append(plan, operatorSteps...), nil
+}
+
+// planPlainOperatorDelete plans the delete of the operator without cascade,
+// which removes its system account created by createSystemAccount as well
+func planPlainOperatorDelete(ctx context.Context, storage logical.Storage, operator string) (cascadePlan, error) {
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil || issue == nil {
+		return cascadePlan{}, err
+	}
+
+	keys := []string{}
+	if issue.CreateSystemAccount {
+		keys, err = existingKeys(ctx, storage, []string{
+			getUserIssuePath(operator, DefaultSysAccountName, DefaultPushUser),
+			getUserNkeyPath(operator, DefaultSysAccountName, DefaultPushUser),
+		})
+		if err != nil {
+			return nil, err
+		}
+		sysAccount, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: operator,
+			Account:  DefaultSysAccountName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sysAccount != nil {
+			sysKeys, err := accountIssueKeys(ctx, storage, sysAccount)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, sysKeys...)
+		}
+	}
+	operatorKeys, err := operatorIssueKeys(ctx, storage, issue)
+	if err != nil {
+		return nil, err
+	}
+	return cascadePlan{{
+		keys: append(keys, operatorKeys...),
+		run: func() error {
+			return deleteOperatorIssue(ctx, storage, IssueOperatorParameters{
+				Operator: operator,
+			})
+		},
+	}}, nil
+}
+
+// planAccountCascade plans the deletion of the account with its users and
+// the links it is part of. Links are deleted regularly, so the other account
+// of the link is updated and pushed.
+func planAccountCascade(ctx context.Context, storage logical.Storage, operator string, account string) (cascadePlan, error) {
+	plan := cascadePlan{}
+
+	links, err := listLinkIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		issue, err := readLinkIssue(ctx, storage, IssueLinkParameters{
+			Operator: operator,
+			Link:     link,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue == nil || (issue.Exporter != account && issue.Importer != account) {
+			continue
+		}
+		params := IssueLinkParameters{
+			Operator: operator,
+			Link:     link,
+		}
+		plan = append(plan, cascadeStep{
+			keys: []string{getLinkIssuePath(operator, link)},
+			run: func() error {
+				return deleteLinkIssue(ctx, storage, params)
+			},
+		})
+	}
+
+	steps, err := planAccountDeletion(ctx, storage, operator, account)
+	if err != nil {
+		return nil, err
+	}
+	// nkeys and JWTs of the account and its users without issue
+	return append(plan, steps...).withRemainingKeys(ctx, storage, nil, getAccountNkeyPath(operator, account), getAccountJWTPath(operator, account))
+}
+
+// planPlainAccountDelete plans the delete of the account without cascade,
+// which keeps its users and links
+func planPlainAccountDelete(ctx context.Context, storage logical.Storage, operator string, account string) (cascadePlan, error) {
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil || issue == nil {
+		return cascadePlan{}, err
+	}
+	keys, err := accountIssueKeys(ctx, storage, issue)
+	if err != nil {
+		return nil, err
+	}
+	return cascadePlan{{
+		keys: keys,
+		run: func() error {
+			return deleteAccountIssue(ctx, storage, IssueAccountParameters{
+				Operator: operator,
+				Account:  account,
+			})
+		},
+	}}, nil
+}
+
+// planAccountDeletion plans the deletion of the users of the account
+// without revocation, followed by the account itself
+func planAccountDeletion(ctx context.Context, storage logical.Storage, operator string, account string) (cascadePlan, error) {
+	plan := cascadePlan{}
+
+	users, err := listUserIssues(ctx, storage, IssueUserParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+			Operator: operator,
+			Account:  account,
+			User:     user,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue == nil {
+			continue
+		}
+		keys, err := existingKeys(ctx, storage, []string{
+			getUserIssuePath(operator, account, user),
+			getUserNkeyPath(operator, account, user),
+		})
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, cascadeStep{
+			keys: keys,
+			run: func() error {
+				return removeUserIssue(ctx, storage, issue)
+			},
+		})
+	}
+
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if issue == nil {
+		return plan, nil
+	}
+	keys, err := accountIssueKeys(ctx, storage, issue)
+	if err != nil {
+		return nil, err
+	}
+	plan = append(plan, cascadeStep{
+		keys: keys,
+		run: func() error {
+			return deleteAccountIssue(ctx, storage, IssueAccountParameters{
+				Operator: operator,
+				Account:  account,
+			})
+		},
+	})
+	return plan, nil
+}
+
+// deleteOperatorIssueCascade deletes the operator with all its links, accounts and
+// users and returns the removed storage entries
+func deleteOperatorIssueCascade(ctx context.Context, storage logical.Storage, operator string) ([]string, error) {
+	log.Info().
+		Str("operator", operator).
+		Msg("cascading delete of operator")
+
+	plan, err := planOperatorCascade(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	return plan.keys(), plan.run()
+}
+
+// deleteAccountIssueCascade deletes the account with its users and the links it is
+// part of and returns the removed storage entries
+func deleteAccountIssueCascade(ctx context.Context, storage logical.Storage, operator string, account string) ([]string, error) {
+	log.Info().
+		Str("operator", operator).Str("account", account).
+		Msg("cascading delete of account")
+
+	plan, err := planAccountCascade(ctx, storage, operator, account)
+	if err != nil {
+		return nil, err
+	}
+	return plan.keys(), plan.run()
+}
+
+// withRemainingKeys adds a step removing the entries below the keys that no
+// step of the plan or of next removes, e.g. nkeys stored before their issue
+// was created or left by a deleted issue
+func (p cascadePlan) withRemainingKeys(ctx context.Context, storage logical.Storage, next cascadePlan, keys ...string) (cascadePlan, error) {
+	planned := map[string]bool{}
+	for _, key := range append(p.keys(), next.keys()...) {
+		planned[key] = true
+	}
+	remaining := []string{}
+	for _, key := range keys {
+		below, err := storedKeysBelow(ctx, storage, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range below {
+			if !planned[key] {
+				remaining = append(remaining, key)
+			}
+		}
+	}
+	if len(remaining) == 0 {
+		return p, nil
+	}
+	return append(p, cascadeStep{
+		keys: remaining,
+		run: func() error {
+			for _, key := range remaining {
+				err := storage.Delete(ctx, key)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}), nil
+}
+
+// operatorIssueKeys returns the existing entries of the operator issue: the
+// issue, its nkeys and its JWT
+func operatorIssueKeys(ctx context.Context, storage logical.Storage, issue *IssueOperatorStorage) ([]string, error) {
+	keys := []string{
+		getOperatorIssuePath(issue.Operator),
+		getOperatorNkeyPath(issue.Operator),
+	}
+	for _, signingKey := range issue.Claims.SigningKeys {
+		keys = append(keys, getOperatorSigningNkeyPath(issue.Operator, signingKey))
+	}
+	keys = append(keys, getOperatorJWTPath(issue.Operator))
+	return existingKeys(ctx, storage, keys)
+}
+
+// accountIssueKeys returns the existing entries of the account issue: the
+// issue, its nkeys and its JWT
+func accountIssueKeys(ctx context.Context, storage logical.Storage, issue *IssueAccountStorage) ([]string, error) {
+	keys := []string{
+		getAccountIssuePath(issue.Operator, issue.Account),
+		getAccountNkeyPath(issue.Operator, issue.Account),
+	}
+	for _, signingKey := range issue.Claims.SigningKeys {
+		keys = append(keys, getAccountSigningNkeyPath(issue.Operator, issue.Account, signingKey))
+	}
+	keys = append(keys, getAccountJWTPath(issue.Operator, issue.Account))
+	return existingKeys(ctx, storage, keys)
+}
+
+// existingKeys filters the storage keys that exist
+func existingKeys(ctx context.Context, storage logical.Storage, keys []string) ([]string, error) {
+	existing := []string{}
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			existing = append(existing, key)
+		}
+	}
+	return existing, nil
+}
+
+// createResponseCascade lists the storage entries removed (or to be removed on a dry run) by a cascading delete
+func createResponseCascade(keys []string, dryRun bool) *logical.Response {
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"dryRun":  dryRun,
+			"deleted": keys,
+		},
+	}
+}
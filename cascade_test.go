@@ -0,0 +1,208 @@
+package natsbackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCascade(t *testing.T) (*NatsBackend, logical.Storage) {
+	t.Helper()
+	b, reqStorage := getTestBackend(t)
+
+	// accounts must allow exports and imports
+	limits := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"account": map[string]interface{}{
+				"limits": map[string]interface{}{
+					"imports":         -1,
+					"exports":         -1,
+					"wildcardExports": true,
+				},
+			},
+		},
+	}
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{"createSystemAccount": true}},
+		{"issue/operator/op1/account/exporter", limits},
+		{"issue/operator/op1/account/importer", limits},
+		{"issue/operator/op1/account/exporter/user/us1", map[string]interface{}{}},
+		{"issue/operator/op1/account/exporter/user/us2", map[string]interface{}{}},
+		{"issue/operator/op1/link/svc", map[string]interface{}{
+			"exporter": "exporter",
+			"importer": "importer",
+			"subject":  "svc.>",
+			"type":     "service",
+		}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	return b, reqStorage
+}
+
+func TestAccountIssueCascadeDelete(t *testing.T) {
+	b, reqStorage := setupCascade(t)
+	expected := []string{
+		"issue/operator/op1/link/svc",
+		"issue/operator/op1/account/exporter/user/us1",
+		"nkey/operator/op1/account/exporter/user/us1",
+		"issue/operator/op1/account/exporter/user/us2",
+		"nkey/operator/op1/account/exporter/user/us2",
+		"issue/operator/op1/account/exporter",
+		"nkey/operator/op1/account/exporter",
+		"jwt/operator/op1/account/exporter",
+		// a user nkey without issue
+		"nkey/operator/op1/account/exporter/user/us3",
+	}
+	seed, err := createSeed(nkeys.PrefixByteUser)
+	require.NoError(t, err)
+	require.NoError(t, storeInStorage(context.Background(), reqStorage, getUserNkeyPath("op1", "exporter", "us3"), &NKeyStorage{Seed: seed}))
+
+	t.Run("dryRun without cascade lists the entries of the account", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"dryRun": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, true, resp.Data["dryRun"])
+		assert.Equal(t, []string{
+			"issue/operator/op1/account/exporter",
+			"nkey/operator/op1/account/exporter",
+			"jwt/operator/op1/account/exporter",
+		}, resp.Data["deleted"])
+	})
+
+	t.Run("dryRun lists the entries to remove", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true, "dryRun": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, true, resp.Data["dryRun"])
+		assert.Equal(t, expected, resp.Data["deleted"])
+
+		for _, key := range expected {
+			entry, err := reqStorage.Get(context.Background(), key)
+			require.NoError(t, err)
+			assert.NotNil(t, entry, key)
+		}
+	})
+
+	t.Run("cascade removes the subtree", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, false, resp.Data["dryRun"])
+		assert.Equal(t, expected, resp.Data["deleted"])
+
+		for _, key := range expected {
+			entry, err := reqStorage.Get(context.Background(), key)
+			require.NoError(t, err)
+			assert.Nil(t, entry, key)
+		}
+
+		// the importer got unlinked
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+			Operator: "op1",
+			Account:  "importer",
+		})
+		require.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.Empty(t, claims.Imports)
+	})
+}
+
+func TestOperatorIssueCascadeDelete(t *testing.T) {
+	b, reqStorage := setupCascade(t)
+
+	// a plain delete removes the system account with the operator
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{"dryRun": true},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	assert.Equal(t, []string{
+		"issue/operator/op1/account/sys/user/default-push",
+		"nkey/operator/op1/account/sys/user/default-push",
+		"issue/operator/op1/account/sys",
+		"nkey/operator/op1/account/sys",
+		"jwt/operator/op1/account/sys",
+		"issue/operator/op1",
+		"nkey/operator/op1",
+		"jwt/operator/op1",
+	}, resp.Data["deleted"])
+
+	// nkeys and JWTs without issue are removed as well
+	orphans := []string{
+		getAccountNkeyPath("op1", "gone"),
+		getAccountJWTPath("op1", "gone"),
+	}
+	for _, key := range orphans {
+		require.NoError(t, reqStorage.Put(context.Background(), &logical.StorageEntry{Key: key, Value: []byte("{}")}))
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{"cascade": true, "dryRun": true},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	planned := resp.Data["deleted"].([]string)
+	assert.Equal(t, "issue/operator/op1/link/svc", planned[0])
+	assert.Contains(t, planned, "issue/operator/op1/account/importer")
+	assert.Contains(t, planned, "nkey/operator/op1/account/exporter/user/us2")
+	assert.Contains(t, planned, "issue/operator/op1/account/sys/user/default-push")
+	for _, key := range orphans {
+		assert.Contains(t, planned, key)
+	}
+	assert.Equal(t, "jwt/operator/op1", planned[len(planned)-1])
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{"cascade": true},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	assert.Equal(t, planned, resp.Data["deleted"])
+
+	// nothing is left of the operator
+	for _, prefix := range []string{"issue/", "nkey/", "jwt/"} {
+		keys, err := reqStorage.List(context.Background(), prefix)
+		require.NoError(t, err)
+		assert.Empty(t, keys, prefix)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// newApplyCommand writes the desired state of an operator to the apply path
+// of the mount. plan only shows the changes.
+func newApplyCommand(plan bool) *cobra.Command {
+	var vault vaultFlags
+	var operator, file string
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Apply the desired state of an operator to the mount",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runApply(&vault, operator, file, plan)
+		},
+	}
+	if plan {
+		cmd.Use = "plan"
+		cmd.Short = "Show the changes applying the desired state of an operator would make"
+	}
+	cmd.Flags().StringVar(&operator, "operator", "", "operator of the desired state")
+	cmd.Flags().StringVarP(&file, "file", "f", "-", "file with the desired state, - for stdin")
+	vault.register(cmd)
+	_ = cmd.MarkFlagRequired("operator")
+	return cmd
+}
+
+func runApply(vault *vaultFlags, operator, file string, plan bool) error {
+	contents, err := readInput(file)
+	if err != nil {
+		return err
+	}
+	data := map[string]interface{}{}
+	err = json.Unmarshal(contents, &data)
+	if err != nil {
+		return fmt.Errorf("invalid desired state: %w", err)
+	}
+	data["plan"] = plan
+
+	client, err := vault.client()
+	if err != nil {
+		return err
+	}
+	secret, err := client.Logical().Write(vault.path("apply/operator/"+operator), data)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("empty response of vault")
+	}
+	for _, warning := range secret.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	changes, _ := secret.Data["changes"].([]interface{})
+	if len(changes) == 0 {
+		fmt.Printf("operator %s is up to date\n", operator)
+		return nil
+	}
+	for _, c := range changes {
+		change, _ := c.(map[string]interface{})
+		name := fmt.Sprint(change["name"])
+		if account, ok := change["account"]; ok {
+			name = fmt.Sprintf("%v/%s", account, name)
+		}
+		fmt.Printf("%v %v %s\n", change["action"], change["kind"], name)
+	}
+	if plan {
+		fmt.Printf("%d changes planned for operator %s\n", len(changes), operator)
+	} else {
+		fmt.Printf("%d changes applied to operator %s\n", len(changes), operator)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+)
+
+func newDecodeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "decode [file]",
+		Short: "Pretty-print the claims of a JWT or creds file",
+		Long: "Decode prints the claims of an operator, account or user JWT, or of the\n" +
+			"JWT of a creds file, as JSON. The signature of the JWT is verified. The\n" +
+			"file defaults to stdin.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := "-"
+			if len(args) > 0 {
+				file = args[0]
+			}
+			token, err := readJWT(file)
+			if err != nil {
+				return err
+			}
+			claims, err := jwt.Decode(token)
+			if err != nil {
+				return fmt.Errorf("invalid jwt: %w", err)
+			}
+			return printJSON(claims)
+		},
+	}
+	return cmd
+}
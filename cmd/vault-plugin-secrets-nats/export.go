@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/cobra"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/nsc"
+)
+
+// newExportNscCommand extracts the nsc store of an operator of the mount
+// into a directory. With an xkey seed the keys directory is exported as
+// well, encrypted for the public key of the seed.
+func newExportNscCommand() *cobra.Command {
+	var vault vaultFlags
+	var dir, operator, xkey string
+	cmd := &cobra.Command{
+		Use:   "export-nsc",
+		Short: "Export an operator of the mount as nsc store",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runExportNsc(&vault, dir, operator, xkey)
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", filepath.Join(os.Getenv("HOME"), ".local", "share", "nats", "nsc"), "nsc data directory the store is extracted to")
+	cmd.Flags().StringVar(&operator, "operator", "", "operator to export")
+	cmd.Flags().StringVar(&xkey, "xkey", "", "file with the curve (xkey) seed to export and decrypt the keys")
+	vault.register(cmd)
+	_ = cmd.MarkFlagRequired("operator")
+	return cmd
+}
+
+func runExportNsc(vault *vaultFlags, dir, operator, xkey string) error {
+	client, err := vault.client()
+	if err != nil {
+		return err
+	}
+	path := vault.path("export/nsc/" + operator)
+	var seed []byte
+	var secret *api.Secret
+	if xkey != "" {
+		data, err := os.ReadFile(xkey)
+		if err != nil {
+			return err
+		}
+		seed = []byte(strings.TrimSpace(string(data)))
+		kp, err := nkeys.FromCurveSeed(seed)
+		if err != nil {
+			return err
+		}
+		publicKey, err := kp.PublicKey()
+		if err != nil {
+			return err
+		}
+		secret, err = client.Logical().Write(path+"/keys", map[string]interface{}{"publicKey": publicKey})
+		if err != nil {
+			return err
+		}
+	} else {
+		secret, err = client.Logical().Read(path)
+		if err != nil {
+			return err
+		}
+	}
+	if secret == nil {
+		return fmt.Errorf("operator %s does not exist", operator)
+	}
+	for _, warning := range secret.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	encoded, _ := secret.Data["archive"].(string)
+	archive, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	if seed != nil {
+		archive, err = nsc.OpenArchive(archive, seed)
+		if err != nil {
+			return err
+		}
+	}
+	err = nsc.ExtractArchive(archive, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("exported operator %s to %s\n", operator, dir)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/nsc"
+)
+
+// newImportNscCommand imports an operator of an nsc store into the mount of
+// the plugin.
+func newImportNscCommand() *cobra.Command {
+	nscHome := filepath.Join(os.Getenv("HOME"), ".local", "share", "nats", "nsc")
+	defaultKeys := os.Getenv("NKEYS_PATH")
+	if defaultKeys == "" {
+		defaultKeys = filepath.Join(nscHome, "keys")
+	}
+
+	var vault vaultFlags
+	var storeDir, keysDir, operator, name string
+	cmd := &cobra.Command{
+		Use:   "import-nsc",
+		Short: "Import an operator of an nsc store into the mount",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				name = operator
+			}
+			return runImportNsc(&vault, storeDir, keysDir, operator, name)
+		},
+	}
+	cmd.Flags().StringVar(&storeDir, "store", filepath.Join(nscHome, "stores"), "nsc store directory")
+	cmd.Flags().StringVar(&keysDir, "keys", defaultKeys, "nsc keys directory")
+	cmd.Flags().StringVar(&operator, "operator", "", "operator of the nsc store to import")
+	cmd.Flags().StringVar(&name, "name", "", "operator identifier in the mount (default: the operator of the store)")
+	vault.register(cmd)
+	_ = cmd.MarkFlagRequired("operator")
+	return cmd
+}
+
+func runImportNsc(vault *vaultFlags, storeDir, keysDir, operator, name string) error {
+	store, err := nsc.ReadStore(storeDir, keysDir, operator)
+	if err != nil {
+		return err
+	}
+
+	client, err := vault.client()
+	if err != nil {
+		return err
+	}
+	secret, err := client.Logical().Write(vault.path("import/nsc/"+name), map[string]interface{}{
+		"jwt":      store.JWT,
+		"accounts": store.Accounts,
+		"users":    store.Users,
+		"seeds":    store.Seeds,
+	})
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return nil
+	}
+	for _, warning := range secret.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	fmt.Printf("imported operator %s\n", name)
+	for _, key := range []string{"accounts", "users"} {
+		if list, ok := secret.Data[key].([]interface{}); ok {
+			for _, entry := range list {
+				fmt.Printf("  %s %v\n", strings.TrimSuffix(key, "s"), entry)
+			}
+		}
+	}
+	return nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"io"
+	"os"
+	"strings"
+
 	nats "github.com/edgefarm/vault-plugin-secrets-nats"
+	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
+	"github.com/spf13/cobra"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -11,10 +17,74 @@ import (
 func main() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
-	err := plugin.Serve(&plugin.ServeOpts{
-		BackendFactoryFunc: nats.Factory,
-	})
+	err := newRootCommand().Execute()
 	if err != nil {
-		log.Error().Err(err).Msg("plugin shutting down")
+		os.Exit(1)
+	}
+}
+
+// newRootCommand serves the plugin if no subcommand is given, so the binary
+// registered in vault keeps working with whatever arguments vault passes.
+func newRootCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vault-plugin-secrets-nats",
+		Short: "Vault secrets plugin for NATS with offline tooling",
+		Args:  cobra.ArbitraryArgs,
+		FParseErrWhitelist: cobra.FParseErrWhitelist{
+			UnknownFlags: true,
+		},
+		SilenceUsage: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := plugin.Serve(&plugin.ServeOpts{
+				BackendFactoryFunc: nats.Factory,
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("plugin shutting down")
+			}
+		},
+	}
+	cmd.AddCommand(
+		newRenderCommand(),
+		newVerifyCommand(),
+		newDecodeCommand(),
+		newSchemaCommand(),
+		newApplyCommand(false),
+		newApplyCommand(true),
+		newImportNscCommand(),
+		newExportNscCommand(),
+	)
+	return cmd
+}
+
+// vaultFlags are the flags of the subcommands talking to the mount. The
+// token is taken from the environment like the vault CLI does, e.g.
+// VAULT_TOKEN.
+type vaultFlags struct {
+	address string
+	mount   string
+}
+
+func (f *vaultFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.address, "address", "", "address of vault (default: VAULT_ADDR)")
+	cmd.Flags().StringVar(&f.mount, "mount", "nats-secrets", "mount path of the plugin")
+}
+
+func (f *vaultFlags) client() (*api.Client, error) {
+	config := api.DefaultConfig()
+	if f.address != "" {
+		config.Address = f.address
+	}
+	return api.NewClient(config)
+}
+
+func (f *vaultFlags) path(path string) string {
+	return strings.Trim(f.mount, "/") + "/" + path
+}
+
+// readInput reads the file, or stdin for "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
 	}
+	return os.ReadFile(path)
 }
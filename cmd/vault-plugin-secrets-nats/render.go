@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	nats "github.com/edgefarm/vault-plugin-secrets-nats"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/user/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+// newRenderCommand expands the claims template of a user issue like the
+// creds path does, without subject, issuer and expiration
+func newRenderCommand() *cobra.Command {
+	var file, parameters string
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render the claims template of a user issue locally",
+		Long: "Render expands the claims template of a user issue (the JSON written to\n" +
+			"issue/operator/<operator>/account/<account>/user/<user>) with the template\n" +
+			"parameters and prints the resulting user claims of the JWT.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			claims, warnings, err := renderUserClaims(file, parameters)
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+			}
+			if err != nil {
+				return err
+			}
+			return printJSON(claims)
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "-", "file with the user issue, - for stdin")
+	cmd.Flags().StringVarP(&parameters, "parameters", "p", "", "template parameters as key=value,key2=value2 or JSON")
+	return cmd
+}
+
+func renderUserClaims(file, parameters string) (interface{}, []string, error) {
+	data, err := readInput(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var issue nats.IssueUserParameters
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&issue)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid user issue: %w", err)
+	}
+
+	params := map[string]string{}
+	if parameters != "" {
+		params, err = nats.ParseTemplateParameters(parameters)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	claims, err := nats.RenderClaimsTemplate(issue.ClaimsTemplate, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	natsJwt, err := v1alpha1.Convert(&claims)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not convert claims to nats jwt: %w", err)
+	}
+	warnings, err := validate.Claims(natsJwt)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return natsJwt, warnings, nil
+}
+
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
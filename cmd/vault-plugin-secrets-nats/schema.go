@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema"
+)
+
+func newSchemaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:       "schema <" + strings.Join(schema.Kinds(), "|") + ">",
+		Short:     "Print the JSON Schema of operator, account or user claims",
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: schema.Kinds(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			raw, err := schema.Raw(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(raw))
+			return nil
+		},
+	}
+	return cmd
+}
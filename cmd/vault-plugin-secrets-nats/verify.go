@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/spf13/cobra"
+)
+
+// verification is the result of checking a JWT against its issuers
+type verification struct {
+	kind     string
+	name     string
+	subject  string
+	problems []string
+	warnings []string
+}
+
+func newVerifyCommand() *cobra.Command {
+	var operatorFile, accountFile string
+	cmd := &cobra.Command{
+		Use:   "verify <file>",
+		Short: "Verify a creds file or JWT against an operator JWT",
+		Long: "Verify checks the signature and validity of an account JWT, user JWT or\n" +
+			"creds file and that it is issued by the operator. Users are verified\n" +
+			"against the account JWT of --account, including its revocations. The\n" +
+			"seed of a creds file must belong to the user. - reads the file from stdin.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			operatorJWT, err := readJWT(operatorFile)
+			if err != nil {
+				return err
+			}
+			var accountJWT string
+			if accountFile != "" {
+				accountJWT, err = readJWT(accountFile)
+				if err != nil {
+					return err
+				}
+			}
+			contents, err := readInput(args[0])
+			if err != nil {
+				return err
+			}
+
+			result, err := verifyCredentials(operatorJWT, accountJWT, contents)
+			if err != nil {
+				return err
+			}
+			for _, warning := range result.warnings {
+				fmt.Printf("warning: %s\n", warning)
+			}
+			if len(result.problems) > 0 {
+				for _, problem := range result.problems {
+					fmt.Printf("invalid: %s\n", problem)
+				}
+				return fmt.Errorf("%s %s is not valid", result.kind, result.name)
+			}
+			fmt.Printf("%s %s (%s) is valid\n", result.kind, result.name, result.subject)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&operatorFile, "operator", "", "file with the operator JWT")
+	cmd.Flags().StringVar(&accountFile, "account", "", "file with the account JWT, required for users")
+	_ = cmd.MarkFlagRequired("operator")
+	return cmd
+}
+
+// verifyCredentials verifies the JWT in contents, a creds file or a plain
+// JWT. Errors are returned for input that can't be verified at all.
+func verifyCredentials(operatorJWT, accountJWT string, contents []byte) (*verification, error) {
+	operator, err := jwt.DecodeOperatorClaims(operatorJWT)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operator jwt: %w", err)
+	}
+	token, err := jwt.ParseDecoratedJWT(contents)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := jwt.Decode(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwt: %w", err)
+	}
+
+	result := &verification{
+		kind:    string(claims.ClaimType()),
+		name:    claims.Claims().Name,
+		subject: claims.Claims().Subject,
+	}
+	result.validate(claims)
+	switch c := claims.(type) {
+	case *jwt.OperatorClaims:
+		if c.Subject != operator.Subject {
+			result.problems = append(result.problems, fmt.Sprintf("operator is not %s", operator.Subject))
+		}
+	case *jwt.AccountClaims:
+		if !operator.DidSign(c) {
+			result.problems = append(result.problems, fmt.Sprintf("account is not signed by operator %s", operator.Name))
+		}
+	case *jwt.UserClaims:
+		if accountJWT == "" {
+			return nil, fmt.Errorf("verifying a user needs the account jwt")
+		}
+		account, err := jwt.DecodeAccountClaims(accountJWT)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account jwt: %w", err)
+		}
+		if !operator.DidSign(account) {
+			result.problems = append(result.problems, fmt.Sprintf("account %s is not signed by operator %s", account.Name, operator.Name))
+		}
+		accountResult := &verification{}
+		accountResult.validate(account)
+		for _, problem := range accountResult.problems {
+			result.problems = append(result.problems, fmt.Sprintf("account %s: %s", account.Name, problem))
+		}
+		if !account.DidSign(c) {
+			result.problems = append(result.problems, fmt.Sprintf("user is not signed by account %s", account.Name))
+		}
+		if account.IsClaimRevoked(c) {
+			result.problems = append(result.problems, fmt.Sprintf("user is revoked by account %s", account.Name))
+		}
+		if bytes.Contains(contents, []byte("NKEY SEED")) {
+			kp, err := jwt.ParseDecoratedUserNKey(contents)
+			if err != nil {
+				return nil, err
+			}
+			publicKey, err := kp.PublicKey()
+			if err != nil {
+				return nil, err
+			}
+			if publicKey != c.Subject {
+				result.problems = append(result.problems, "seed does not belong to the user")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt type %s", claims.ClaimType())
+	}
+	return result, nil
+}
+
+// validate adds the issues of the nats-io/jwt validation, expired claims
+// are problems
+func (v *verification) validate(claims jwt.Claims) {
+	vr := jwt.CreateValidationResults()
+	claims.Validate(vr)
+	for _, issue := range vr.Issues {
+		if issue.Blocking || issue.TimeCheck {
+			v.problems = append(v.problems, issue.Description)
+		} else {
+			v.warnings = append(v.warnings, issue.Description)
+		}
+	}
+}
+
+// readJWT reads a JWT from a file, which may be decorated like creds files
+func readJWT(path string) (string, error) {
+	contents, err := readInput(path)
+	if err != nil {
+		return "", err
+	}
+	token, err := jwt.ParseDecoratedJWT(contents)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(token), nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCredentials(t *testing.T) {
+	operatorKey, err := nkeys.CreateOperator()
+	require.NoError(t, err)
+	accountKey, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	otherOperatorKey, err := nkeys.CreateOperator()
+	require.NoError(t, err)
+
+	encode := func(t *testing.T, claims jwt.Claims, kp nkeys.KeyPair) string {
+		token, err := claims.Encode(kp)
+		require.NoError(t, err)
+		return token
+	}
+	publicKey := func(t *testing.T, kp nkeys.KeyPair) string {
+		pk, err := kp.PublicKey()
+		require.NoError(t, err)
+		return pk
+	}
+
+	operatorClaims := jwt.NewOperatorClaims(publicKey(t, operatorKey))
+	operatorClaims.Name = "op1"
+	operatorJWT := encode(t, operatorClaims, operatorKey)
+	accountClaims := jwt.NewAccountClaims(publicKey(t, accountKey))
+	accountClaims.Name = "ac1"
+	accountJWT := encode(t, accountClaims, operatorKey)
+
+	creds := func(t *testing.T, expires int64, revoke bool) (string, []byte) {
+		userKey, err := nkeys.CreateUser()
+		require.NoError(t, err)
+		userClaims := jwt.NewUserClaims(publicKey(t, userKey))
+		userClaims.Name = "us1"
+		userClaims.IssuedAt = time.Now().Add(-time.Minute).Unix()
+		userClaims.Expires = expires
+		userJWT := encode(t, userClaims, accountKey)
+		seed, err := userKey.Seed()
+		require.NoError(t, err)
+		contents, err := jwt.FormatUserConfig(userJWT, seed)
+		require.NoError(t, err)
+
+		account := accountJWT
+		if revoke {
+			revoked := jwt.NewAccountClaims(publicKey(t, accountKey))
+			revoked.Name = "ac1"
+			revoked.Revoke(userClaims.Subject)
+			account = encode(t, revoked, operatorKey)
+		}
+		return account, contents
+	}
+
+	t.Run("valid creds", func(t *testing.T) {
+		account, contents := creds(t, 0, false)
+		result, err := verifyCredentials(operatorJWT, account, contents)
+		require.NoError(t, err)
+		assert.Equal(t, jwt.UserClaim, result.kind)
+		assert.Equal(t, "us1", result.name)
+		assert.Empty(t, result.problems)
+	})
+
+	t.Run("valid account", func(t *testing.T) {
+		result, err := verifyCredentials(operatorJWT, "", []byte(accountJWT))
+		require.NoError(t, err)
+		assert.Equal(t, jwt.AccountClaim, result.kind)
+		assert.Empty(t, result.problems)
+	})
+
+	t.Run("account of another operator", func(t *testing.T) {
+		otherClaims := jwt.NewOperatorClaims(publicKey(t, otherOperatorKey))
+		result, err := verifyCredentials(encode(t, otherClaims, otherOperatorKey), "", []byte(accountJWT))
+		require.NoError(t, err)
+		assert.Len(t, result.problems, 1)
+	})
+
+	t.Run("expired creds", func(t *testing.T) {
+		account, contents := creds(t, time.Now().Add(-time.Second).Unix(), false)
+		result, err := verifyCredentials(operatorJWT, account, contents)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"claim is expired"}, result.problems)
+	})
+
+	t.Run("revoked creds", func(t *testing.T) {
+		account, contents := creds(t, 0, true)
+		result, err := verifyCredentials(operatorJWT, account, contents)
+		require.NoError(t, err)
+		assert.Len(t, result.problems, 1)
+	})
+
+	t.Run("seed of another user", func(t *testing.T) {
+		account, contents := creds(t, 0, false)
+		_, other := creds(t, 0, false)
+		userJWT, err := jwt.ParseDecoratedJWT(contents)
+		require.NoError(t, err)
+		kp, err := jwt.ParseDecoratedUserNKey(other)
+		require.NoError(t, err)
+		seed, err := kp.Seed()
+		require.NoError(t, err)
+		mixed, err := jwt.FormatUserConfig(userJWT, seed)
+		require.NoError(t, err)
+
+		result, err := verifyCredentials(operatorJWT, account, mixed)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"seed does not belong to the user"}, result.problems)
+	})
+
+	t.Run("user without account", func(t *testing.T) {
+		_, contents := creds(t, 0, false)
+		_, err := verifyCredentials(operatorJWT, "", contents)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid jwt", func(t *testing.T) {
+		_, err := verifyCredentials(operatorJWT, accountJWT, []byte("garbage"))
+		assert.Error(t, err)
+	})
+}
@@ -1,5 +1,13 @@
 package natsbackend
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
 const (
 
 	// Parameters
@@ -14,11 +22,12 @@ const (
 	ListIssuesFailedError   = "listing issues failed"
 
 	// JWT
-	AddingJWTFailedError  = "adding jwt failed"
-	ReadingJWTFailedError = "reading jwt failed"
-	ListJWTsFailedError   = "listing jwts failed"
-	DeleteJWTFailedError  = "deleting jwt failed"
-	JwtNotFoundError      = "jwt not found"
+	AddingJWTFailedError   = "adding jwt failed"
+	ReadingJWTFailedError  = "reading jwt failed"
+	ListJWTsFailedError    = "listing jwts failed"
+	DeleteJWTFailedError   = "deleting jwt failed"
+	JwtNotFoundError       = "jwt not found"
+	AdoptingJWTFailedError = "adopting jwt failed"
 
 	// NKEY
 	AddingNkeyFailedError  = "adding nkey failed"
@@ -34,6 +43,38 @@ const (
 	DeleteCredsFailedError  = "deleting creds failed"
 	CredsNotFoundError      = "creds not found"
 
+	// ACTIVATION
+	AddingActivationFailedError   = "issuing activation token failed"
+	RevokingActivationFailedError = "revoking activation token failed"
+
+	// CONFIG
+	ReadingConfigFailedError = "reading config failed"
+	WritingConfigFailedError = "writing config failed"
+
+	// TOMBSTONE
+	ReadingTombstoneFailedError = "reading tombstone failed"
+	ListTombstonesFailedError   = "listing tombstones failed"
+	DeleteTombstoneFailedError  = "deleting tombstone failed"
+	RestoreTombstoneFailedError = "restoring tombstone failed"
+	TombstoneNotFoundError      = "tombstone not found"
+
+	// IMPORT
+	ImportFailedError = "importing nsc store failed"
+	ExportFailedError = "exporting nsc store failed"
+
+	// BACKUP
+	BackupFailedError  = "backup failed"
+	RestoreFailedError = "restoring backup failed"
+
+	// APPLY
+	ApplyFailedError = "applying desired state failed"
+
+	// SCHEMA
+	ReadingSchemaFailedError = "reading schema failed"
+
+	// DOCTOR
+	DoctorFailedError = "checking storage failed"
+
 	// // Operator Errors
 	// OperatorNotConfiguredError      = "operator not configured"
 	// OperatorMissingError            = "missing operator"
@@ -60,3 +101,169 @@ const (
 	// JwtTokenHasWrongClaimTypeError = "token has wrong claim type"
 	// JwtExistenceCheckError         = "existence check failed"
 )
+
+// errorKind describes the stable code and the HTTP status of an error message
+type errorKind struct {
+	code   string
+	status int
+}
+
+var errorKinds = map[string]errorKind{
+	InvalidParametersError: {"invalid_parameters", http.StatusBadRequest},
+	DecodeFailedError:      {"decode_failed", http.StatusBadRequest},
+
+	AddingIssueFailedError:  {"adding_issue_failed", http.StatusInternalServerError},
+	ReadingIssueFailedError: {"reading_issue_failed", http.StatusInternalServerError},
+	IssueNotFoundError:      {"issue_not_found", http.StatusNotFound},
+	DeleteIssueFailedError:  {"deleting_issue_failed", http.StatusInternalServerError},
+	ListIssuesFailedError:   {"listing_issues_failed", http.StatusInternalServerError},
+
+	AddingJWTFailedError:   {"adding_jwt_failed", http.StatusInternalServerError},
+	ReadingJWTFailedError:  {"reading_jwt_failed", http.StatusInternalServerError},
+	ListJWTsFailedError:    {"listing_jwts_failed", http.StatusInternalServerError},
+	DeleteJWTFailedError:   {"deleting_jwt_failed", http.StatusInternalServerError},
+	JwtNotFoundError:       {"jwt_not_found", http.StatusNotFound},
+	AdoptingJWTFailedError: {"adopting_jwt_failed", http.StatusInternalServerError},
+
+	AddingNkeyFailedError:  {"adding_nkey_failed", http.StatusInternalServerError},
+	ReadingNkeyFailedError: {"reading_nkey_failed", http.StatusInternalServerError},
+	ListNkeysFailedError:   {"listing_nkeys_failed", http.StatusInternalServerError},
+	DeleteNkeyFailedError:  {"deleting_nkey_failed", http.StatusInternalServerError},
+	NkeyNotFoundError:      {"nkey_not_found", http.StatusNotFound},
+
+	AddingCredsFailedError:  {"adding_creds_failed", http.StatusInternalServerError},
+	ReadingCredsFailedError: {"reading_creds_failed", http.StatusInternalServerError},
+	ListCredsFailedError:    {"listing_creds_failed", http.StatusInternalServerError},
+	DeleteCredsFailedError:  {"deleting_creds_failed", http.StatusInternalServerError},
+	CredsNotFoundError:      {"creds_not_found", http.StatusNotFound},
+
+	AddingActivationFailedError:   {"issuing_activation_failed", http.StatusInternalServerError},
+	RevokingActivationFailedError: {"revoking_activation_failed", http.StatusInternalServerError},
+
+	ReadingConfigFailedError: {"reading_config_failed", http.StatusInternalServerError},
+	WritingConfigFailedError: {"writing_config_failed", http.StatusInternalServerError},
+
+	ReadingTombstoneFailedError: {"reading_tombstone_failed", http.StatusInternalServerError},
+	ListTombstonesFailedError:   {"listing_tombstones_failed", http.StatusInternalServerError},
+	DeleteTombstoneFailedError:  {"deleting_tombstone_failed", http.StatusInternalServerError},
+	RestoreTombstoneFailedError: {"restoring_tombstone_failed", http.StatusInternalServerError},
+	TombstoneNotFoundError:      {"tombstone_not_found", http.StatusNotFound},
+
+	ImportFailedError: {"import_failed", http.StatusInternalServerError},
+	ExportFailedError: {"export_failed", http.StatusInternalServerError},
+
+	BackupFailedError:  {"backup_failed", http.StatusInternalServerError},
+	RestoreFailedError: {"restore_failed", http.StatusInternalServerError},
+
+	ApplyFailedError: {"apply_failed", http.StatusInternalServerError},
+
+	ReadingSchemaFailedError: {"reading_schema_failed", http.StatusInternalServerError},
+
+	DoctorFailedError: {"doctor_failed", http.StatusInternalServerError},
+}
+
+// Error is the error returned by the handlers. It carries a stable code,
+// the human readable message and the wrapped cause.
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+	Cause   error
+}
+
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Message, e.Cause.Error())
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// newError creates an error for one of the messages above. The status
+// of the message is overridden by the status of a classified cause.
+func newError(message string, cause error) *Error {
+	kind, ok := errorKinds[message]
+	if !ok {
+		kind = errorKind{"internal", http.StatusInternalServerError}
+	}
+	var se *statusError
+	if errors.As(cause, &se) {
+		kind.status = se.status
+	}
+	return &Error{
+		Code:    kind.code,
+		Message: message,
+		Status:  kind.status,
+		Cause:   cause,
+	}
+}
+
+// errorResponse returns the error as response and as logical.CodedError
+// so that vault responds with the HTTP status of the error. The code of the
+// error is set next to the message.
+func errorResponse(message string, cause error) (*logical.Response, error) {
+	e := newError(message, cause)
+	resp := logical.ErrorResponse(e.Error())
+	resp.Data["code"] = e.Code
+	// vault only passes the "data" of error responses to HTTP clients
+	resp.Data["data"] = map[string]interface{}{
+		"code": e.Code,
+	}
+	return resp, logical.CodedError(e.Status, e.Error())
+}
+
+// statusError classifies the cause of an error by the HTTP status it maps to
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// errNotFound classifies an error caused by a missing resource
+func errNotFound(format string, args ...interface{}) error {
+	return &statusError{http.StatusNotFound, fmt.Errorf(format, args...)}
+}
+
+// errInvalid classifies an error caused by invalid input
+func errInvalid(format string, args ...interface{}) error {
+	return &statusError{http.StatusBadRequest, fmt.Errorf(format, args...)}
+}
+
+// errConflict classifies an error caused by a conflict with the stored state
+func errConflict(format string, args ...interface{}) error {
+	return &statusError{http.StatusConflict, fmt.Errorf(format, args...)}
+}
+
+// pendingError marks a write that failed on a missing issuer, e.g. an
+// account issued before its operator. The issue is kept and completed by
+// the write of the issuer instead of being rolled back.
+type pendingError struct {
+	err error
+}
+
+func (e *pendingError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pendingError) Unwrap() error {
+	return e.err
+}
+
+func errPending(err error) error {
+	return &pendingError{err}
+}
+
+func isPending(err error) bool {
+	var pe *pendingError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,98 @@
+package natsbackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertErrorStatus asserts that err is a coded error with the HTTP status
+func assertErrorStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	var coded logical.HTTPCodedError
+	if assert.True(t, errors.As(err, &coded), "expected coded error, got %v", err) {
+		assert.Equal(t, status, coded.Code(), "%v", err)
+	}
+}
+
+// isErrorResponse reports whether resp is an error response. Responses of
+// errorResponse carry the code next to the error, so resp.IsError is false.
+func isErrorResponse(resp *logical.Response) bool {
+	return resp != nil && resp.Data["error"] != nil
+}
+
+// errorMessage returns the message of the error response
+func errorMessage(resp *logical.Response) string {
+	message, _ := resp.Data["error"].(string)
+	return message
+}
+
+func TestNewError(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		cause   error
+		code    string
+		status  int
+	}{
+		{InvalidParametersError, fmt.Errorf("bad"), "invalid_parameters", http.StatusBadRequest},
+		{IssueNotFoundError, nil, "issue_not_found", http.StatusNotFound},
+		{AddingIssueFailedError, fmt.Errorf("storage failure"), "adding_issue_failed", http.StatusInternalServerError},
+		{AddingIssueFailedError, errNotFound("operator nkey does not exist"), "adding_issue_failed", http.StatusNotFound},
+		{AddingIssueFailedError, fmt.Errorf("wrapped: %w", errInvalid("bad claims")), "adding_issue_failed", http.StatusBadRequest},
+		{AddingIssueFailedError, errConflict("conflict"), "adding_issue_failed", http.StatusConflict},
+	} {
+		e := newError(tc.message, tc.cause)
+		assert.Equal(t, tc.code, e.Code)
+		assert.Equal(t, tc.status, e.Status)
+		assert.Equal(t, tc.message, e.Message)
+		if tc.cause != nil {
+			assert.ErrorIs(t, e, tc.cause)
+			assert.Equal(t, tc.message+": "+tc.cause.Error(), e.Error())
+		}
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	// missing resources map to 404
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+	})
+	assertErrorStatus(t, err, http.StatusNotFound)
+	assert.True(t, isErrorResponse(resp))
+	assert.Equal(t, IssueNotFoundError, errorMessage(resp))
+	assert.Equal(t, "issue_not_found", resp.Data["code"])
+
+	// the cause of a failure is returned
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "activation/operator/op1/account/ac1",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"target":  "ac2",
+			"subject": "foo",
+		},
+	})
+	assertErrorStatus(t, err, http.StatusNotFound)
+	assert.Equal(t, AddingActivationFailedError+": account issue does not exist: ac1", errorMessage(resp))
+
+	// invalid input maps to 400
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1/link/l1",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"exporter": "ac1",
+		},
+	})
+	assertErrorStatus(t, err, http.StatusBadRequest)
+	assert.True(t, isErrorResponse(resp))
+}
@@ -0,0 +1,174 @@
+//go:build generate
+
+// schemagen writes the JSON Schemas of the claims types from their Go types
+// and kubebuilder validation markers, using the schema parser of
+// controller-tools.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/controller-tools/pkg/crd"
+	crdmarkers "sigs.k8s.io/controller-tools/pkg/crd/markers"
+	"sigs.k8s.io/controller-tools/pkg/loader"
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+const module = "github.com/edgefarm/vault-plugin-secrets-nats"
+
+var claims = []struct {
+	kind     string
+	pkg      string
+	typeName string
+}{
+	{kind: "operator", pkg: module + "/pkg/claims/operator/v1alpha1", typeName: "OperatorClaims"},
+	{kind: "account", pkg: module + "/pkg/claims/account/v1alpha1", typeName: "AccountClaims"},
+	{kind: "user", pkg: module + "/pkg/claims/user/v1alpha1", typeName: "UserClaims"},
+}
+
+func main() {
+	out := flag.String("out", "pkg/claims/schema", "directory the schemas are written to")
+	flag.Parse()
+
+	err := generate(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(out string) error {
+	registry := &markers.Registry{}
+	err := crdmarkers.Register(registry)
+	if err != nil {
+		return err
+	}
+	parser := &crd.Parser{
+		Collector: &markers.Collector{Registry: registry},
+		Checker:   &loader.TypeChecker{},
+	}
+	crd.AddKnownTypes(parser)
+
+	var roots []string
+	for _, c := range claims {
+		roots = append(roots, c.pkg)
+	}
+	pkgs, err := loader.LoadRoots(roots...)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range claims {
+		var pkg *loader.Package
+		for _, p := range pkgs {
+			if p.PkgPath == c.pkg {
+				pkg = p
+			}
+		}
+		if pkg == nil {
+			return fmt.Errorf("package %s not loaded", c.pkg)
+		}
+
+		ident := crd.TypeIdent{Package: pkg, Name: c.typeName}
+		parser.NeedFlattenedSchemaFor(ident)
+		// like controller-gen, type errors outside of the types don't matter
+		if loader.PrintErrors(pkgs, packages.TypeError) {
+			return fmt.Errorf("cannot generate schema of %s", ident)
+		}
+
+		props := parser.FlattenedSchemata[ident]
+		schema := toJSONSchema(&props)
+		schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+		schema["$id"] = fmt.Sprintf("https://github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema/%s.schema.json", c.kind)
+		schema["title"] = c.typeName
+
+		data, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(out, c.kind+".schema.json"), append(data, '\n'), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toJSONSchema converts the OpenAPI schema of controller-tools into JSON
+// Schema: nullable becomes a null type and the kubernetes extensions are
+// dropped.
+func toJSONSchema(props *apiext.JSONSchemaProps) map[string]interface{} {
+	// markers of slice fields describe the slice, but enums and patterns are
+	// meant for the items, e.g. allowedConnectionTypes
+	if props.Type == "array" && props.Items != nil && props.Items.Schema != nil {
+		items := props.Items.Schema.DeepCopy()
+		if len(props.Enum) > 0 {
+			items.Enum, props.Enum = props.Enum, nil
+		}
+		if props.Pattern != "" {
+			items.Pattern, props.Pattern = props.Pattern, ""
+		}
+		props.Items = &apiext.JSONSchemaPropsOrArray{Schema: items}
+	}
+
+	schema := map[string]interface{}{}
+	if props.Description != "" {
+		schema["description"] = props.Description
+	}
+	if props.Type != "" {
+		if props.Nullable {
+			schema["type"] = []string{props.Type, "null"}
+		} else {
+			schema["type"] = props.Type
+		}
+	}
+	if props.Format != "" {
+		schema["format"] = props.Format
+	}
+	if props.Pattern != "" {
+		schema["pattern"] = props.Pattern
+	}
+	if len(props.Enum) > 0 {
+		var enum []json.RawMessage
+		for _, e := range props.Enum {
+			enum = append(enum, e.Raw)
+		}
+		schema["enum"] = enum
+	}
+	if props.Default != nil {
+		schema["default"] = json.RawMessage(props.Default.Raw)
+	}
+	if props.Minimum != nil {
+		schema["minimum"] = *props.Minimum
+	}
+	if props.Maximum != nil {
+		schema["maximum"] = *props.Maximum
+	}
+	if len(props.Required) > 0 {
+		schema["required"] = props.Required
+	}
+	if props.Items != nil && props.Items.Schema != nil {
+		schema["items"] = toJSONSchema(props.Items.Schema)
+	}
+	if len(props.Properties) > 0 {
+		properties := map[string]interface{}{}
+		for name, p := range props.Properties {
+			p := p
+			properties[name] = toJSONSchema(&p)
+		}
+		schema["properties"] = properties
+		if props.AdditionalProperties == nil {
+			schema["additionalProperties"] = false
+		}
+	}
+	if props.AdditionalProperties != nil && props.AdditionalProperties.Schema != nil {
+		schema["additionalProperties"] = toJSONSchema(props.AdditionalProperties.Schema)
+	}
+	return schema
+}
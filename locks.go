@@ -0,0 +1,95 @@
+package natsbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Issues are mutated read-modify-write and changes cascade: an operator
+// refreshes all of its accounts, an account its users and the accounts
+// importing from it. Mutations are therefore serialized with two levels of
+// keyed locks taken by the path handlers:
+//
+//   - lockOperator locks all issues of an operator exclusively. It is used
+//     by mutations that can touch more than one account.
+//   - lockAccount locks the issues of a single account and its users. The
+//     operator is locked shared, so mutations of different accounts of the
+//     same operator run concurrently.
+//
+// The locks are not reentrant. Only handlers and the periodic function take
+// them, the functions they call must not.
+
+// lockOperator locks all issues of the operator and returns the unlock function
+func (b *NatsBackend) lockOperator(operator string) func() {
+	lock := locksutil.LockForKey(b.operatorLocks, operator)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// lockOperators locks all issues of the operators and returns the unlock
+// function. The locks are taken in a fixed order, so concurrent calls can't
+// deadlock.
+func (b *NatsBackend) lockOperators(operators []string) func() {
+	locks := locksutil.LocksForKeys(b.operatorLocks, operators)
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// lockAccount locks the issues of the account and its users and returns the unlock function
+func (b *NatsBackend) lockAccount(operator string, account string) func() {
+	operatorLock := locksutil.LockForKey(b.operatorLocks, operator)
+	operatorLock.RLock()
+	accountLock := locksutil.LockForKey(b.accountLocks, operator+"/"+account)
+	accountLock.Lock()
+	return func() {
+		accountLock.Unlock()
+		operatorLock.RUnlock()
+	}
+}
+
+// lockAccountIssue locks the account for updates of an existing account.
+// Creating an account issues its nkey, which refreshes the operator and the
+// importing accounts, so the whole operator is locked instead.
+func (b *NatsBackend) lockAccountIssue(ctx context.Context, storage logical.Storage, operator string, account string) (func(), error) {
+	unlock := b.lockAccount(operator, account)
+
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	nkey, err := readAccountNkey(ctx, storage, NkeyParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if issue != nil && nkey != nil {
+		return unlock, nil
+	}
+
+	unlock()
+	return b.lockOperator(operator), nil
+}
+
+// lockUserIssue locks the account of the user. Writing the push user of the
+// system account pushes all accounts, so the whole operator is locked instead.
+func (b *NatsBackend) lockUserIssue(operator string, account string, user string) func() {
+	if account == DefaultSysAccountName && user == DefaultPushUser {
+		return b.lockOperator(operator)
+	}
+	return b.lockAccount(operator, account)
+}
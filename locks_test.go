@@ -0,0 +1,157 @@
+package natsbackend
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// yieldingStorage yields to other goroutines on every storage access
+// to interleave concurrent requests even on a single cpu
+type yieldingStorage struct {
+	logical.Storage
+}
+
+func (s *yieldingStorage) Get(ctx context.Context, key string) (*logical.StorageEntry, error) {
+	runtime.Gosched()
+	return s.Storage.Get(ctx, key)
+}
+
+func (s *yieldingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	runtime.Gosched()
+	return s.Storage.Put(ctx, entry)
+}
+
+func TestConcurrentIssueWrites(t *testing.T) {
+	b, storage := getTestBackend(t)
+	reqStorage := &yieldingStorage{Storage: storage}
+
+	for _, path := range []string{
+		"issue/operator/op1",
+		"issue/operator/op1/account/ac1",
+		"issue/operator/op1/account/ac2",
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+
+	const users = 10
+	for i := 0; i < users; i++ {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      fmt.Sprintf("issue/operator/op1/account/ac1/user/us%d", i),
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+
+	userPublicKeys := []string{}
+	for i := 0; i < users; i++ {
+		nkey, err := readUserNkey(context.Background(), reqStorage, NkeyParameters{
+			Operator: "op1",
+			Account:  "ac1",
+			User:     fmt.Sprintf("us%d", i),
+		})
+		require.NoError(t, err)
+		data, err := toNkeyData(nkey)
+		require.NoError(t, err)
+		userPublicKeys = append(userPublicKeys, data.PublicKey)
+	}
+
+	// delete all users of ac1, patch the limits of ac2 field by field
+	// and run the periodic refresh at the same time
+	limits := []string{"subs", "conn", "leafNodeConn", "payload", "data"}
+	var wg sync.WaitGroup
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.DeleteOperation,
+				Path:      fmt.Sprintf("issue/operator/op1/account/ac1/user/us%d", i),
+				Storage:   reqStorage,
+			})
+			assert.NoError(t, err)
+			assert.False(t, resp.IsError())
+		}(i)
+	}
+	for i, limit := range limits {
+		wg.Add(1)
+		go func(i int, limit string) {
+			defer wg.Done()
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.PatchOperation,
+				Path:      "issue/operator/op1/account/ac2",
+				Storage:   reqStorage,
+				Data: map[string]interface{}{
+					"claims": map[string]interface{}{
+						"account": map[string]interface{}{
+							"limits": map[string]interface{}{
+								limit: i + 1,
+							},
+						},
+					},
+				},
+			})
+			assert.NoError(t, err)
+			assert.False(t, resp.IsError())
+		}(i, limit)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage})
+		assert.NoError(t, err)
+	}()
+	wg.Wait()
+
+	// no revocation got lost
+	ac1, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+		Operator: "op1",
+		Account:  "ac1",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ac1)
+	assert.Len(t, ac1.Claims.Revocations, users)
+	for _, pub := range userPublicKeys {
+		assert.Contains(t, ac1.Claims.Revocations, pub)
+	}
+
+	accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+		Operator: "op1",
+		Account:  "ac1",
+	})
+	require.NoError(t, err)
+	claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+	require.NoError(t, err)
+	assert.Len(t, claims.Revocations, users)
+
+	// no patch got lost
+	ac2, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+		Operator: "op1",
+		Account:  "ac2",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, ac2)
+	assert.Equal(t, int64(len(limits)+1), ac2.Version)
+	assert.Equal(t, int64(1), ac2.Claims.Account.Limits.Subs)
+	assert.Equal(t, int64(2), ac2.Claims.Account.Limits.Conn)
+	assert.Equal(t, int64(3), ac2.Claims.Account.Limits.LeafNodeConn)
+	assert.Equal(t, int64(4), ac2.Claims.Account.Limits.Payload)
+	assert.Equal(t, int64(5), ac2.Claims.Account.Limits.Data)
+}
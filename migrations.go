@@ -0,0 +1,235 @@
+package natsbackend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	storageVersionPath = "storage/version"
+	// storageVersion is the version of the storage layout written by this
+	// plugin. Raising it needs a migration in storageMigrations.
+	storageVersion = 2
+)
+
+// StorageVersion marks the version of the storage layout of the mount.
+// Mounts without the marker have version 0.
+type StorageVersion struct {
+	Version int `json:"version"`
+}
+
+// storageMigration upgrades the storage layout from version-1 to version.
+// Migrations must be idempotent: an interrupted migration runs again.
+type storageMigration struct {
+	version     int
+	description string
+	migrate     func(ctx context.Context, storage logical.Storage) error
+}
+
+// storageMigrations are the upgrade steps ordered by version
+var storageMigrations = []storageMigration{
+	{
+		version:     1,
+		description: "delete the user creds and jwts stored before they were generated on demand",
+		migrate:     deleteStoredUserCreds,
+	},
+	{
+		version:     2,
+		description: "store the entries of tombstones one by one",
+		migrate:     splitTombstoneEntries,
+	},
+}
+
+// tombstoneStorageV1 is a tombstone of storage version 1 and older keeping
+// all removed entries in a single storage entry
+type tombstoneStorageV1 struct {
+	TombstoneStorage
+	Entries map[string][]byte `json:"entries,omitempty"`
+}
+
+// initialize migrates the storage of the mount to the current version
+func (b *NatsBackend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	// only the active node of the primary cluster writes to the storage
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationPerformanceStandby | consts.ReplicationDRSecondary) {
+		return nil
+	}
+
+	current, err := readStorageVersion(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if current == storageVersion {
+		return nil
+	}
+
+	// migrations may rewrite the entries of any operator
+	keys, err := listMountKeys(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	defer b.lockOperators(backupOperators(keys))()
+
+	err = migrateStorage(ctx, req.Storage)
+	if err != nil {
+		log.Error().Err(err).Msg("storage migration failed")
+	}
+	return err
+}
+
+// migrateStorage runs the migrations the storage misses. The caller must
+// hold the locks of all operators.
+func migrateStorage(ctx context.Context, storage logical.Storage) error {
+	current, err := readStorageVersion(ctx, storage)
+	if err != nil {
+		return err
+	}
+	if current > storageVersion {
+		return fmt.Errorf("storage version %d is newer than the supported version %d", current, storageVersion)
+	}
+
+	for _, migration := range storageMigrations {
+		if migration.version <= current {
+			continue
+		}
+		log.Info().Int("from", current).Int("to", migration.version).Msgf("migrate storage: %s", migration.description)
+		err = migration.migrate(ctx, storage)
+		if err != nil {
+			return fmt.Errorf("migrating storage to version %d failed: %w", migration.version, err)
+		}
+		err = storeInStorage(ctx, storage, storageVersionPath, &StorageVersion{Version: migration.version})
+		if err != nil {
+			return err
+		}
+		current = migration.version
+	}
+	if current < storageVersion {
+		return storeInStorage(ctx, storage, storageVersionPath, &StorageVersion{Version: storageVersion})
+	}
+	return nil
+}
+
+// migrateEntries migrates entries of an older storage version, e.g. of a
+// backup, in memory and returns the migrated entries
+func migrateEntries(ctx context.Context, entries map[string][]byte) (map[string][]byte, error) {
+	storage := new(logical.InmemStorage)
+	for key, value := range entries {
+		err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: value})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := migrateStorage(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	return readMountEntries(ctx, storage)
+}
+
+func readStorageVersion(ctx context.Context, storage logical.Storage) (int, error) {
+	version, err := getFromStorage[StorageVersion](ctx, storage, storageVersionPath)
+	if err != nil {
+		return 0, err
+	}
+	if version == nil {
+		return 0, nil
+	}
+	return version.Version, nil
+}
+
+// deleteStoredUserCreds deletes the user creds and jwts stored before they
+// were generated on demand, also from tombstones
+func deleteStoredUserCreds(ctx context.Context, storage logical.Storage) error {
+	for _, prefix := range []string{"creds/", "jwt/"} {
+		keys, err := logical.CollectKeysWithPrefix(ctx, storage, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if !isStoredUserCredsKey(key) {
+				continue
+			}
+			err = deleteFromStorage(ctx, storage, key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	keys, err := logical.CollectKeysWithPrefix(ctx, storage, "tombstone/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		tombstone, err := getFromStorage[tombstoneStorageV1](ctx, storage, key)
+		if err != nil {
+			return err
+		}
+		if tombstone == nil {
+			continue
+		}
+		changed := false
+		for entry := range tombstone.Entries {
+			if isStoredUserCredsKey(entry) {
+				delete(tombstone.Entries, entry)
+				changed = true
+			}
+		}
+		if changed {
+			err = storeInStorage(ctx, storage, key, tombstone)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isStoredUserCredsKey matches creds/... and jwt/operator/<operator>/account/<account>/user/<user>
+func isStoredUserCredsKey(key string) bool {
+	if strings.HasPrefix(key, "creds/") {
+		return true
+	}
+	parts := strings.Split(key, "/")
+	return len(parts) == 7 && parts[0] == "jwt" && parts[1] == "operator" && parts[3] == "account" && parts[5] == "user"
+}
+
+// splitTombstoneEntries moves the entries of each tombstone below it, see
+// getTombstoneEntryPath. The tombstone is stored without them last, so an
+// interrupted migration moves them again.
+func splitTombstoneEntries(ctx context.Context, storage logical.Storage) error {
+	keys, err := logical.CollectKeysWithPrefix(ctx, storage, "tombstone/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if strings.Contains(key, "/entries/") {
+			continue
+		}
+		tombstone, err := getFromStorage[tombstoneStorageV1](ctx, storage, key)
+		if err != nil {
+			return err
+		}
+		if tombstone == nil || tombstone.Entries == nil {
+			continue
+		}
+		for entry, value := range tombstone.Entries {
+			err = storage.Put(ctx, &logical.StorageEntry{
+				Key:   getTombstoneEntryPath(tombstone.Operator, tombstone.Account, entry),
+				Value: value,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = storeInStorage(ctx, storage, key, &tombstone.TombstoneStorage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/backup"
+)
+
+func TestStorageMigrations(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("migrations are consecutive", func(t *testing.T) {
+		for i, migration := range storageMigrations {
+			assert.Equal(t, i+1, migration.version)
+		}
+		assert.Equal(t, storageVersion, storageMigrations[len(storageMigrations)-1].version)
+	})
+
+	t.Run("new mounts are current", func(t *testing.T) {
+		_, reqStorage := getTestBackend(t)
+		version, err := readStorageVersion(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.Equal(t, storageVersion, version)
+	})
+
+	t.Run("unversioned mount is migrated", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		for _, path := range []string{
+			"issue/operator/op1",
+			"issue/operator/op1/account/ac1",
+			"issue/operator/op1/account/ac1/user/us1",
+		} {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      path,
+				Storage:   reqStorage,
+				Data:      map[string]interface{}{},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+
+		// entries left by versions storing user creds and jwts
+		for _, key := range []string{
+			"creds/operator/op1/account/ac1/user/us1",
+			"jwt/operator/op1/account/ac1/user/us1",
+		} {
+			require.NoError(t, reqStorage.Put(ctx, &logical.StorageEntry{Key: key, Value: []byte("{}")}))
+		}
+		require.NoError(t, storeInStorage(ctx, reqStorage, getTombstonePath("op2", ""), &tombstoneStorageV1{
+			TombstoneStorage: TombstoneStorage{Operator: "op2"},
+			Entries: map[string][]byte{
+				"issue/operator/op2":                      []byte("{}"),
+				"creds/operator/op2/account/ac1/user/us1": []byte("{}"),
+				"jwt/operator/op2/account/ac1/user/us1":   []byte("{}"),
+			},
+		}))
+		require.NoError(t, reqStorage.Delete(ctx, storageVersionPath))
+
+		require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: reqStorage}))
+
+		version, err := readStorageVersion(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.Equal(t, storageVersion, version)
+		keys, err := logical.CollectKeys(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.NotContains(t, keys, "creds/operator/op1/account/ac1/user/us1")
+		assert.NotContains(t, keys, "jwt/operator/op1/account/ac1/user/us1")
+		assert.Contains(t, keys, "jwt/operator/op1/account/ac1")
+		assert.Contains(t, keys, "nkey/operator/op1/account/ac1/user/us1")
+		tombstone, err := getFromStorage[tombstoneStorageV1](ctx, reqStorage, getTombstonePath("op2", ""))
+		require.NoError(t, err)
+		assert.Equal(t, "op2", tombstone.Operator)
+		assert.Nil(t, tombstone.Entries)
+		tombstoneKeys, err := listTombstoneKeys(ctx, reqStorage, TombstoneParameters{Operator: "op2"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"issue/operator/op2"}, tombstoneKeys)
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, resp.Data["creds"])
+	})
+
+	t.Run("newer storage is not touched", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		require.NoError(t, storeInStorage(ctx, reqStorage, storageVersionPath, &StorageVersion{Version: storageVersion + 1}))
+		require.NoError(t, reqStorage.Put(ctx, &logical.StorageEntry{Key: "creds/operator/op1/account/ac1/user/us1", Value: []byte("{}")}))
+
+		err := b.Initialize(ctx, &logical.InitializationRequest{Storage: reqStorage})
+		assert.Error(t, err)
+		entry, err := reqStorage.Get(ctx, "creds/operator/op1/account/ac1/user/us1")
+		require.NoError(t, err)
+		assert.NotNil(t, entry)
+	})
+
+	t.Run("restored backups are migrated", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		restore := func(t *testing.T, entries map[string][]byte, mode string) (*logical.Response, error) {
+			recipient, err := backup.Recipient("secret", "")
+			require.NoError(t, err)
+			sealed, err := backup.Seal(backup.New(entries), recipient)
+			require.NoError(t, err)
+			return b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "restore",
+				Storage:   reqStorage,
+				Data: map[string]interface{}{
+					"backup":     sealed,
+					"passphrase": "secret",
+					"mode":       mode,
+				},
+			})
+		}
+
+		for _, mode := range []string{restoreModeMerge, restoreModeReplace} {
+			resp, err := restore(t, map[string][]byte{
+				"creds/operator/op1/account/ac1/user/us1": []byte("{}"),
+				"jwt/operator/op1/account/ac1/user/us1":   []byte("{}"),
+			}, mode)
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+			assert.Empty(t, resp.Data["conflicts"], mode)
+			keys, err := logical.CollectKeys(ctx, reqStorage)
+			require.NoError(t, err)
+			assert.Equal(t, []string{storageVersionPath}, keys, mode)
+		}
+
+		resp, err := restore(t, map[string][]byte{
+			storageVersionPath: []byte(`{"version":99}`),
+		}, restoreModeReplace)
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
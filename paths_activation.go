@@ -0,0 +1,364 @@
+package natsbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+// ActivationParameters represents the parameters for issuing or revoking
+// an activation token for a private export of an account
+type ActivationParameters struct {
+	Operator      string `json:"operator"`
+	Account       string `json:"account"`
+	Target        string `json:"target"`
+	Subject       string `json:"subject"`
+	UseSigningKey string `json:"useSigningKey,omitempty"`
+	ExpirationS   int64  `json:"expirationS,omitempty"`
+}
+
+// ActivationData represents the data returned when an activation token is issued
+type ActivationData struct {
+	Operator        string `json:"operator"`
+	Account         string `json:"account"`
+	Target          string `json:"target"`
+	TargetPublicKey string `json:"targetPublicKey"`
+	Subject         string `json:"subject"`
+	Type            string `json:"type"`
+	JWT             string `json:"jwt"`
+	ExpiresAt       int64  `json:"expiresAt,omitempty"`
+}
+
+func pathActivation(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "activation/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"account": {
+					Type:        framework.TypeString,
+					Description: "exporting account identifier",
+					Required:    false,
+				},
+				"target": {
+					Type:        framework.TypeString,
+					Description: "Importing account, either by its name within the operator or by its public key",
+					Required:    true,
+				},
+				"subject": {
+					Type:        framework.TypeString,
+					Description: "Subject to activate. Must be contained in a private export of the account",
+					Required:    true,
+				},
+				"useSigningKey": {
+					Type:        framework.TypeString,
+					Description: "Account signing key to sign the activation token with",
+					Required:    false,
+				},
+				"expirationS": {
+					Type:        framework.TypeInt,
+					Description: "Activation token expiration time in seconds from now (0 = never expires)",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathIssueActivation,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathIssueActivation,
+				},
+			},
+			HelpSynopsis:    `Issues activation tokens for private exports of an account.`,
+			HelpDescription: `Generates an activation JWT that allows the target account to import a subject of an export that requires a token.`,
+		},
+		{
+			Pattern: "activation/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "/revoke$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"account": {
+					Type:        framework.TypeString,
+					Description: "exporting account identifier",
+					Required:    false,
+				},
+				"target": {
+					Type:        framework.TypeString,
+					Description: "Importing account, either by its name within the operator or by its public key",
+					Required:    true,
+				},
+				"subject": {
+					Type:        framework.TypeString,
+					Description: "Subject of the private export to revoke the activation for",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRevokeActivation,
+				},
+			},
+			HelpSynopsis:    `Revokes activation tokens for private exports of an account.`,
+			HelpDescription: `Adds the target account to the revocations of the matching export and pushes the re-signed account JWT.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathIssueActivation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	jsonString, err := json.Marshal(data.Raw)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	params := ActivationParameters{}
+	json.Unmarshal(jsonString, &params)
+
+	activation, err := issueActivation(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(AddingActivationFailedError, err)
+	}
+
+	return createResponseActivationData(activation)
+}
+
+func (b *NatsBackend) pathRevokeActivation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	jsonString, err := json.Marshal(data.Raw)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	params := ActivationParameters{}
+	json.Unmarshal(jsonString, &params)
+
+	defer b.lockAccount(params.Operator, params.Account)()
+
+	err = revokeActivation(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(RevokingActivationFailedError, err)
+	}
+	return nil, nil
+}
+
+func issueActivation(ctx context.Context, storage logical.Storage, params ActivationParameters) (*ActivationData, error) {
+	log.Info().
+		Str("operator", params.Operator).Str("account", params.Account).Str("target", params.Target).
+		Msgf("issue activation token")
+
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: params.Operator,
+		Account:  params.Account,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if issue == nil {
+		return nil, errNotFound("account issue does not exist: %s", params.Account)
+	}
+
+	export, err := findPrivateExport(issue, params.Subject)
+	if err != nil {
+		return nil, err
+	}
+	exportType, err := v1alpha1.ConvertExportType(export.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	targetPublicKey, err := resolveAccountPublicKey(ctx, storage, params.Operator, params.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	// use either the account nkey or one of its signing nkeys
+	// to sign the activation token
+	accountNkey, err := readAccountNkey(ctx, storage, NkeyParameters{
+		Operator: params.Operator,
+		Account:  params.Account,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read account nkey: %s", err)
+	}
+	if accountNkey == nil {
+		return nil, errNotFound("account nkey does not exist: %s", params.Account)
+	}
+	accountKeyPair, err := nkeys.FromSeed(accountNkey.Seed)
+	if err != nil {
+		return nil, err
+	}
+	accountPublicKey, err := accountKeyPair.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeyPair := accountKeyPair
+	if params.UseSigningKey != "" {
+		signingNkey, err := readAccountSigningNkey(ctx, storage, NkeyParameters{
+			Operator: params.Operator,
+			Account:  params.Account,
+			Signing:  params.UseSigningKey,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read signing nkey: %s", err)
+		}
+		if signingNkey == nil {
+			return nil, errNotFound("account signing nkey does not exist: %s", params.UseSigningKey)
+		}
+		signingKeyPair, err = nkeys.FromSeed(signingNkey.Seed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	claims := jwt.NewActivationClaims(targetPublicKey)
+	claims.Name = params.Subject
+	claims.ImportSubject = jwt.Subject(params.Subject)
+	claims.ImportType = exportType
+	if params.UseSigningKey != "" {
+		claims.IssuerAccount = accountPublicKey
+	}
+	var expiresAt int64
+	if params.ExpirationS > 0 {
+		expiresAt = time.Now().Add(time.Duration(params.ExpirationS) * time.Second).Unix()
+		claims.Expires = expiresAt
+	}
+
+	token, err := claims.Encode(signingKeyPair)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode activation jwt: %s", err)
+	}
+
+	log.Info().
+		Str("operator", params.Operator).Str("account", params.Account).Str("target", params.Target).
+		Int64("expiresAt", expiresAt).
+		Msg("activation token issued")
+
+	return &ActivationData{
+		Operator:        params.Operator,
+		Account:         params.Account,
+		Target:          params.Target,
+		TargetPublicKey: targetPublicKey,
+		Subject:         params.Subject,
+		Type:            export.Type,
+		JWT:             token,
+		ExpiresAt:       expiresAt,
+	}, nil
+}
+
+func revokeActivation(ctx context.Context, storage logical.Storage, params ActivationParameters) error {
+	log.Info().
+		Str("operator", params.Operator).Str("account", params.Account).Str("target", params.Target).
+		Msgf("revoke activation token")
+
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: params.Operator,
+		Account:  params.Account,
+	})
+	if err != nil {
+		return err
+	}
+	if issue == nil {
+		return errNotFound("account issue does not exist: %s", params.Account)
+	}
+
+	export, err := findPrivateExport(issue, params.Subject)
+	if err != nil {
+		return err
+	}
+
+	targetPublicKey, err := resolveAccountPublicKey(ctx, storage, params.Operator, params.Target)
+	if err != nil {
+		return err
+	}
+
+	// activations issued before now are rejected by the server
+	if export.Revocations == nil {
+		export.Revocations = map[string]int64{}
+	}
+	export.Revocations[targetPublicKey] = time.Now().Unix()
+
+	// reissue account jwt and push by refresing account
+	return refreshAccount(ctx, storage, issue)
+}
+
+// findPrivateExport returns the export of the account the subject is contained in.
+// The export must require an activation token.
+func findPrivateExport(issue *IssueAccountStorage, subject string) (*v1alpha1.Export, error) {
+	if subject == "" {
+		return nil, errInvalid("subject is required")
+	}
+	for i := range issue.Claims.Exports {
+		export := &issue.Claims.Exports[i]
+		if !jwt.Subject(subject).IsContainedIn(jwt.Subject(export.Subject)) {
+			continue
+		}
+		if !export.TokenReq {
+			return nil, errInvalid("export %q does not require an activation token", export.Subject)
+		}
+		return export, nil
+	}
+	return nil, errNotFound("no export found for subject %q", subject)
+}
+
+// resolveAccountPublicKey returns the public key of an account given either by
+// its name within the operator or by its public key.
+func resolveAccountPublicKey(ctx context.Context, storage logical.Storage, operator string, account string) (string, error) {
+	if account == "" {
+		return "", errInvalid("account is required")
+	}
+	if nkeys.IsValidPublicAccountKey(account) {
+		return account, nil
+	}
+	nkey, err := readAccountNkey(ctx, storage, NkeyParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not read account nkey: %s", err)
+	}
+	if nkey == nil {
+		return "", errNotFound("account nkey does not exist: %s", account)
+	}
+	kp, err := nkeys.FromSeed(nkey.Seed)
+	if err != nil {
+		return "", err
+	}
+	return kp.PublicKey()
+}
+
+func createResponseActivationData(activation *ActivationData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(activation, &rval)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: rval,
+	}
+	return resp, nil
+}
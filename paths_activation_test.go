@@ -0,0 +1,206 @@
+package natsbackend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+func TestActivation(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	// create operator, exporting and importing account
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1/account/exporter",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"claims": map[string]interface{}{
+				"account": map[string]interface{}{
+					"limits": map[string]interface{}{
+						"exports":         -1,
+						"wildcardExports": true,
+					},
+					"exports": []interface{}{
+						map[string]interface{}{
+							"name":     "private",
+							"subject":  "svc.>",
+							"type":     "Service",
+							"tokenReq": true,
+						},
+						map[string]interface{}{
+							"name":    "public",
+							"subject": "pub.>",
+							"type":    "Stream",
+						},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1/account/importer",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	exporterPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "exporter")
+	require.NoError(t, err)
+	importerPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "importer")
+	require.NoError(t, err)
+
+	t.Run("Issue activation token for private export", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "activation/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"target":      "importer",
+				"subject":     "svc.foo",
+				"expirationS": 3600,
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		var activation ActivationData
+		stm.MapToStruct(resp.Data, &activation)
+		assert.Equal(t, importerPub, activation.TargetPublicKey)
+		assert.Equal(t, "Service", activation.Type)
+		assert.Greater(t, activation.ExpiresAt, int64(0))
+
+		claims, err := jwt.DecodeActivationClaims(activation.JWT)
+		assert.NoError(t, err)
+		assert.Equal(t, importerPub, claims.Subject)
+		assert.Equal(t, exporterPub, claims.Issuer)
+		assert.Equal(t, jwt.Subject("svc.foo"), claims.ImportSubject)
+		assert.Equal(t, jwt.Service, claims.ImportType)
+		assert.Equal(t, activation.ExpiresAt, claims.Expires)
+
+		// the token can be used by the importing account
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/importer",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"limits": map[string]interface{}{
+							"imports": -1,
+						},
+						"imports": []interface{}{
+							map[string]interface{}{
+								"name":    "private",
+								"subject": "svc.foo",
+								"account": exporterPub,
+								"type":    "Service",
+								"token":   activation.JWT,
+							},
+						},
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+	})
+
+	t.Run("Issue activation token by public key", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "activation/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"target":  importerPub,
+				"subject": "svc.>",
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		claims, err := jwt.DecodeActivationClaims(resp.Data["jwt"].(string))
+		assert.NoError(t, err)
+		assert.Equal(t, importerPub, claims.Subject)
+		assert.Equal(t, int64(0), claims.Expires)
+	})
+
+	t.Run("Reject invalid activation requests", func(t *testing.T) {
+		for _, data := range []map[string]interface{}{
+			// export does not require a token
+			{"target": "importer", "subject": "pub.foo"},
+			// no matching export
+			{"target": "importer", "subject": "other.foo"},
+			// unknown target account
+			{"target": "unknown", "subject": "svc.foo"},
+			// unknown signing key
+			{"target": "importer", "subject": "svc.foo", "useSigningKey": "unknown"},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "activation/operator/op1/account/exporter",
+				Storage:   reqStorage,
+				Data:      data,
+			})
+			assert.Error(t, err)
+			assert.True(t, isErrorResponse(resp), "%v", data)
+		}
+	})
+
+	t.Run("Revoke activation token", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "activation/operator/op1/account/exporter/revoke",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"target":  "importer",
+				"subject": "svc.foo",
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		// revocation is stored in the issue ...
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+			Operator: "op1",
+			Account:  "exporter",
+		})
+		assert.NoError(t, err)
+		assert.Contains(t, issue.Claims.Exports[0].Revocations, importerPub)
+
+		// ... and part of the re-signed account jwt
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+			Operator: "op1",
+			Account:  "exporter",
+		})
+		assert.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		assert.NoError(t, err)
+		for _, export := range claims.Exports {
+			if export.Subject == "svc.>" {
+				assert.Contains(t, export.Revocations, importerPub)
+			} else {
+				assert.Empty(t, export.Revocations)
+			}
+		}
+	})
+}
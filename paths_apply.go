@@ -0,0 +1,624 @@
+package natsbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+const (
+	applyActionCreate = "create"
+	applyActionUpdate = "update"
+	applyActionDelete = "delete"
+
+	applyKindOperator = "operator"
+	applyKindAccount  = "account"
+	applyKindUser     = "user"
+	applyKindLink     = "link"
+)
+
+// ApplyOperatorParameters is the desired state of an operator: the
+// parameters of the operator issue together with all of its accounts, their
+// users and the links between the accounts. Accounts, users and links are
+// keyed by name and take the parameters of their issue paths.
+type ApplyOperatorParameters struct {
+	IssueOperatorParameters
+	Accounts map[string]ApplyAccountParameters `json:"accounts,omitempty"`
+	Links    map[string]IssueLinkParameters    `json:"links,omitempty"`
+	Plan     bool                              `json:"plan,omitempty"`
+}
+
+type ApplyAccountParameters struct {
+	IssueAccountParameters
+	Users map[string]IssueUserParameters `json:"users,omitempty"`
+}
+
+// ApplyChange is a create, update or delete of an issue
+type ApplyChange struct {
+	Action  string `json:"action"`
+	Kind    string `json:"kind"`
+	Account string `json:"account,omitempty"`
+	Name    string `json:"name"`
+}
+
+type ApplyData struct {
+	Operator string        `json:"operator"`
+	Plan     bool          `json:"plan"`
+	Changes  []ApplyChange `json:"changes"`
+}
+
+// applyStep is a planned change and the function applying it
+type applyStep struct {
+	change ApplyChange
+	apply  func(ctx context.Context, storage logical.Storage) error
+}
+
+func pathApply(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "apply/operator/" + framework.GenericNameRegex("operator") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"createSystemAccount": {
+					Type:        framework.TypeBool,
+					Description: "Create system account (default: false)",
+					Required:    false,
+				},
+				"claims": {
+					Type:        framework.TypeMap,
+					Description: "Operator claims (jwt.OperatorClaims from github.com/nats-io/jwt/v2)",
+					Required:    false,
+				},
+				"syncAccountServer": {
+					Type:        framework.TypeBool,
+					Description: "Sync account jwt's with account server",
+					Required:    false,
+				},
+				"deletionProtection": deletionProtectionField,
+				"accounts": {
+					Type:        framework.TypeMap,
+					Description: "Account issues by name, each with its users by name in users",
+					Required:    false,
+				},
+				"links": {
+					Type:        framework.TypeMap,
+					Description: "Links between the accounts by name",
+					Required:    false,
+				},
+				"plan": {
+					Type:        framework.TypeBool,
+					Description: "Only return the changes without applying them",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathApplyOperator,
+				},
+			},
+			HelpSynopsis:    `Applies the desired state of an operator.`,
+			HelpDescription: `Compares the operator issue, its accounts, users and links with the stored issues and creates, updates and deletes them in dependency order. With plan the changes are only returned.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathApplyOperator(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := ApplyOperatorParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	err = normalizeApplyParameters(&params)
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	steps, err := planApply(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ApplyFailedError, err)
+	}
+	changes := []ApplyChange{}
+	for _, step := range steps {
+		changes = append(changes, step.change)
+	}
+
+	if !params.Plan {
+		for i, step := range steps {
+			err = step.apply(ctx, req.Storage)
+			if err != nil {
+				return errorResponse(ApplyFailedError, fmt.Errorf("%s %s %s failed after %d of %d changes: %w",
+					step.change.Action, step.change.Kind, applyChangeName(step.change), i, len(steps), err))
+			}
+		}
+		log.Info().Str("operator", params.Operator).Int("changes", len(steps)).Msg("operator applied")
+	}
+
+	resp, err := createResponseApplyData(&ApplyData{
+		Operator: params.Operator,
+		Plan:     params.Plan,
+		Changes:  changes,
+	})
+	if err != nil {
+		return errorResponse(ApplyFailedError, err)
+	}
+	return resp, nil
+}
+
+// normalizeApplyParameters sets the names of the issues from the path and
+// their keys in the document and validates them
+func normalizeApplyParameters(params *ApplyOperatorParameters) error {
+	if params.CAS != nil {
+		return fmt.Errorf("cas is not supported by apply")
+	}
+	for name, account := range params.Accounts {
+		if !importNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid account name: %s", name)
+		}
+		if account.CAS != nil {
+			return fmt.Errorf("cas is not supported by apply")
+		}
+		account.Operator = params.Operator
+		account.Account = name
+		for userName, user := range account.Users {
+			if !importNameRegex.MatchString(userName) {
+				return fmt.Errorf("invalid user name: %s/%s", name, userName)
+			}
+			if user.CAS != nil {
+				return fmt.Errorf("cas is not supported by apply")
+			}
+			user.Operator = params.Operator
+			user.Account = name
+			user.User = userName
+			account.Users[userName] = user
+		}
+		params.Accounts[name] = account
+	}
+	for name, link := range params.Links {
+		if !importNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid link name: %s", name)
+		}
+		if link.CAS != nil {
+			return fmt.Errorf("cas is not supported by apply")
+		}
+		link.Operator = params.Operator
+		link.Link = name
+		link.Type = normalizeLinkType(link.Type)
+		params.Links[name] = link
+	}
+	return nil
+}
+
+// planApply compares the desired state with the stored issues and returns
+// the changes in the order they are applied:
+//
+//   - links that are removed, so they don't hold on to changed accounts
+//   - the operator, then the accounts, exporters before their importers
+//   - the users of the accounts
+//   - removed users, then removed accounts including their users
+//   - created and updated links
+//
+// The system account and its push user created by the operator are kept
+// unless they are part of the desired state.
+func planApply(ctx context.Context, storage logical.Storage, params ApplyOperatorParameters) ([]applyStep, error) {
+	operator := params.Operator
+	steps := []applyStep{}
+
+	currentLinks, err := listLinkIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedStrings(currentLinks) {
+		if _, ok := params.Links[name]; ok {
+			continue
+		}
+		link := IssueLinkParameters{Operator: operator, Link: name}
+		steps = append(steps, applyStep{
+			change: ApplyChange{Action: applyActionDelete, Kind: applyKindLink, Name: name},
+			apply: func(ctx context.Context, storage logical.Storage) error {
+				return removeLinkIssue(ctx, storage, link)
+			},
+		})
+	}
+
+	opIssue, err := readOperatorIssue(ctx, storage, params.IssueOperatorParameters)
+	if err != nil {
+		return nil, err
+	}
+	desiredOperator := params.IssueOperatorParameters
+	if opIssue == nil || !sameApplyParameters(&desiredOperator, &IssueOperatorParameters{
+		Operator:            opIssue.Operator,
+		CreateSystemAccount: opIssue.CreateSystemAccount,
+		SyncAccountServer:   opIssue.SyncAccountServer,
+		DeletionProtection:  opIssue.DeletionProtection,
+		Claims:              opIssue.Claims,
+	}) {
+		steps = append(steps, applyStep{
+			change: ApplyChange{Action: applyAction(opIssue != nil), Kind: applyKindOperator, Name: operator},
+			apply: func(ctx context.Context, storage logical.Storage) error {
+				return withIssueWAL(ctx, storage, &issueWALEntry{
+					Operation: walOperationWrite,
+					Operator:  operator,
+				}, func() error {
+					return addOperatorIssue(ctx, storage, desiredOperator)
+				})
+			},
+		})
+	}
+
+	accountNames := map[string]bool{}
+	for name := range params.Accounts {
+		accountNames[name] = true
+	}
+	imports := map[string][]string{}
+	for name, account := range params.Accounts {
+		for _, imp := range account.Claims.Imports {
+			if imp.AccountRef != "" {
+				imports[name] = append(imports[name], imp.AccountRef)
+			}
+		}
+	}
+	for _, name := range orderAccounts(accountNames, imports) {
+		desired := params.Accounts[name].IssueAccountParameters
+		issue, err := readAccountIssue(ctx, storage, desired)
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil && sameApplyParameters(&desired, &IssueAccountParameters{
+			Operator:           issue.Operator,
+			Account:            issue.Account,
+			UseSigningKey:      issue.UseSigningKey,
+			DeletionProtection: issue.DeletionProtection,
+			Claims:             unmanagedAccountClaims(issue.Claims),
+		}) {
+			continue
+		}
+		steps = append(steps, applyStep{
+			change: ApplyChange{Action: applyAction(issue != nil), Kind: applyKindAccount, Name: name},
+			apply: func(ctx context.Context, storage logical.Storage) error {
+				return withIssueWAL(ctx, storage, &issueWALEntry{
+					Operation: walOperationWrite,
+					Operator:  desired.Operator,
+					Account:   desired.Account,
+				}, func() error {
+					// the stored entries maintained by the backend are kept
+					current, err := readAccountIssue(ctx, storage, desired)
+					if err != nil {
+						return err
+					}
+					params := desired
+					if current != nil {
+						params.Claims = withManagedAccountClaims(desired.Claims, current.Claims)
+					}
+					return addAccountIssue(ctx, storage, params)
+				})
+			},
+		})
+	}
+
+	var userDeletes []applyStep
+	for _, name := range sortedKeys(params.Accounts) {
+		account := params.Accounts[name]
+		for _, userName := range sortedKeys(account.Users) {
+			desired := account.Users[userName]
+			issue, err := readUserIssue(ctx, storage, desired)
+			if err != nil {
+				return nil, err
+			}
+			if issue != nil && sameApplyParameters(&desired, &IssueUserParameters{
+				Operator:        issue.Operator,
+				Account:         issue.Account,
+				User:            issue.User,
+				UseSigningKey:   issue.UseSigningKey,
+				ClaimsTemplate:  issue.ClaimsTemplate,
+				ExpirationS:     issue.ExpirationS,
+				RotationPeriodS: issue.RotationPeriodS,
+			}) {
+				continue
+			}
+			steps = append(steps, applyStep{
+				change: ApplyChange{Action: applyAction(issue != nil), Kind: applyKindUser, Account: name, Name: userName},
+				apply: func(ctx context.Context, storage logical.Storage) error {
+					return withIssueWAL(ctx, storage, &issueWALEntry{
+						Operation: walOperationWrite,
+						Operator:  desired.Operator,
+						Account:   desired.Account,
+						User:      desired.User,
+					}, func() error {
+						return addUserIssue(ctx, storage, desired)
+					})
+				},
+			})
+		}
+
+		users, err := listUserIssues(ctx, storage, IssueUserParameters{Operator: operator, Account: name})
+		if err != nil {
+			return nil, err
+		}
+		for _, userName := range sortedStrings(users) {
+			if _, ok := account.Users[userName]; ok {
+				continue
+			}
+			if params.CreateSystemAccount && name == DefaultSysAccountName && userName == DefaultPushUser {
+				continue
+			}
+			user := IssueUserParameters{Operator: operator, Account: name, User: userName}
+			userDeletes = append(userDeletes, applyStep{
+				change: ApplyChange{Action: applyActionDelete, Kind: applyKindUser, Account: name, Name: userName},
+				apply: func(ctx context.Context, storage logical.Storage) error {
+					return withIssueWAL(ctx, storage, &issueWALEntry{
+						Operation: walOperationDelete,
+						Operator:  user.Operator,
+						Account:   user.Account,
+						User:      user.User,
+					}, func() error {
+						return deleteUserIssue(ctx, storage, user)
+					})
+				},
+			})
+		}
+	}
+	steps = append(steps, userDeletes...)
+
+	currentAccounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	removedAccounts := map[string]bool{}
+	currentImports := map[string][]string{}
+	for _, name := range currentAccounts {
+		if _, ok := params.Accounts[name]; ok {
+			continue
+		}
+		if params.CreateSystemAccount && name == DefaultSysAccountName {
+			continue
+		}
+		err = checkAccountDeletionProtection(ctx, storage, operator, name)
+		if err != nil {
+			return nil, err
+		}
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{Operator: operator, Account: name})
+		if err != nil {
+			return nil, err
+		}
+		removedAccounts[name] = true
+		if issue != nil {
+			for _, imp := range issue.Claims.Imports {
+				if imp.AccountRef != "" {
+					currentImports[name] = append(currentImports[name], imp.AccountRef)
+				}
+			}
+		}
+	}
+	// importers are removed before their exporters
+	removed := orderAccounts(removedAccounts, currentImports)
+	for i := len(removed) - 1; i >= 0; i-- {
+		name := removed[i]
+		users, err := listUserIssues(ctx, storage, IssueUserParameters{Operator: operator, Account: name})
+		if err != nil {
+			return nil, err
+		}
+		for _, userName := range sortedStrings(users) {
+			// the users are deleted together with the account
+			steps = append(steps, applyStep{
+				change: ApplyChange{Action: applyActionDelete, Kind: applyKindUser, Account: name, Name: userName},
+				apply:  func(ctx context.Context, storage logical.Storage) error { return nil },
+			})
+		}
+		steps = append(steps, applyStep{
+			change: ApplyChange{Action: applyActionDelete, Kind: applyKindAccount, Name: name},
+			apply: func(ctx context.Context, storage logical.Storage) error {
+				return deleteAccountIssueWithTombstone(ctx, storage, operator, name)
+			},
+		})
+	}
+
+	for _, name := range sortedKeys(params.Links) {
+		desired := params.Links[name]
+		issue, err := readLinkIssue(ctx, storage, desired)
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil && sameApplyParameters(&desired, &IssueLinkParameters{
+			Operator:     issue.Operator,
+			Link:         issue.Link,
+			Exporter:     issue.Exporter,
+			Importer:     issue.Importer,
+			Subject:      issue.Subject,
+			LocalSubject: issue.LocalSubject,
+			Type:         issue.Type,
+			TokenReq:     issue.TokenReq,
+		}) {
+			continue
+		}
+		steps = append(steps, applyStep{
+			change: ApplyChange{Action: applyAction(issue != nil), Kind: applyKindLink, Name: name},
+			apply: func(ctx context.Context, storage logical.Storage) error {
+				return writeLinkIssue(ctx, storage, desired)
+			},
+		})
+	}
+
+	return steps, nil
+}
+
+// deleteAccountIssueWithTombstone deletes the account and its users like
+// a cascading delete of the account path, keeping them for restore
+func deleteAccountIssueWithTombstone(ctx context.Context, storage logical.Storage, operator string, account string) error {
+	entry := &issueWALEntry{
+		Operation: walOperationDelete,
+		Operator:  operator,
+		Account:   account,
+		Cascade:   true,
+	}
+	var err error
+	entry.Tombstone, err = createTombstone(ctx, storage, operator, account)
+	if err != nil {
+		return err
+	}
+	return withIssueWAL(ctx, storage, entry, func() error {
+		_, err := entry.deleteIssue(ctx, storage)
+		return err
+	})
+}
+
+// orderAccounts orders the accounts so that the accounts they import from
+// come first. The system account comes first of all accounts without
+// dependencies, accounts in an import cycle are appended by name.
+func orderAccounts(accounts map[string]bool, imports map[string][]string) []string {
+	ordered := []string{}
+	done := map[string]bool{}
+	for len(done) < len(accounts) {
+		ready := []string{}
+		for name := range accounts {
+			if done[name] {
+				continue
+			}
+			blocked := false
+			for _, ref := range imports[name] {
+				if ref != name && accounts[ref] && !done[ref] {
+					blocked = true
+				}
+			}
+			if !blocked {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			// import cycle
+			for name := range accounts {
+				if !done[name] {
+					ready = append(ready, name)
+				}
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool {
+			if (ready[i] == DefaultSysAccountName) != (ready[j] == DefaultSysAccountName) {
+				return ready[i] == DefaultSysAccountName
+			}
+			return ready[i] < ready[j]
+		})
+		for _, name := range ready {
+			done[name] = true
+		}
+		ordered = append(ordered, ready...)
+	}
+	return ordered
+}
+
+// unmanagedAccountClaims returns the claims without the entries maintained
+// by the backend: the revocations and the imports and exports of links
+func unmanagedAccountClaims(claims v1alpha1.AccountClaims) v1alpha1.AccountClaims {
+	c := *claims.DeepCopy()
+	c.Revocations = nil
+	c.Imports = nil
+	for _, imp := range claims.Imports {
+		if !strings.HasPrefix(imp.Name, linkEntryPrefix) {
+			c.Imports = append(c.Imports, imp)
+		}
+	}
+	c.Exports = nil
+	for _, export := range claims.Exports {
+		if !strings.HasPrefix(export.Name, linkEntryPrefix) {
+			export.Revocations = nil
+			c.Exports = append(c.Exports, export)
+		}
+	}
+	return c
+}
+
+// withManagedAccountClaims adds the entries of the current claims
+// maintained by the backend to the desired claims
+func withManagedAccountClaims(desired v1alpha1.AccountClaims, current v1alpha1.AccountClaims) v1alpha1.AccountClaims {
+	c := *desired.DeepCopy()
+	if len(current.Revocations) > 0 {
+		c.Revocations = current.DeepCopy().Revocations
+	}
+	for i := range c.Exports {
+		if export := findExport(&IssueAccountStorage{Claims: current}, c.Exports[i].Subject); export != nil && len(export.Revocations) > 0 {
+			c.Exports[i].Revocations = export.DeepCopy().Revocations
+		}
+	}
+	for _, imp := range current.Imports {
+		if strings.HasPrefix(imp.Name, linkEntryPrefix) {
+			c.Imports = append(c.Imports, *imp.DeepCopy())
+		}
+	}
+	for _, export := range current.Exports {
+		if strings.HasPrefix(export.Name, linkEntryPrefix) {
+			c.Exports = append(c.Exports, *export.DeepCopy())
+		}
+	}
+	return c
+}
+
+// sameApplyParameters compares the parameters by their JSON encoding
+func sameApplyParameters(a interface{}, b interface{}) bool {
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(ja) == string(jb)
+}
+
+func applyAction(exists bool) string {
+	if exists {
+		return applyActionUpdate
+	}
+	return applyActionCreate
+}
+
+func applyChangeName(change ApplyChange) string {
+	if change.Account != "" {
+		return change.Account + "/" + change.Name
+	}
+	return change.Name
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedStrings(s []string) []string {
+	sorted := append([]string{}, s...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+func createResponseApplyData(data *ApplyData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
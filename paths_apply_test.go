@@ -0,0 +1,175 @@
+package natsbackend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyOperator(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	enableTombstones(t, b, reqStorage)
+
+	document := func(t *testing.T, doc string) map[string]interface{} {
+		data := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(doc), &data))
+		return data
+	}
+	apply := func(t *testing.T, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "apply/operator/op1",
+			Storage:   reqStorage,
+			Data:      data,
+		})
+	}
+	changes := func(t *testing.T, resp *logical.Response) []string {
+		var list []string
+		for _, c := range resp.Data["changes"].([]interface{}) {
+			change := c.(map[string]interface{})
+			name := change["name"].(string)
+			if account, ok := change["account"]; ok {
+				name = account.(string) + "/" + name
+			}
+			list = append(list, change["action"].(string)+" "+change["kind"].(string)+" "+name)
+		}
+		return list
+	}
+
+	desired := `{
+		"createSystemAccount": true,
+		"claims": {"operator": {"signingKeys": ["opsk1"]}},
+		"accounts": {
+			"importer": {
+				"useSigningKey": "opsk1",
+				"claims": {"account": {
+					"limits": {"imports": -1, "exports": -1},
+					"imports": [{"name": "orders", "subject": "orders.>", "accountRef": "exporter", "type": "Stream"}]
+				}},
+				"users": {"us1": {}}
+			},
+			"exporter": {
+				"claims": {"account": {
+					"limits": {"imports": -1, "exports": -1, "wildcardExports": true},
+					"exports": [{"name": "orders", "subject": "orders.>", "type": "Stream"}]
+				}},
+				"users": {"us1": {"expirationS": 60}, "us2": {}}
+			},
+			"obsolete": {}
+		},
+		"links": {
+			"events": {"exporter": "exporter", "importer": "importer", "subject": "events.>", "type": "stream"}
+		}
+	}`
+
+	t.Run("plan does not change anything", func(t *testing.T) {
+		data := document(t, desired)
+		data["plan"] = true
+		resp, err := apply(t, data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, true, resp.Data["plan"])
+		assert.Equal(t, []string{
+			"create operator op1",
+			"create account exporter",
+			"create account obsolete",
+			"create account importer",
+			"create user exporter/us1",
+			"create user exporter/us2",
+			"create user importer/us1",
+			"create link events",
+		}, changes(t, resp))
+
+		issue, err := readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+	})
+
+	t.Run("apply creates everything in dependency order", func(t *testing.T) {
+		resp, err := apply(t, document(t, desired))
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Len(t, changes(t, resp), 8)
+
+		link, err := readLinkIssue(context.Background(), reqStorage, IssueLinkParameters{Operator: "op1", Link: "events"})
+		require.NoError(t, err)
+		assert.NotNil(t, link)
+		sys, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: DefaultSysAccountName})
+		require.NoError(t, err)
+		assert.NotNil(t, sys)
+	})
+
+	t.Run("applying again is a no-op", func(t *testing.T) {
+		data := document(t, desired)
+		data["plan"] = true
+		resp, err := apply(t, data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Empty(t, changes(t, resp))
+	})
+
+	t.Run("updates and removals", func(t *testing.T) {
+		data := document(t, desired)
+		accounts := data["accounts"].(map[string]interface{})
+		delete(accounts, "obsolete")
+		delete(accounts["exporter"].(map[string]interface{})["users"].(map[string]interface{}), "us2")
+		accounts["importer"].(map[string]interface{})["users"] = map[string]interface{}{"us1": map[string]interface{}{"expirationS": 120}}
+		delete(data, "links")
+
+		resp, err := apply(t, data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []string{
+			"delete link events",
+			"update user importer/us1",
+			"delete user exporter/us2",
+			"delete account obsolete",
+		}, changes(t, resp))
+
+		user, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{Operator: "op1", Account: "exporter", User: "us2"})
+		require.NoError(t, err)
+		assert.Nil(t, user)
+		user, err = readUserIssue(context.Background(), reqStorage, IssueUserParameters{Operator: "op1", Account: "importer", User: "us1"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(120), user.ExpirationS)
+		tombstone, err := readTombstone(context.Background(), reqStorage, TombstoneParameters{Operator: "op1", Account: "obsolete"})
+		require.NoError(t, err)
+		assert.NotNil(t, tombstone)
+
+		// the revocation of the deleted user is kept by later applies
+		data["plan"] = true
+		resp, err = apply(t, data)
+		require.NoError(t, err)
+		assert.Empty(t, changes(t, resp))
+	})
+
+	t.Run("protected accounts are not removed", func(t *testing.T) {
+		data := document(t, desired)
+		data["accounts"].(map[string]interface{})["obsolete"] = map[string]interface{}{"deletionProtection": true}
+		resp, err := apply(t, data)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		delete(data["accounts"].(map[string]interface{}), "obsolete")
+		resp, err = apply(t, data)
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("invalid documents", func(t *testing.T) {
+		for _, doc := range []string{
+			`{"cas": 1}`,
+			`{"accounts": {"ac1": {"unknown": true}}}`,
+			`{"accounts": {"ac 1": {}}}`,
+		} {
+			resp, err := apply(t, document(t, doc))
+			assertErrorStatus(t, err, http.StatusBadRequest)
+			assert.True(t, isErrorResponse(resp))
+		}
+	})
+}
@@ -0,0 +1,456 @@
+package natsbackend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/backup"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+const (
+	restoreModeMerge   = "merge"
+	restoreModeReplace = "replace"
+)
+
+// BackupParameters selects how the backup is encrypted: with a passphrase or
+// for an age X25519 recipient
+type BackupParameters struct {
+	Passphrase string `json:"passphrase,omitempty"`
+	Recipient  string `json:"recipient,omitempty"`
+}
+
+type BackupData struct {
+	// Backup is the ASCII armored age encrypted archive
+	Backup    string `json:"backup"`
+	Version   int    `json:"version"`
+	CreatedAt int64  `json:"createdAt"`
+	Entries   int    `json:"entries"`
+}
+
+// RestoreParameters carries the backup and the passphrase or age X25519
+// identity to decrypt it
+type RestoreParameters struct {
+	Backup     string `json:"backup"`
+	Passphrase string `json:"passphrase,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+	Mode       string `json:"mode,omitempty"`
+	DryRun     bool   `json:"dryRun,omitempty"`
+}
+
+// RestoreData lists the restored, deleted and conflicting units. A unit is an
+// operator with everything below it (operator/<name>) or a top level entry
+// like config.
+type RestoreData struct {
+	Mode      string   `json:"mode"`
+	DryRun    bool     `json:"dryRun"`
+	Restored  []string `json:"restored"`
+	Deleted   []string `json:"deleted"`
+	Conflicts []string `json:"conflicts"`
+}
+
+// restorePlan holds the storage changes of a restore
+type restorePlan struct {
+	data    RestoreData
+	puts    map[string][]byte
+	deletes []string
+}
+
+func pathBackup(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "backup$",
+			Fields: map[string]*framework.FieldSchema{
+				"passphrase": {
+					Type:        framework.TypeString,
+					Description: "Passphrase the backup is encrypted with",
+					Required:    false,
+				},
+				"recipient": {
+					Type:        framework.TypeString,
+					Description: "age X25519 public key (age1...) the backup is encrypted for",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathBackup,
+				},
+			},
+			HelpSynopsis:    `Backs up all entries of the mount.`,
+			HelpDescription: `Returns a versioned and checksummed archive of all nkeys, JWTs, issues, tombstones and the config, encrypted as ASCII armored age file with either the passphrase or for the recipient.`,
+		},
+		{
+			Pattern: "restore$",
+			Fields: map[string]*framework.FieldSchema{
+				"backup": {
+					Type:        framework.TypeString,
+					Description: "ASCII armored backup returned by the backup endpoint",
+					Required:    true,
+				},
+				"passphrase": {
+					Type:        framework.TypeString,
+					Description: "Passphrase the backup was encrypted with",
+					Required:    false,
+				},
+				"identity": {
+					Type:        framework.TypeString,
+					Description: "age X25519 secret key (AGE-SECRET-KEY-1...) the backup was encrypted for",
+					Required:    false,
+				},
+				"mode": {
+					Type:        framework.TypeString,
+					Description: "merge only restores operators missing in the mount, replace makes the mount equal to the backup (default: merge)",
+					Required:    false,
+				},
+				"dryRun": {
+					Type:        framework.TypeBool,
+					Description: "Only report the changes without writing them",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestore,
+				},
+			},
+			HelpSynopsis:    `Restores the mount from a backup.`,
+			HelpDescription: `Restores the entries of a backup per operator. Operators existing in the mount with different entries are reported as conflicts: merge keeps them, replace overwrites them and deletes operators missing in the backup.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathBackup(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params BackupParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	recipient, err := backup.Recipient(params.Passphrase, params.Recipient)
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	keys, err := listMountKeys(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(BackupFailedError, err)
+	}
+	// the operator locks keep the entries of each operator consistent
+	defer b.lockOperators(backupOperators(keys))()
+
+	entries, err := readMountEntries(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(BackupFailedError, err)
+	}
+	archive := backup.New(entries)
+	sealed, err := backup.Seal(archive, recipient)
+	if err != nil {
+		return errorResponse(BackupFailedError, err)
+	}
+	log.Info().Int("entries", len(entries)).Msg("mount backed up")
+
+	resp, err := createResponseBackupData(&BackupData{
+		Backup:    sealed,
+		Version:   archive.Version,
+		CreatedAt: archive.CreatedAt,
+		Entries:   len(entries),
+	})
+	if err != nil {
+		return errorResponse(BackupFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathRestore(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params RestoreParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	if params.Mode == "" {
+		params.Mode = restoreModeMerge
+	}
+	if params.Mode != restoreModeMerge && params.Mode != restoreModeReplace {
+		return errorResponse(InvalidParametersError, fmt.Errorf("mode must be %s or %s", restoreModeMerge, restoreModeReplace))
+	}
+	identity, err := backup.Identity(params.Passphrase, params.Identity)
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+	archive, err := backup.Open(params.Backup, identity)
+	if err != nil {
+		return errorResponse(RestoreFailedError, errInvalid("%w", err))
+	}
+
+	version, err := backupStorageVersion(archive)
+	if err != nil {
+		return errorResponse(RestoreFailedError, err)
+	}
+	if version > storageVersion {
+		return errorResponse(RestoreFailedError, errInvalid("backup has storage version %d, newer than the supported version %d", version, storageVersion))
+	}
+
+	keys, err := listMountKeys(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(RestoreFailedError, err)
+	}
+	defer b.lockOperators(backupOperators(append(keys, archive.Keys()...)))()
+
+	current, err := readMountEntries(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(RestoreFailedError, err)
+	}
+	// entries of older backups are migrated before they are compared,
+	// so no outdated entries are written back
+	archived := archive.Entries
+	if version < storageVersion {
+		archived, err = migrateEntries(ctx, archived)
+		if err != nil {
+			return errorResponse(RestoreFailedError, err)
+		}
+	}
+	plan := planRestore(current, archived, params.Mode)
+	plan.data.DryRun = params.DryRun
+	if !params.DryRun {
+		err = b.applyRestore(ctx, req.Storage, plan)
+		if err != nil {
+			return errorResponse(RestoreFailedError, err)
+		}
+		log.Info().
+			Str("mode", params.Mode).Strs("restored", plan.data.Restored).Strs("deleted", plan.data.Deleted).
+			Msg("mount restored")
+	}
+
+	resp, err := createResponseRestoreData(&plan.data)
+	if err != nil {
+		return errorResponse(RestoreFailedError, err)
+	}
+	return resp, nil
+}
+
+// listMountKeys lists the keys of all storage entries except the WAL
+func listMountKeys(ctx context.Context, storage logical.Storage) ([]string, error) {
+	keys, err := logical.CollectKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	mountKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasPrefix(key, framework.WALPrefix) {
+			mountKeys = append(mountKeys, key)
+		}
+	}
+	return mountKeys, nil
+}
+
+// readMountEntries reads all storage entries except the WAL by their key
+func readMountEntries(ctx context.Context, storage logical.Storage) (map[string][]byte, error) {
+	keys, err := listMountKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string][]byte{}
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries[key] = entry.Value
+		}
+	}
+	return entries, nil
+}
+
+// backupUnit returns the unit a storage entry is restored with and its
+// operator. Entries below an operator, e.g. nkey/operator/<name>/...,
+// belong to the operator, other entries are units of their own.
+func backupUnit(key string) (string, string) {
+	parts := strings.Split(key, "/")
+	if len(parts) >= 3 && parts[1] == "operator" {
+		return "operator/" + parts[2], parts[2]
+	}
+	return key, ""
+}
+
+func backupOperators(keys []string) []string {
+	operators := []string{}
+	for _, key := range keys {
+		_, operator := backupUnit(key)
+		if operator != "" {
+			operators = append(operators, operator)
+		}
+	}
+	return operators
+}
+
+func groupBackupUnits(entries map[string][]byte) map[string]map[string][]byte {
+	units := map[string]map[string][]byte{}
+	for key, value := range entries {
+		unit, _ := backupUnit(key)
+		if units[unit] == nil {
+			units[unit] = map[string][]byte{}
+		}
+		units[unit][key] = value
+	}
+	return units
+}
+
+// planRestore compares the entries of the mount and the backup per unit.
+// Units missing in the mount are restored. Units with different entries are
+// conflicts, which are only restored in replace mode. Replace also deletes
+// the units missing in the backup.
+func planRestore(current map[string][]byte, archived map[string][]byte, mode string) *restorePlan {
+	plan := &restorePlan{
+		data: RestoreData{
+			Mode:      mode,
+			Restored:  []string{},
+			Deleted:   []string{},
+			Conflicts: []string{},
+		},
+		puts: map[string][]byte{},
+	}
+	currentUnits := groupBackupUnits(current)
+	archivedUnits := groupBackupUnits(archived)
+
+	for unit, entries := range archivedUnits {
+		existing, ok := currentUnits[unit]
+		if ok {
+			if equalEntries(existing, entries) {
+				continue
+			}
+			plan.data.Conflicts = append(plan.data.Conflicts, unit)
+			if mode != restoreModeReplace {
+				continue
+			}
+		}
+		plan.data.Restored = append(plan.data.Restored, unit)
+		for key, value := range entries {
+			old, ok := existing[key]
+			if !ok || !bytes.Equal(old, value) {
+				plan.puts[key] = value
+			}
+		}
+		for key := range existing {
+			if _, ok := entries[key]; !ok {
+				plan.deletes = append(plan.deletes, key)
+			}
+		}
+	}
+	if mode == restoreModeReplace {
+		for unit, existing := range currentUnits {
+			if _, ok := archivedUnits[unit]; ok {
+				continue
+			}
+			plan.data.Deleted = append(plan.data.Deleted, unit)
+			for key := range existing {
+				plan.deletes = append(plan.deletes, key)
+			}
+		}
+	}
+
+	sort.Strings(plan.data.Restored)
+	sort.Strings(plan.data.Deleted)
+	sort.Strings(plan.data.Conflicts)
+	sort.Strings(plan.deletes)
+	return plan
+}
+
+func equalEntries(a map[string][]byte, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRestore writes the planned changes. Pending WAL entries of the
+// changed operators are discarded first, so they can't roll back the
+// restored entries.
+func (b *NatsBackend) applyRestore(ctx context.Context, storage logical.Storage, plan *restorePlan) error {
+	configChanged := false
+	for _, unit := range append(append([]string{}, plan.data.Restored...), plan.data.Deleted...) {
+		if unit == configPath {
+			configChanged = true
+		}
+		if operator := strings.TrimPrefix(unit, "operator/"); operator != unit {
+			err := discardIssueWAL(ctx, storage, operator)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, key := range plan.deletes {
+		err := storage.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	for key, value := range plan.puts {
+		err := storage.Put(ctx, &logical.StorageEntry{Key: key, Value: value})
+		if err != nil {
+			return err
+		}
+	}
+
+	if configChanged {
+		b.reset()
+	}
+	return nil
+}
+
+// backupStorageVersion returns the storage version of the backed up entries
+func backupStorageVersion(archive *backup.Archive) (int, error) {
+	value, ok := archive.Entries[storageVersionPath]
+	if !ok {
+		return 0, nil
+	}
+	entry := &logical.StorageEntry{Key: storageVersionPath, Value: value}
+	var version StorageVersion
+	err := entry.DecodeJSON(&version)
+	if err != nil {
+		return 0, errInvalid("invalid storage version: %w", err)
+	}
+	return version.Version, nil
+}
+
+func createResponseBackupData(data *BackupData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
+
+func createResponseRestoreData(data *RestoreData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
@@ -0,0 +1,142 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	request := func(t *testing.T, b logical.Backend, storage logical.Storage, operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		return resp
+	}
+	write := func(t *testing.T, b logical.Backend, storage logical.Storage, path string, data map[string]interface{}) *logical.Response {
+		return request(t, b, storage, logical.UpdateOperation, path, data)
+	}
+	for _, path := range []string{
+		"issue/operator/op1",
+		"issue/operator/op1/account/ac1",
+		"issue/operator/op1/account/ac1/user/us1",
+	} {
+		write(t, b, reqStorage, path, map[string]interface{}{})
+	}
+	write(t, b, reqStorage, "config", map[string]interface{}{"tombstoneRetention": "1h"})
+
+	resp := write(t, b, reqStorage, "backup", map[string]interface{}{"passphrase": "secret"})
+	sealed := resp.Data["backup"].(string)
+	assert.Contains(t, sealed, "BEGIN AGE ENCRYPTED FILE")
+	assert.Equal(t, float64(1), resp.Data["version"])
+	entries, err := readMountEntries(context.Background(), reqStorage)
+	require.NoError(t, err)
+	assert.Equal(t, float64(len(entries)), resp.Data["entries"])
+
+	restore := func(t *testing.T, data map[string]interface{}) *logical.Response {
+		data["backup"] = sealed
+		data["passphrase"] = "secret"
+		return write(t, b, reqStorage, "restore", data)
+	}
+
+	t.Run("merge into an empty mount", func(t *testing.T) {
+		b2, storage2 := getTestBackend(t)
+		resp := write(t, b2, storage2, "restore", map[string]interface{}{
+			"backup":     sealed,
+			"passphrase": "secret",
+		})
+		assert.Equal(t, []interface{}{"config", "operator/op1"}, resp.Data["restored"])
+		assert.Empty(t, resp.Data["conflicts"])
+
+		restored, err := readMountEntries(context.Background(), storage2)
+		require.NoError(t, err)
+		assert.Equal(t, entries, restored)
+		resp = request(t, b2, storage2, logical.ReadOperation, "creds/operator/op1/account/ac1/user/us1", nil)
+		assert.NotEmpty(t, resp.Data["creds"])
+	})
+
+	// change the mount after the backup
+	write(t, b, reqStorage, "issue/operator/op1/account/ac2", map[string]interface{}{})
+	write(t, b, reqStorage, "issue/operator/op2", map[string]interface{}{})
+
+	t.Run("merge keeps conflicting operators", func(t *testing.T) {
+		resp := restore(t, map[string]interface{}{})
+		assert.Equal(t, "merge", resp.Data["mode"])
+		assert.Equal(t, []interface{}{"operator/op1"}, resp.Data["conflicts"])
+		assert.Empty(t, resp.Data["restored"])
+		assert.Empty(t, resp.Data["deleted"])
+
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac2"})
+		require.NoError(t, err)
+		assert.NotNil(t, issue)
+	})
+
+	t.Run("dry run of replace", func(t *testing.T) {
+		before, err := readMountEntries(context.Background(), reqStorage)
+		require.NoError(t, err)
+
+		resp := restore(t, map[string]interface{}{"mode": "replace", "dryRun": true})
+		assert.Equal(t, true, resp.Data["dryRun"])
+		assert.Equal(t, []interface{}{"operator/op1"}, resp.Data["conflicts"])
+		assert.Equal(t, []interface{}{"operator/op1"}, resp.Data["restored"])
+		assert.Equal(t, []interface{}{"operator/op2"}, resp.Data["deleted"])
+
+		after, err := readMountEntries(context.Background(), reqStorage)
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		resp := restore(t, map[string]interface{}{"mode": "replace"})
+		assert.Equal(t, []interface{}{"operator/op1"}, resp.Data["restored"])
+		assert.Equal(t, []interface{}{"operator/op2"}, resp.Data["deleted"])
+
+		restored, err := readMountEntries(context.Background(), reqStorage)
+		require.NoError(t, err)
+		assert.Equal(t, entries, restored)
+	})
+
+	t.Run("recipient", func(t *testing.T) {
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		resp := write(t, b, reqStorage, "backup", map[string]interface{}{"recipient": identity.Recipient().String()})
+
+		resp = write(t, b, reqStorage, "restore", map[string]interface{}{
+			"backup":   resp.Data["backup"],
+			"identity": identity.String(),
+		})
+		assert.Empty(t, resp.Data["restored"])
+		assert.Empty(t, resp.Data["conflicts"])
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, data := range []map[string]interface{}{
+			{},
+			{"backup": sealed},
+			{"backup": sealed, "passphrase": "wrong"},
+			{"backup": sealed, "passphrase": "secret", "mode": "overwrite"},
+			{"backup": "garbage", "passphrase": "secret"},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "restore",
+				Storage:   reqStorage,
+				Data:      data,
+			})
+			assertErrorStatus(t, err, http.StatusBadRequest)
+			assert.True(t, isErrorResponse(resp))
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package natsbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+const configPath = "config"
+
+type ConfigStorage struct {
+	// TombstoneRetentionS is the number of seconds deleted operators and
+	// accounts are kept for restore. 0 deletes them permanently.
+	TombstoneRetentionS int64 `json:"tombstoneRetention"`
+}
+
+type ConfigData struct {
+	TombstoneRetentionS int64 `json:"tombstoneRetention"`
+}
+
+func pathConfig(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "config$",
+			Fields: map[string]*framework.FieldSchema{
+				"tombstoneRetention": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long deleted operators and accounts can be restored, 0 deletes them permanently (default: 0)",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathWriteConfig,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathWriteConfig,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathReadConfig,
+				},
+			},
+			HelpSynopsis:    `Manages the configuration of the backend.`,
+			HelpDescription: ``,
+		},
+	}
+}
+
+func (b *NatsBackend) pathWriteConfig(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(ReadingConfigFailedError, err)
+	}
+	if retention, ok := data.GetOk("tombstoneRetention"); ok {
+		config.TombstoneRetentionS = int64(retention.(int))
+	}
+	if config.TombstoneRetentionS < 0 {
+		return errorResponse(InvalidParametersError, errInvalid("tombstoneRetention must not be negative"))
+	}
+
+	err = storeInStorage(ctx, req.Storage, configPath, config)
+	if err != nil {
+		return errorResponse(WritingConfigFailedError, err)
+	}
+	return createResponseConfigData(config)
+}
+
+func (b *NatsBackend) pathReadConfig(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	config, err := readConfig(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(ReadingConfigFailedError, err)
+	}
+	return createResponseConfigData(config)
+}
+
+// readConfig reads the stored config, falling back to the defaults
+func readConfig(ctx context.Context, storage logical.Storage) (*ConfigStorage, error) {
+	config, err := getFromStorage[ConfigStorage](ctx, storage, configPath)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &ConfigStorage{}
+	}
+	return config, nil
+}
+
+func createResponseConfigData(config *ConfigStorage) (*logical.Response, error) {
+	data := &ConfigData{
+		TombstoneRetentionS: config.TombstoneRetentionS,
+	}
+
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: rval,
+	}, nil
+}
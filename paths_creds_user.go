@@ -8,6 +8,7 @@ import (
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/user/v1alpha1"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/nats-io/jwt/v2"
@@ -97,7 +98,7 @@ func pathUserCreds(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathReadUserCreds(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	// Extract path parameters directly from data.Raw
@@ -110,17 +111,10 @@ func (b *NatsBackend) pathReadUserCreds(ctx context.Context, req *logical.Reques
 	// Parse parameters string from query parameter
 	if parametersStr := data.Get("parameters"); parametersStr != nil {
 		if paramStr, ok := parametersStr.(string); ok && paramStr != "" {
-			params.Parameters = make(map[string]string)
-
-			// Try to parse as JSON first
-			err := json.Unmarshal([]byte(paramStr), &params.Parameters)
+			params.Parameters, err = ParseTemplateParameters(paramStr)
 			if err != nil {
-				// If JSON parsing fails, try key=value format
-				err = parseKeyValueString(paramStr, params.Parameters)
-				if err != nil {
-					log.Error().Err(err).Str("parametersStr", paramStr).Msg("Failed to parse parameters")
-					return logical.ErrorResponse("Invalid parameters format. Use key=value,key2=value2 or JSON"), logical.ErrInvalidRequest
-				}
+				log.Error().Err(err).Str("parametersStr", paramStr).Msg("Failed to parse parameters")
+				return errorResponse(DecodeFailedError, errInvalid("%w", err))
 			}
 
 			log.Debug().Interface("parsedParameters", params.Parameters).Msg("Parsed parameters")
@@ -130,14 +124,45 @@ func (b *NatsBackend) pathReadUserCreds(ctx context.Context, req *logical.Reques
 	// Generate fresh credentials on-demand
 	UserCredsData, err := generateUserCreds(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("GeneratingCredsFailedError: %s", err.Error())), nil
+		return errorResponse(ReadingCredsFailedError, err)
 	}
 
 	if UserCredsData == nil {
-		return logical.ErrorResponse("UserTemplateNotFoundError"), nil
+		return errorResponse(CredsNotFoundError, nil)
 	}
 
-	return createResponseUserCredsData(UserCredsData)
+	resp, err := createResponseUserCredsData(UserCredsData)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.ParseDecoratedJWT([]byte(UserCredsData.Creds))
+	if err != nil {
+		return resp, nil
+	}
+	return addJWTWarnings(resp, token), nil
+}
+
+// ParseTemplateParameters parses the parameters of a claims template given
+// as JSON object or as key=value,key2=value2
+func ParseTemplateParameters(input string) (map[string]string, error) {
+	parameters := make(map[string]string)
+
+	// Try to parse as JSON first
+	err := json.Unmarshal([]byte(input), &parameters)
+	if err != nil {
+		// If JSON parsing fails, try key=value format
+		err = parseKeyValueString(input, parameters)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parameters format, use key=value,key2=value2 or JSON: %w", err)
+		}
+	}
+	return parameters, nil
+}
+
+// RenderClaimsTemplate expands the claims template of a user issue with the
+// parameters like the creds path does before signing the user JWT
+func RenderClaimsTemplate(template v1alpha1.UserClaims, parameters map[string]string) (v1alpha1.UserClaims, error) {
+	return applyTemplateParameters(template, parameters)
 }
 
 func parseKeyValueString(input string, result map[string]string) error {
@@ -164,18 +189,18 @@ func parseKeyValueString(input string, result map[string]string) error {
 func (b *NatsBackend) pathListUserCreds(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params UserCredsParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listUserCreds(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListCredsFailedError), nil
+		return errorResponse(ListCredsFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -199,19 +224,19 @@ func generateUserCreds(ctx context.Context, storage logical.Storage, params User
 		return nil, fmt.Errorf("could not read user template: %s", err)
 	}
 	if issue == nil {
-		return nil, fmt.Errorf("user template not found")
+		return nil, errNotFound("user template not found")
 	}
 
 	// 2. Apply template parameters to claims
 	processedClaims, err := applyTemplateParameters(issue.ClaimsTemplate, params.Parameters)
 	if err != nil {
-		return nil, fmt.Errorf("could not apply template parameters: %s", err)
+		return nil, errInvalid("could not apply template parameters: %w", err)
 	}
 
 	// 3. Generate fresh JWT
 	jwtToken, expiresAt, err := generateUserJWT(ctx, storage, *issue, processedClaims)
 	if err != nil {
-		return nil, fmt.Errorf("could not generate JWT: %s", err)
+		return nil, fmt.Errorf("could not generate JWT: %w", err)
 	}
 
 	// 4. Get user nkey for creds file
@@ -224,7 +249,7 @@ func generateUserCreds(ctx context.Context, storage logical.Storage, params User
 		return nil, fmt.Errorf("could not read user nkey: %s", err)
 	}
 	if userNkey == nil {
-		return nil, fmt.Errorf("user nkey not found")
+		return nil, errNotFound("user nkey not found")
 	}
 
 	// 5. Create creds file
@@ -268,7 +293,7 @@ func applyTemplateParameters(template v1alpha1.UserClaims, parameters map[string
 	// Check if all required variables are provided
 	if len(requiredVars) > 0 {
 		if len(parameters) == 0 {
-			return template, fmt.Errorf("template requires parameters but none provided: %v", requiredVars)
+			return template, errInvalid("template requires parameters but none provided: %v", requiredVars)
 		}
 
 		var missingVars []string
@@ -279,7 +304,7 @@ func applyTemplateParameters(template v1alpha1.UserClaims, parameters map[string
 		}
 
 		if len(missingVars) > 0 {
-			return template, fmt.Errorf("missing required template parameters: %v", missingVars)
+			return template, errInvalid("missing required template parameters: %v", missingVars)
 		}
 	}
 
@@ -299,6 +324,11 @@ func applyTemplateParameters(template v1alpha1.UserClaims, parameters map[string
 	return processedClaims, nil
 }
 
+// hasTemplateVariables reports whether s contains template variables
+func hasTemplateVariables(s string) bool {
+	return len(findTemplateVariables(s)) > 0
+}
+
 func findTemplateVariables(templateStr string) []string {
 	var variables []string
 	variableMap := make(map[string]bool) // To avoid duplicates
@@ -345,7 +375,7 @@ func generateUserJWT(ctx context.Context, storage logical.Storage, issue IssueUs
 		return "", 0, fmt.Errorf("could not read account nkey: %s", err)
 	}
 	if accountNkey == nil {
-		return "", 0, fmt.Errorf("account nkey does not exist: %s", issue.Account)
+		return "", 0, errNotFound("account nkey does not exist: %s", issue.Account)
 	}
 
 	accountKeyPair, err := nkeys.FromSeed(accountNkey.Seed)
@@ -369,7 +399,7 @@ func generateUserJWT(ctx context.Context, storage logical.Storage, issue IssueUs
 			return "", 0, fmt.Errorf("could not read signing nkey: %s", err)
 		}
 		if signingNkey == nil {
-			return "", 0, fmt.Errorf("account signing nkey does not exist: %s", useSigningKey)
+			return "", 0, errNotFound("account signing nkey does not exist: %s", useSigningKey)
 		}
 		seed = signingNkey.Seed
 	}
@@ -393,7 +423,7 @@ func generateUserJWT(ctx context.Context, storage logical.Storage, issue IssueUs
 		return "", 0, fmt.Errorf("could not read user nkey: %s", err)
 	}
 	if userNkey == nil {
-		return "", 0, fmt.Errorf("user nkey does not exist")
+		return "", 0, errNotFound("user nkey does not exist")
 	}
 
 	userKeyPair, err := nkeys.FromSeed(userNkey.Seed)
@@ -411,6 +441,9 @@ func generateUserJWT(ctx context.Context, storage logical.Storage, issue IssueUs
 	}
 	claims.ClaimsData.Subject = userPublicKey
 	claims.ClaimsData.Issuer = signingPublicKey
+	if claims.ClaimsData.Name == "" {
+		claims.ClaimsData.Name = issue.User
+	}
 
 	// Set expiration if configured
 	var expiresAt int64
@@ -422,7 +455,11 @@ func generateUserJWT(ctx context.Context, storage logical.Storage, issue IssueUs
 	// Convert and encode JWT
 	natsJwt, err := v1alpha1.Convert(&claims)
 	if err != nil {
-		return "", 0, fmt.Errorf("could not convert claims to nats jwt: %s", err)
+		return "", 0, errInvalid("could not convert claims to nats jwt: %w", err)
+	}
+	_, err = validate.Claims(natsJwt)
+	if err != nil {
+		return "", 0, errInvalid("%w", err)
 	}
 
 	token, err := natsJwt.Encode(signingKeyPair)
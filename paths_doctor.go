@@ -0,0 +1,674 @@
+package natsbackend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+const (
+	doctorCheckMissingNkey   = "missing_nkey"
+	doctorCheckMissingJWT    = "missing_jwt"
+	doctorCheckInvalidJWT    = "invalid_jwt"
+	doctorCheckSubject       = "subject"
+	doctorCheckIssuer        = "issuer"
+	doctorCheckSigningKeys   = "signing_keys"
+	doctorCheckSystemAccount = "system_account"
+	doctorCheckOrphan        = "orphan"
+)
+
+// DoctorParameters selects the operator to check, all operators without it
+type DoctorParameters struct {
+	Operator string `json:"operator,omitempty"`
+	Repair   bool   `json:"repair,omitempty"`
+}
+
+// DoctorProblem is an inconsistency of the storage entry at Path
+type DoctorProblem struct {
+	Path    string `json:"path"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+	// Repaired is set when the problem is gone after the repair
+	Repaired    bool   `json:"repaired,omitempty"`
+	RepairError string `json:"repairError,omitempty"`
+}
+
+type DoctorData struct {
+	Repair    bool            `json:"repair"`
+	Operators int             `json:"operators"`
+	Accounts  int             `json:"accounts"`
+	Users     int             `json:"users"`
+	Problems  []DoctorProblem `json:"problems"`
+}
+
+// doctorFinding is a problem and how to repair it. Findings with the same
+// repair key share one repair, e.g. re-issuing the account. Findings without
+// repair explain in manual what needs to be done instead.
+type doctorFinding struct {
+	problem   DoctorProblem
+	repairKey string
+	repair    func(ctx context.Context, storage logical.Storage) error
+	manual    string
+}
+
+// doctorReport collects the findings of one operator
+type doctorReport struct {
+	accounts int
+	users    int
+	findings []doctorFinding
+}
+
+func (r *doctorReport) add(path string, check string, repairKey string, repair func(ctx context.Context, storage logical.Storage) error, format string, args ...interface{}) {
+	r.findings = append(r.findings, doctorFinding{
+		problem: DoctorProblem{
+			Path:    path,
+			Check:   check,
+			Message: fmt.Sprintf(format, args...),
+		},
+		repairKey: repairKey,
+		repair:    repair,
+	})
+}
+
+// addManual adds a finding the doctor does not repair
+func (r *doctorReport) addManual(path string, check string, manual string, format string, args ...interface{}) {
+	r.add(path, check, "", nil, format, args...)
+	r.findings[len(r.findings)-1].manual = manual
+}
+
+func pathDoctor(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "doctor$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "Only check this operator (default: all operators)",
+					Required:    false,
+				},
+				"repair": {
+					Type:        framework.TypeBool,
+					Description: "Re-issue the affected issues",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathDoctor,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathDoctor,
+				},
+			},
+			HelpSynopsis:    `Checks the nkeys and JWTs of all operators, accounts and users.`,
+			HelpDescription: `Verifies the subject and issuer chains and signatures of the JWTs, the signing keys, the system account of the operator and reports orphaned nkeys and JWTs. With repair the affected issues are re-issued, orphaned entries are only reported.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathDoctor(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := DoctorParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	if params.Repair && req.Operation != logical.UpdateOperation {
+		return errorResponse(InvalidParametersError, fmt.Errorf("repair requires a write"))
+	}
+
+	operators, err := listDoctorOperators(ctx, req.Storage)
+	if err != nil {
+		return errorResponse(DoctorFailedError, err)
+	}
+	if params.Operator != "" {
+		operators = []string{params.Operator}
+	}
+
+	result := &DoctorData{
+		Repair:   params.Repair,
+		Problems: []DoctorProblem{},
+	}
+	for _, operator := range operators {
+		report, err := b.doctorOperator(ctx, req.Storage, operator, params.Repair)
+		if err != nil {
+			return errorResponse(DoctorFailedError, fmt.Errorf("operator %s: %w", operator, err))
+		}
+		result.Operators++
+		result.Accounts += report.accounts
+		result.Users += report.users
+		for _, finding := range report.findings {
+			result.Problems = append(result.Problems, finding.problem)
+		}
+	}
+
+	resp, err := createResponseDoctorData(result)
+	if err != nil {
+		return errorResponse(DoctorFailedError, err)
+	}
+	return resp, nil
+}
+
+// listDoctorOperators lists the operators with issues, nkeys or JWTs
+func listDoctorOperators(ctx context.Context, storage logical.Storage) ([]string, error) {
+	keys, err := listMountKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	operators := map[string]bool{}
+	for _, key := range keys {
+		parts := strings.Split(key, "/")
+		if len(parts) < 3 || parts[1] != "operator" {
+			continue
+		}
+		switch parts[0] {
+		case "issue", "nkey", "jwt":
+			operators[parts[2]] = true
+		}
+	}
+	return sortedKeys(operators), nil
+}
+
+// doctorOperator checks the operator under its lock. With repair the
+// findings are repaired and the operator is checked again, problems still
+// found are reported unrepaired.
+func (b *NatsBackend) doctorOperator(ctx context.Context, storage logical.Storage, operator string, repair bool) (*doctorReport, error) {
+	defer b.lockOperator(operator)()
+
+	report, err := checkOperator(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	if !repair || len(report.findings) == 0 {
+		return report, nil
+	}
+
+	repairErrors := map[string]string{}
+	for _, finding := range report.findings {
+		if finding.repair == nil {
+			continue
+		}
+		if _, done := repairErrors[finding.repairKey]; done {
+			continue
+		}
+		repairErrors[finding.repairKey] = ""
+		err := finding.repair(ctx, storage)
+		if err != nil {
+			log.Warn().Err(err).Str("operator", operator).Str("path", finding.problem.Path).Msg("doctor repair failed")
+			repairErrors[finding.repairKey] = err.Error()
+		}
+	}
+
+	after, err := checkOperator(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	remaining := map[DoctorProblem]bool{}
+	for _, finding := range after.findings {
+		remaining[DoctorProblem{Path: finding.problem.Path, Check: finding.problem.Check}] = true
+	}
+	found := map[DoctorProblem]bool{}
+	for i := range report.findings {
+		finding := &report.findings[i]
+		key := DoctorProblem{Path: finding.problem.Path, Check: finding.problem.Check}
+		found[key] = true
+		if !remaining[key] {
+			finding.problem.Repaired = true
+			continue
+		}
+		finding.problem.RepairError = repairErrors[finding.repairKey]
+		if finding.repair == nil {
+			finding.problem.RepairError = "not repaired: " + finding.manual
+		}
+	}
+	// problems caused by the repair, e.g. of a failed re-issue
+	for _, finding := range after.findings {
+		if !found[DoctorProblem{Path: finding.problem.Path, Check: finding.problem.Check}] {
+			report.findings = append(report.findings, finding)
+		}
+	}
+	log.Info().Str("operator", operator).Int("problems", len(report.findings)).Msg("operator repaired")
+	return report, nil
+}
+
+// checkOperator checks the operator, its accounts and users and the nkeys
+// and JWTs stored below the operator
+func checkOperator(ctx context.Context, storage logical.Storage, operator string) (*doctorReport, error) {
+	report := &doctorReport{}
+
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// public keys of the operator as signed into its JWT, accounts must be issued by one of them
+	operatorKeys := map[string]bool{}
+	if issue != nil {
+		operatorKeys, err = checkOperatorIssue(ctx, storage, report, issue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	accounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range accounts {
+		account, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: operator,
+			Account:  name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if account == nil {
+			continue
+		}
+		report.accounts++
+		err = checkAccountIssue(ctx, storage, report, account, operatorKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		users, err := listUserIssues(ctx, storage, IssueUserParameters{
+			Operator: operator,
+			Account:  name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range users {
+			user, err := readUserIssue(ctx, storage, IssueUserParameters{
+				Operator: operator,
+				Account:  account.Account,
+				User:     name,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if user == nil {
+				continue
+			}
+			report.users++
+			err = checkUserIssue(ctx, storage, report, user, account)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = checkOrphans(ctx, storage, report, operator)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func checkOperatorIssue(ctx context.Context, storage logical.Storage, report *doctorReport, issue *IssueOperatorStorage) (map[string]bool, error) {
+	issuePath := getOperatorIssuePath(issue.Operator)
+	repair := func(ctx context.Context, storage logical.Storage) error {
+		// an earlier repair may have updated the issue
+		issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: issue.Operator,
+		})
+		if err != nil || issue == nil {
+			return err
+		}
+		return refreshOperator(ctx, storage, issue)
+	}
+
+	nkeyPath := getOperatorNkeyPath(issue.Operator)
+	publicKey, err := readPublicKey(ctx, storage, nkeyPath)
+	if err != nil {
+		return nil, err
+	}
+	// re-issuing creates a new operator key, invalidating every account
+	// signed by it, so nothing is repaired until the nkey is restored
+	if publicKey == "" {
+		report.addManual(nkeyPath, doctorCheckMissingNkey, "restore the operator nkey, e.g. from a backup or tombstone",
+			"operator nkey does not exist")
+	}
+	addFinding := func(path string, check string, format string, args ...interface{}) {
+		if publicKey == "" {
+			report.addManual(path, check, "restore the operator nkey first", format, args...)
+			return
+		}
+		report.add(path, check, issuePath, repair, format, args...)
+	}
+
+	signingKeys := map[string]bool{}
+	for _, name := range issue.Claims.SigningKeys {
+		path := getOperatorSigningNkeyPath(issue.Operator, name)
+		signingKey, err := readPublicKey(ctx, storage, path)
+		if err != nil {
+			return nil, err
+		}
+		if signingKey == "" {
+			addFinding(path, doctorCheckMissingNkey, "operator signing nkey %s does not exist", name)
+			continue
+		}
+		signingKeys[signingKey] = true
+	}
+
+	if issue.CreateSystemAccount {
+		sysIssue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: issue.Operator,
+			Account:  DefaultSysAccountName,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sysIssue == nil {
+			addFinding(getAccountIssuePath(issue.Operator, DefaultSysAccountName), doctorCheckSystemAccount,
+				"system account %s does not exist", DefaultSysAccountName)
+		}
+	}
+	systemAccount, err := readPublicKey(ctx, storage, getAccountNkeyPath(issue.Operator, DefaultSysAccountName))
+	if err != nil {
+		return nil, err
+	}
+
+	jwtPath := getOperatorJWTPath(issue.Operator)
+	stored, err := readJWT(ctx, storage, jwtPath)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		addFinding(jwtPath, doctorCheckMissingJWT, "operator jwt does not exist")
+		return map[string]bool{}, nil
+	}
+	// verifies the signature against the issuer
+	claims, err := jwt.DecodeOperatorClaims(stored.JWT)
+	if err != nil {
+		addFinding(jwtPath, doctorCheckInvalidJWT, "operator jwt is invalid: %s", err)
+		return map[string]bool{}, nil
+	}
+
+	if publicKey != "" && claims.Subject != publicKey {
+		addFinding(jwtPath, doctorCheckSubject, "subject %s is not the operator nkey %s", claims.Subject, publicKey)
+	}
+	if claims.Issuer != claims.Subject {
+		addFinding(jwtPath, doctorCheckIssuer, "issuer %s is not the subject, operator jwts are self-signed", claims.Issuer)
+	}
+	if !sameKeys(claims.SigningKeys, signingKeys) {
+		addFinding(jwtPath, doctorCheckSigningKeys, "signing keys %v are not the operator signing nkeys %v",
+			sortedStrings(claims.SigningKeys), sortedKeys(signingKeys))
+	}
+	if claims.SystemAccount != systemAccount {
+		addFinding(jwtPath, doctorCheckSystemAccount, "system account %q is not the nkey %q of account %s",
+			claims.SystemAccount, systemAccount, DefaultSysAccountName)
+	}
+
+	operatorKeys := map[string]bool{claims.Subject: true}
+	for _, signingKey := range claims.SigningKeys {
+		operatorKeys[signingKey] = true
+	}
+	return operatorKeys, nil
+}
+
+func checkAccountIssue(ctx context.Context, storage logical.Storage, report *doctorReport, issue *IssueAccountStorage, operatorKeys map[string]bool) error {
+	issuePath := getAccountIssuePath(issue.Operator, issue.Account)
+	repair := func(ctx context.Context, storage logical.Storage) error {
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: issue.Operator,
+			Account:  issue.Account,
+		})
+		if err != nil || issue == nil {
+			return err
+		}
+		return refreshAccount(ctx, storage, issue)
+	}
+
+	nkeyPath := getAccountNkeyPath(issue.Operator, issue.Account)
+	publicKey, err := readPublicKey(ctx, storage, nkeyPath)
+	if err != nil {
+		return err
+	}
+	// re-issuing creates a new account key, invalidating the creds of its
+	// users and the imports of other accounts, so nothing is repaired until
+	// the nkey is restored
+	if publicKey == "" {
+		report.addManual(nkeyPath, doctorCheckMissingNkey, "restore the account nkey, e.g. from a backup or tombstone",
+			"account nkey does not exist")
+	}
+	addFinding := func(path string, check string, format string, args ...interface{}) {
+		if publicKey == "" {
+			report.addManual(path, check, "restore the account nkey first", format, args...)
+			return
+		}
+		report.add(path, check, issuePath, repair, format, args...)
+	}
+
+	signingKeys := map[string]bool{}
+	for _, name := range issue.Claims.SigningKeys {
+		path := getAccountSigningNkeyPath(issue.Operator, issue.Account, name)
+		signingKey, err := readPublicKey(ctx, storage, path)
+		if err != nil {
+			return err
+		}
+		if signingKey == "" {
+			addFinding(path, doctorCheckMissingNkey, "account signing nkey %s does not exist", name)
+			continue
+		}
+		signingKeys[signingKey] = true
+	}
+
+	// the key the account is signed with
+	issuerPath := getOperatorNkeyPath(issue.Operator)
+	if issue.UseSigningKey != "" {
+		issuerPath = getOperatorSigningNkeyPath(issue.Operator, issue.UseSigningKey)
+	}
+	issuerKey, err := readPublicKey(ctx, storage, issuerPath)
+	if err != nil {
+		return err
+	}
+	if issuerKey == "" && issue.UseSigningKey != "" {
+		// refreshing the account fails as well, useSigningKey must be changed
+		report.addManual(issuePath, doctorCheckIssuer, "useSigningKey of the account needs to be changed",
+			"operator signing key %s does not exist", issue.UseSigningKey)
+	}
+
+	jwtPath := getAccountJWTPath(issue.Operator, issue.Account)
+	stored, err := readJWT(ctx, storage, jwtPath)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		addFinding(jwtPath, doctorCheckMissingJWT, "account jwt does not exist")
+		return nil
+	}
+	claims, err := jwt.DecodeAccountClaims(stored.JWT)
+	if err != nil {
+		addFinding(jwtPath, doctorCheckInvalidJWT, "account jwt is invalid: %s", err)
+		return nil
+	}
+
+	if publicKey != "" && claims.Subject != publicKey {
+		addFinding(jwtPath, doctorCheckSubject, "subject %s is not the account nkey %s", claims.Subject, publicKey)
+	}
+	switch {
+	case !operatorKeys[claims.Issuer]:
+		addFinding(jwtPath, doctorCheckIssuer, "issuer %s is not a key of the operator", claims.Issuer)
+	case issuerKey != "" && claims.Issuer != issuerKey:
+		addFinding(jwtPath, doctorCheckIssuer, "issuer %s is not the signing nkey %s", claims.Issuer, issuerKey)
+	}
+	if !sameKeys(claims.SigningKeys.Keys(), signingKeys) {
+		addFinding(jwtPath, doctorCheckSigningKeys, "signing keys %v are not the account signing nkeys %v",
+			sortedStrings(claims.SigningKeys.Keys()), sortedKeys(signingKeys))
+	}
+	return nil
+}
+
+func checkUserIssue(ctx context.Context, storage logical.Storage, report *doctorReport, issue *IssueUserStorage, account *IssueAccountStorage) error {
+	issuePath := getUserIssuePath(issue.Operator, issue.Account, issue.User)
+
+	nkeyPath := getUserNkeyPath(issue.Operator, issue.Account, issue.User)
+	publicKey, err := readPublicKey(ctx, storage, nkeyPath)
+	if err != nil {
+		return err
+	}
+	if publicKey == "" {
+		report.add(nkeyPath, doctorCheckMissingNkey, issuePath, func(ctx context.Context, storage logical.Storage) error {
+			issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+				Operator: issue.Operator,
+				Account:  issue.Account,
+				User:     issue.User,
+			})
+			if err != nil || issue == nil {
+				return err
+			}
+			return refreshUser(ctx, storage, issue)
+		}, "user nkey does not exist")
+	}
+
+	if issue.UseSigningKey == "" {
+		return nil
+	}
+	member := false
+	for _, name := range account.Claims.SigningKeys {
+		member = member || name == issue.UseSigningKey
+	}
+	if !member {
+		// creds can't be generated until useSigningKey is changed
+		report.addManual(issuePath, doctorCheckIssuer, "useSigningKey of the user needs to be changed",
+			"signing key %s is not a signing key of account %s", issue.UseSigningKey, issue.Account)
+	}
+	return nil
+}
+
+// checkOrphans reports the nkeys and JWTs below the operator without an
+// issue they belong to
+func checkOrphans(ctx context.Context, storage logical.Storage, report *doctorReport, operator string) error {
+	owned := map[string]bool{}
+
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return err
+	}
+	if issue != nil {
+		owned[getOperatorNkeyPath(operator)] = true
+		owned[getOperatorJWTPath(operator)] = true
+		for _, name := range issue.Claims.SigningKeys {
+			owned[getOperatorSigningNkeyPath(operator, name)] = true
+		}
+	}
+
+	accounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return err
+	}
+	for _, name := range accounts {
+		account, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: operator,
+			Account:  name,
+		})
+		if err != nil {
+			return err
+		}
+		if account == nil {
+			continue
+		}
+		owned[getAccountNkeyPath(operator, name)] = true
+		owned[getAccountJWTPath(operator, name)] = true
+		for _, signing := range account.Claims.SigningKeys {
+			owned[getAccountSigningNkeyPath(operator, name, signing)] = true
+		}
+		users, err := listUserIssues(ctx, storage, IssueUserParameters{
+			Operator: operator,
+			Account:  name,
+		})
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			owned[getUserNkeyPath(operator, name, user)] = true
+		}
+	}
+
+	for _, prefix := range []string{getOperatorNkeyPath(operator), getOperatorJWTPath(operator)} {
+		keys, err := storedKeysBelow(ctx, storage, prefix)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if owned[key] {
+				continue
+			}
+			// nkeys and JWTs may be stored before their issue is created or
+			// adopted, so they are never deleted by the doctor
+			report.addManual(key, doctorCheckOrphan, "create or adopt the issue, or delete the entry",
+				"entry does not belong to an issue")
+		}
+	}
+	return nil
+}
+
+// storedKeysBelow returns the key itself, if it exists, and all keys below it
+func storedKeysBelow(ctx context.Context, storage logical.Storage, key string) ([]string, error) {
+	keys, err := logical.CollectKeysWithPrefix(ctx, storage, key+"/")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		keys = append([]string{key}, keys...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// readPublicKey returns the public key of the nkey stored at path, empty if
+// it does not exist
+func readPublicKey(ctx context.Context, storage logical.Storage, path string) (string, error) {
+	nkey, err := readNkey(ctx, storage, path)
+	if err != nil || nkey == nil {
+		return "", err
+	}
+	d, err := toNkeyData(nkey)
+	if err != nil {
+		return "", err
+	}
+	return d.PublicKey, nil
+}
+
+func sameKeys(keys []string, expected map[string]bool) bool {
+	if len(keys) != len(expected) {
+		return false
+	}
+	for _, key := range keys {
+		if !expected[key] {
+			return false
+		}
+	}
+	return true
+}
+
+func createResponseDoctorData(data *DoctorData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
@@ -0,0 +1,260 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+func TestDoctor(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*NatsBackend, logical.Storage) {
+		t.Helper()
+		b, reqStorage := getTestBackend(t)
+		for _, setup := range []struct {
+			path string
+			data map[string]interface{}
+		}{
+			{"issue/operator/op1", map[string]interface{}{
+				"createSystemAccount": true,
+				"claims": map[string]interface{}{
+					"operator": map[string]interface{}{
+						"signingKeys": []interface{}{"opsk1"},
+					},
+				},
+			}},
+			{"issue/operator/op1/account/ac1", map[string]interface{}{
+				"useSigningKey": "opsk1",
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"signingKeys": []interface{}{"acsk1"},
+					},
+				},
+			}},
+			{"issue/operator/op1/account/ac1/user/us1", map[string]interface{}{
+				"useSigningKey": "acsk1",
+			}},
+		} {
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      setup.path,
+				Storage:   reqStorage,
+				Data:      setup.data,
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+		return b, reqStorage
+	}
+
+	doctor := func(t *testing.T, b *NatsBackend, storage logical.Storage, data map[string]interface{}) DoctorData {
+		t.Helper()
+		var operation logical.Operation = logical.ReadOperation
+		if data["repair"] == true {
+			operation = logical.UpdateOperation
+		}
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: operation,
+			Path:      "doctor",
+			Storage:   storage,
+			Data:      data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		var result DoctorData
+		require.NoError(t, stm.MapToStruct(resp.Data, &result))
+		return result
+	}
+
+	checks := func(problems []DoctorProblem) map[string]string {
+		result := map[string]string{}
+		for _, problem := range problems {
+			result[problem.Path] = problem.Check
+		}
+		return result
+	}
+
+	replaceNkey := func(t *testing.T, storage logical.Storage, path string, prefix nkeys.PrefixByte) {
+		t.Helper()
+		seed, err := createSeed(prefix)
+		require.NoError(t, err)
+		require.NoError(t, storeInStorage(ctx, storage, path, &NKeyStorage{Seed: seed}))
+	}
+
+	t.Run("consistent mount", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		result := doctor(t, b, reqStorage, nil)
+		assert.Equal(t, 1, result.Operators)
+		assert.Equal(t, 2, result.Accounts)
+		assert.Equal(t, 2, result.Users)
+		assert.Empty(t, result.Problems)
+	})
+
+	t.Run("account subject does not match nkey", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		replaceNkey(t, reqStorage, getAccountNkeyPath("op1", "ac1"), nkeys.PrefixByteAccount)
+
+		result := doctor(t, b, reqStorage, map[string]interface{}{"operator": "op1"})
+		require.Len(t, result.Problems, 1)
+		assert.Equal(t, getAccountJWTPath("op1", "ac1"), result.Problems[0].Path)
+		assert.Equal(t, doctorCheckSubject, result.Problems[0].Check)
+		assert.False(t, result.Problems[0].Repaired)
+
+		result = doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		require.Len(t, result.Problems, 1)
+		assert.True(t, result.Problems[0].Repaired)
+		assert.Empty(t, doctor(t, b, reqStorage, nil).Problems)
+	})
+
+	t.Run("deleted operator signing key", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		require.NoError(t, reqStorage.Delete(ctx, getOperatorSigningNkeyPath("op1", "opsk1")))
+
+		result := doctor(t, b, reqStorage, nil)
+		assert.Equal(t, map[string]string{
+			getOperatorSigningNkeyPath("op1", "opsk1"): doctorCheckMissingNkey,
+			getOperatorJWTPath("op1"):                  doctorCheckSigningKeys,
+			getAccountIssuePath("op1", "ac1"):          doctorCheckIssuer,
+		}, checks(result.Problems))
+
+		// a new signing key is issued and the account is signed with it
+		result = doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		for _, problem := range result.Problems {
+			assert.True(t, problem.Repaired, "%s %s", problem.Path, problem.Check)
+		}
+		assert.Empty(t, doctor(t, b, reqStorage, nil).Problems)
+	})
+
+	t.Run("account issued by unknown key", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		// the signing key was replaced but the account was not re-signed
+		replaceNkey(t, reqStorage, getOperatorSigningNkeyPath("op1", "opsk1"), nkeys.PrefixByteOperator)
+		opIssue, err := readOperatorIssue(ctx, reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		require.NoError(t, issueOperatorJWT(ctx, reqStorage, *opIssue))
+
+		result := doctor(t, b, reqStorage, nil)
+		require.Len(t, result.Problems, 1)
+		assert.Equal(t, getAccountJWTPath("op1", "ac1"), result.Problems[0].Path)
+		assert.Equal(t, doctorCheckIssuer, result.Problems[0].Check)
+		assert.Contains(t, result.Problems[0].Message, "is not a key of the operator")
+
+		result = doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		require.Len(t, result.Problems, 1)
+		assert.True(t, result.Problems[0].Repaired)
+	})
+
+	t.Run("system account reference", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		replaceNkey(t, reqStorage, getAccountNkeyPath("op1", DefaultSysAccountName), nkeys.PrefixByteAccount)
+
+		result := doctor(t, b, reqStorage, nil)
+		assert.Equal(t, map[string]string{
+			getOperatorJWTPath("op1"):                       doctorCheckSystemAccount,
+			getAccountJWTPath("op1", DefaultSysAccountName): doctorCheckSubject,
+		}, checks(result.Problems))
+
+		doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		assert.Empty(t, doctor(t, b, reqStorage, nil).Problems)
+	})
+
+	t.Run("missing user nkey and unknown user signing key", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		require.NoError(t, reqStorage.Delete(ctx, getUserNkeyPath("op1", "ac1", "us1")))
+		user, err := readUserIssue(ctx, reqStorage, IssueUserParameters{Operator: "op1", Account: "ac1", User: "us1"})
+		require.NoError(t, err)
+		user.UseSigningKey = "acsk2"
+		_, err = storeUserIssueUpdate(ctx, reqStorage, user)
+		require.NoError(t, err)
+
+		result := doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		assert.Equal(t, map[string]string{
+			getUserNkeyPath("op1", "ac1", "us1"):  doctorCheckMissingNkey,
+			getUserIssuePath("op1", "ac1", "us1"): doctorCheckIssuer,
+		}, checks(result.Problems))
+		for _, problem := range result.Problems {
+			if problem.Check == doctorCheckIssuer {
+				assert.False(t, problem.Repaired)
+				assert.NotEmpty(t, problem.RepairError)
+			} else {
+				assert.True(t, problem.Repaired)
+			}
+		}
+	})
+
+	t.Run("missing account nkey is not re-issued", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		require.NoError(t, reqStorage.Delete(ctx, getAccountNkeyPath("op1", "ac1")))
+		before, err := reqStorage.Get(ctx, getAccountJWTPath("op1", "ac1"))
+		require.NoError(t, err)
+
+		// a new key would invalidate the creds of the users
+		result := doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		assert.Equal(t, map[string]string{
+			getAccountNkeyPath("op1", "ac1"): doctorCheckMissingNkey,
+		}, checks(result.Problems))
+		assert.False(t, result.Problems[0].Repaired)
+		assert.Contains(t, result.Problems[0].RepairError, "restore the account nkey")
+
+		nkey, err := readAccountNkey(ctx, reqStorage, NkeyParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Nil(t, nkey)
+		after, err := reqStorage.Get(ctx, getAccountJWTPath("op1", "ac1"))
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("orphaned entries", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		seed, err := createSeed(nkeys.PrefixByteAccount)
+		require.NoError(t, err)
+		orphans := []string{
+			getAccountNkeyPath("op1", "gone"),
+			getUserNkeyPath("op1", "gone", "us1"),
+			"jwt/operator/op1/account/ac1/user/us1",
+			getOperatorNkeyPath("op2"),
+		}
+		for _, key := range orphans {
+			require.NoError(t, storeInStorage(ctx, reqStorage, key, &NKeyStorage{Seed: seed}))
+		}
+
+		result := doctor(t, b, reqStorage, nil)
+		assert.Equal(t, 2, result.Operators)
+		expected := map[string]string{}
+		for _, key := range orphans {
+			expected[key] = doctorCheckOrphan
+		}
+		assert.Equal(t, expected, checks(result.Problems))
+
+		// seeds may be stored before their issue is created or adopted
+		result = doctor(t, b, reqStorage, map[string]interface{}{"repair": true})
+		for _, problem := range result.Problems {
+			assert.False(t, problem.Repaired)
+			assert.Contains(t, problem.RepairError, "not repaired")
+		}
+		for _, key := range orphans {
+			entry, err := reqStorage.Get(ctx, key)
+			require.NoError(t, err)
+			assert.NotNil(t, entry, key)
+		}
+	})
+
+	t.Run("repair requires a write", func(t *testing.T) {
+		b, reqStorage := setup(t)
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "doctor",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"repair": true},
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+	})
+}
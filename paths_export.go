@@ -0,0 +1,261 @@
+package natsbackend
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/nsc"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+// ExportNscParameters selects the operator to export. Keys are only exported
+// together with the curve public key the archive is encrypted for.
+type ExportNscParameters struct {
+	Operator  string `json:"operator"`
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+type ExportNscData struct {
+	Operator string `json:"operator"`
+	// Archive is the base64 encoded tar archive
+	Archive   string `json:"archive"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+func pathExport(b *NatsBackend) []*framework.Path {
+	operatorField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "operator identifier",
+		Required:    false,
+	}
+	return []*framework.Path{
+		{
+			Pattern: "export/nsc/" + framework.GenericNameRegex("operator") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathExportNsc,
+				},
+			},
+			HelpSynopsis:    `Exports the JWTs of an operator as nsc store.`,
+			HelpDescription: `Returns a base64 encoded tar archive with the operator, account and user JWTs in the layout of the nsc data directory.`,
+		},
+		{
+			Pattern: "export/nsc/" + framework.GenericNameRegex("operator") + "/keys$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"publicKey": {
+					Type:        framework.TypeString,
+					Description: "Curve (xkey) public key the archive is encrypted for",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathExportNscKeys,
+				},
+			},
+			HelpSynopsis:    `Exports an operator as nsc store including its keys directory.`,
+			HelpDescription: `Returns the nsc store together with the seeds and user creds, encrypted as NaCl anonymous box for the given curve public key.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathExportNsc(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params ExportNscParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	return b.exportNsc(ctx, req.Storage, params)
+}
+
+func (b *NatsBackend) pathExportNscKeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params ExportNscParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	if params.PublicKey == "" {
+		return errorResponse(InvalidParametersError, fmt.Errorf("publicKey is required to export keys"))
+	}
+
+	return b.exportNsc(ctx, req.Storage, params)
+}
+
+func (b *NatsBackend) exportNsc(ctx context.Context, storage logical.Storage, params ExportNscParameters) (*logical.Response, error) {
+	// the operator lock keeps the exported entries consistent
+	defer b.lockOperator(params.Operator)()
+
+	withKeys := params.PublicKey != ""
+	files, warnings, err := collectNscFiles(ctx, storage, params.Operator, withKeys)
+	if err != nil {
+		return errorResponse(ExportFailedError, err)
+	}
+	archive, err := nsc.WriteArchive(files)
+	if err != nil {
+		return errorResponse(ExportFailedError, err)
+	}
+	if withKeys {
+		archive, err = nsc.SealArchive(archive, params.PublicKey)
+		if err != nil {
+			return errorResponse(ExportFailedError, errInvalid("%w", err))
+		}
+		log.Info().Str("operator", params.Operator).Msg("operator exported with keys")
+	}
+
+	resp, err := createResponseExportNscData(&ExportNscData{
+		Operator:  params.Operator,
+		Archive:   base64.StdEncoding.EncodeToString(archive),
+		Encrypted: withKeys,
+	})
+	if err != nil {
+		return errorResponse(ExportFailedError, err)
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+// collectNscFiles gathers the JWTs of the operator, its accounts and users
+// by their path in the nsc data directory, and with keys the seeds and user
+// creds. User JWTs are generated from their claims templates.
+func collectNscFiles(ctx context.Context, storage logical.Storage, operator string, withKeys bool) (map[string][]byte, []string, error) {
+	files := map[string][]byte{}
+	var warnings []string
+
+	opJWT, err := readOperatorJWT(ctx, storage, JWTParameters{Operator: operator})
+	if err != nil {
+		return nil, nil, err
+	}
+	if opJWT == nil {
+		return nil, nil, errNotFound("operator jwt does not exist: %s", operator)
+	}
+	files[nsc.OperatorJWTPath(operator)] = []byte(opJWT.JWT)
+	files[nsc.OperatorInfoPath(operator)] = nsc.OperatorInfo(operator)
+
+	addKey := func(path string) error {
+		if !withKeys {
+			return nil
+		}
+		nkey, err := readNkey(ctx, storage, path)
+		if err != nil {
+			return err
+		}
+		if nkey == nil {
+			return nil
+		}
+		data, err := toNkeyData(nkey)
+		if err != nil {
+			return err
+		}
+		files[nsc.KeyPath(data.PublicKey)] = nkey.Seed
+		return nil
+	}
+
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{Operator: operator})
+	if err != nil {
+		return nil, nil, err
+	}
+	if issue == nil {
+		return nil, nil, errNotFound("operator issue does not exist: %s", operator)
+	}
+	err = addKey(getOperatorNkeyPath(operator))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, signingKey := range issue.Claims.SigningKeys {
+		err = addKey(getOperatorSigningNkeyPath(operator, signingKey))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	accounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, account := range accounts {
+		accJWT, err := readAccountJWT(ctx, storage, JWTParameters{Operator: operator, Account: account})
+		if err != nil {
+			return nil, nil, err
+		}
+		accIssue, err := readAccountIssue(ctx, storage, IssueAccountParameters{Operator: operator, Account: account})
+		if err != nil {
+			return nil, nil, err
+		}
+		if accJWT == nil || accIssue == nil {
+			warnings = append(warnings, fmt.Sprintf("account %s skipped: not issued", account))
+			continue
+		}
+		files[nsc.AccountJWTPath(operator, account)] = []byte(accJWT.JWT)
+		err = addKey(getAccountNkeyPath(operator, account))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, signingKey := range accIssue.Claims.SigningKeys {
+			err = addKey(getAccountSigningNkeyPath(operator, account, signingKey))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		users, err := listUserIssues(ctx, storage, IssueUserParameters{Operator: operator, Account: account})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, user := range users {
+			creds, err := generateUserCreds(ctx, storage, UserCredsParameters{
+				Operator: operator,
+				Account:  account,
+				User:     user,
+			})
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("user %s/%s skipped: %s", account, user, err))
+				continue
+			}
+			userJWT, err := jwt.ParseDecoratedJWT([]byte(creds.Creds))
+			if err != nil {
+				return nil, nil, err
+			}
+			files[nsc.UserJWTPath(operator, account, user)] = []byte(userJWT)
+			if withKeys {
+				files[nsc.CredsPath(operator, account, user)] = []byte(creds.Creds)
+				err = addKey(getUserNkeyPath(operator, account, user))
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return files, warnings, nil
+}
+
+func createResponseExportNscData(data *ExportNscData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
@@ -0,0 +1,177 @@
+package natsbackend
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/nsc"
+)
+
+func TestExportNsc(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{
+			"createSystemAccount": true,
+			"claims": map[string]interface{}{
+				"operator": map[string]interface{}{"signingKeys": []interface{}{"opsk1"}},
+			},
+		}},
+		{"issue/operator/op1/account/ac1", map[string]interface{}{"useSigningKey": "opsk1"}},
+		{"issue/operator/op1/account/ac1/user/us1", map[string]interface{}{}},
+		{"issue/operator/op1/account/ac1/user/templated", map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"user": map[string]interface{}{
+					"pub": map[string]interface{}{"allow": []interface{}{"{{subject}}"}},
+				},
+			},
+		}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	readArchive := func(resp *logical.Response) []byte {
+		archive, err := base64.StdEncoding.DecodeString(resp.Data["archive"].(string))
+		require.NoError(t, err)
+		return archive
+	}
+	accountPublicKey := func(storage logical.Storage) string {
+		accJWT, err := readAccountJWT(context.Background(), storage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		return claims.Subject
+	}
+
+	t.Run("store without keys", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "export/nsc/op1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, false, resp.Data["encrypted"])
+		// the templated user needs parameters and is skipped
+		assert.Len(t, resp.Warnings, 1)
+
+		files := map[string][]byte{}
+		tr := tar.NewReader(bytes.NewReader(readArchive(resp)))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			content, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = content
+		}
+		assert.Contains(t, files, "stores/op1/op1.jwt")
+		assert.Contains(t, files, "stores/op1/accounts/sys/sys.jwt")
+		assert.Contains(t, files, "stores/op1/accounts/sys/users/default-push.jwt")
+		assert.Contains(t, files, "stores/op1/accounts/ac1/users/us1.jwt")
+		assert.NotContains(t, files, "stores/op1/accounts/ac1/users/templated.jwt")
+		for name := range files {
+			assert.NotContains(t, name, "keys/", "keys are not exported")
+		}
+
+		claims, err := jwt.DecodeAccountClaims(string(files["stores/op1/accounts/ac1/ac1.jwt"]))
+		require.NoError(t, err)
+		assert.Equal(t, accountPublicKey(reqStorage), claims.Subject)
+		assert.Equal(t, "ac1", claims.Name)
+	})
+
+	t.Run("keys are encrypted for the caller and can be imported again", func(t *testing.T) {
+		curve, err := nkeys.CreateCurveKeys()
+		require.NoError(t, err)
+		curvePublicKey, err := curve.PublicKey()
+		require.NoError(t, err)
+		curveSeed, err := curve.Seed()
+		require.NoError(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "export/nsc/op1/keys",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"publicKey": curvePublicKey},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, true, resp.Data["encrypted"])
+
+		sealed := readArchive(resp)
+		other, err := nkeys.CreateCurveKeys()
+		require.NoError(t, err)
+		otherSeed, err := other.Seed()
+		require.NoError(t, err)
+		_, err = nsc.OpenArchive(sealed, otherSeed)
+		assert.Error(t, err)
+
+		archive, err := nsc.OpenArchive(sealed, curveSeed)
+		require.NoError(t, err)
+		dir := t.TempDir()
+		require.NoError(t, nsc.ExtractArchive(archive, dir))
+		assert.FileExists(t, filepath.Join(dir, "keys", "creds", "op1", "ac1", "us1.creds"))
+
+		store, err := nsc.ReadStore(filepath.Join(dir, nsc.StoresDir), filepath.Join(dir, nsc.KeysDir), "op1")
+		require.NoError(t, err)
+		b2, storage2 := getTestBackend(t)
+		resp, err = b2.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/nsc/op1",
+			Storage:   storage2,
+			Data: map[string]interface{}{
+				"jwt":      store.JWT,
+				"accounts": store.Accounts,
+				"users":    store.Users,
+				"seeds":    store.Seeds,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, accountPublicKey(reqStorage), accountPublicKey(storage2))
+	})
+
+	t.Run("keys require a public key", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "export/nsc/op1/keys",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"publicKey": "invalid"},
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("unknown operator", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "export/nsc/op2",
+			Storage:   reqStorage,
+		})
+		assertErrorStatus(t, err, http.StatusNotFound)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
@@ -0,0 +1,391 @@
+package natsbackend
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+// importNameRegex matches the names accepted by framework.GenericNameRegex
+var importNameRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
+// ImportNscParameters carries the content of an nsc store: the JWTs of the
+// operator, its accounts and users and the seeds of the keys directory
+type ImportNscParameters struct {
+	Operator string   `json:"operator"`
+	JWT      string   `json:"jwt"`
+	Accounts []string `json:"accounts,omitempty"`
+	Users    []string `json:"users,omitempty"`
+	Seeds    []string `json:"seeds,omitempty"`
+}
+
+type ImportNscData struct {
+	Operator string   `json:"operator"`
+	Accounts []string `json:"accounts"`
+	Users    []string `json:"users"`
+}
+
+// nscImport is the validated content of an nsc store
+type nscImport struct {
+	operator string
+	claims   *jwt.OperatorClaims
+	accounts []*nscImportAccount
+	// seeds by public key
+	seeds    map[string][]byte
+	warnings []string
+}
+
+type nscImportAccount struct {
+	name   string
+	claims *jwt.AccountClaims
+	users  []*nscImportUser
+}
+
+type nscImportUser struct {
+	name   string
+	claims *jwt.UserClaims
+}
+
+func pathImport(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "import/nsc/" + framework.GenericNameRegex("operator") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier the store is imported as",
+					Required:    false,
+				},
+				"jwt": {
+					Type:        framework.TypeString,
+					Description: "operator JWT of the nsc store",
+					Required:    true,
+				},
+				"accounts": {
+					Type:        framework.TypeStringSlice,
+					Description: "account JWTs of the nsc store",
+					Required:    false,
+				},
+				"users": {
+					Type:        framework.TypeStringSlice,
+					Description: "user JWTs of the nsc store",
+					Required:    false,
+				},
+				"seeds": {
+					Type:        framework.TypeStringSlice,
+					Description: "seeds of the nsc keys directory",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathImportNsc,
+				},
+			},
+			HelpSynopsis:    `Imports an operator with its accounts and users from an nsc store.`,
+			HelpDescription: `The claims of the issues are rebuilt from the decoded JWTs and the nkeys are taken from the seeds, so the issued JWTs keep the subjects of the store. Users without seed are skipped.`,
+		},
+	}
+}
+
+func (b *NatsBackend) pathImportNsc(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	// the slices are read from the field data so comma separated lists are accepted
+	params := ImportNscParameters{
+		Operator: data.Get("operator").(string),
+		JWT:      data.Get("jwt").(string),
+		Accounts: data.Get("accounts").([]string),
+		Users:    data.Get("users").([]string),
+		Seeds:    data.Get("seeds").([]string),
+	}
+
+	imp, err := parseNscImport(params)
+	if err != nil {
+		return errorResponse(ImportFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	existing, err := readOperatorIssue(ctx, req.Storage, IssueOperatorParameters{
+		Operator: params.Operator,
+	})
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if existing != nil {
+		return errorResponse(ImportFailedError, errConflict("operator %s already exists", params.Operator))
+	}
+
+	// an interrupted import is rolled back by deleting the operator
+	// with everything imported so far
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Cascade:   true,
+	}, func() error {
+		err := importNsc(ctx, req.Storage, imp)
+		if err != nil {
+			if removeErr := removeNscImport(ctx, req.Storage, imp); removeErr != nil {
+				log.Error().Err(removeErr).Str("operator", params.Operator).Msg("cannot remove failed import")
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return errorResponse(ImportFailedError, err)
+	}
+
+	return createResponseImportNsc(imp)
+}
+
+// parseNscImport decodes the JWTs and seeds and checks that they form a
+// complete operator: every account is signed by the operator and every
+// user by its account, and all keys signing JWTs have a seed
+func parseNscImport(params ImportNscParameters) (*nscImport, error) {
+	imp := &nscImport{
+		operator: params.Operator,
+		seeds:    map[string][]byte{},
+	}
+
+	for _, s := range params.Seeds {
+		kp, err := nkeys.FromSeed([]byte(s))
+		if err != nil {
+			return nil, errInvalid("invalid seed: %w", err)
+		}
+		publicKey, err := kp.PublicKey()
+		if err != nil {
+			return nil, errInvalid("invalid seed: %w", err)
+		}
+		imp.seeds[publicKey] = []byte(s)
+	}
+
+	claims, err := jwt.DecodeOperatorClaims(params.JWT)
+	if err != nil {
+		return nil, errInvalid("cannot decode operator jwt: %w", err)
+	}
+	imp.claims = claims
+	operatorKeys := append([]string{claims.Subject}, claims.SigningKeys...)
+	for _, key := range operatorKeys {
+		if _, ok := imp.seeds[key]; !ok {
+			return nil, errInvalid("missing seed of operator key %s", key)
+		}
+	}
+
+	accounts := map[string]*nscImportAccount{}
+	names := map[string]bool{}
+	for _, token := range params.Accounts {
+		claims, err := jwt.DecodeAccountClaims(token)
+		if err != nil {
+			return nil, errInvalid("cannot decode account jwt: %w", err)
+		}
+		if !containsString(operatorKeys, claims.Issuer) {
+			return nil, errInvalid("account %s is not signed by the operator", claims.Name)
+		}
+		// the system account has a fixed name in the mount
+		name := claims.Name
+		if claims.Subject == imp.claims.SystemAccount {
+			if name != DefaultSysAccountName {
+				imp.warnings = append(imp.warnings, fmt.Sprintf("system account %s is imported as %s", name, DefaultSysAccountName))
+			}
+			name = DefaultSysAccountName
+		} else if name == DefaultSysAccountName {
+			return nil, errInvalid("account %s is reserved for the system account", name)
+		}
+		if !importNameRegex.MatchString(name) {
+			return nil, errInvalid("account name %q is not a valid identifier", name)
+		}
+		if names[name] {
+			return nil, errInvalid("duplicate account %s", name)
+		}
+		names[name] = true
+		for _, key := range append([]string{claims.Subject}, claims.SigningKeys.Keys()...) {
+			if _, ok := imp.seeds[key]; !ok {
+				return nil, errInvalid("missing seed of account %s key %s", name, key)
+			}
+			if scope, _ := claims.SigningKeys.GetScope(key); scope != nil {
+				imp.warnings = append(imp.warnings, fmt.Sprintf("account %s: scope of signing key %s is not imported", name, key))
+			}
+		}
+		account := &nscImportAccount{name: name, claims: claims}
+		accounts[claims.Subject] = account
+		imp.accounts = append(imp.accounts, account)
+	}
+
+	for _, token := range params.Users {
+		claims, err := jwt.DecodeUserClaims(token)
+		if err != nil {
+			return nil, errInvalid("cannot decode user jwt: %w", err)
+		}
+		issuerAccount := claims.IssuerAccount
+		if issuerAccount == "" {
+			issuerAccount = claims.Issuer
+		}
+		account, ok := accounts[issuerAccount]
+		if !ok {
+			return nil, errInvalid("user %s is not signed by an imported account", claims.Name)
+		}
+		if claims.Issuer != account.claims.Subject && !account.claims.SigningKeys.Contains(claims.Issuer) {
+			return nil, errInvalid("user %s is not signed by account %s", claims.Name, account.name)
+		}
+		if !importNameRegex.MatchString(claims.Name) {
+			return nil, errInvalid("user name %q is not a valid identifier", claims.Name)
+		}
+		for _, user := range account.users {
+			if user.name == claims.Name {
+				return nil, errInvalid("duplicate user %s in account %s", claims.Name, account.name)
+			}
+		}
+		if _, ok := imp.seeds[claims.Subject]; !ok {
+			imp.warnings = append(imp.warnings, fmt.Sprintf("user %s/%s skipped: missing seed", account.name, claims.Name))
+			continue
+		}
+		account.users = append(account.users, &nscImportUser{name: claims.Name, claims: claims})
+	}
+	return imp, nil
+}
+
+// importNsc stores the seeds and creates the issues. The issues reissue the
+// JWTs with the imported nkeys, reproducing the subjects of the store.
+func importNsc(ctx context.Context, storage logical.Storage, imp *nscImport) error {
+	log.Info().Str("operator", imp.operator).Msg("import nsc store")
+
+	operator := imp.operator
+	for _, seed := range imp.seedEntries() {
+		err := validateSeed(seed.seed, seed.kind)
+		if err != nil {
+			return err
+		}
+		err = storeInStorage(ctx, storage, seed.path, &NKeyStorage{Seed: seed.seed})
+		if err != nil {
+			return err
+		}
+	}
+
+	// signing keys are named by their public keys
+	err := addOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+		Claims:   adoptedOperatorClaims(imp.claims, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, account := range imp.accounts {
+		params := IssueAccountParameters{
+			Operator: operator,
+			Account:  account.name,
+			Claims:   adoptedAccountClaims(account.claims, nil),
+		}
+		if account.claims.Issuer != imp.claims.Subject {
+			params.UseSigningKey = account.claims.Issuer
+		}
+		err = addAccountIssue(ctx, storage, params)
+		if err != nil {
+			return fmt.Errorf("account %s: %w", account.name, err)
+		}
+	}
+
+	for _, account := range imp.accounts {
+		for _, user := range account.users {
+			params := IssueUserParameters{
+				Operator:       operator,
+				Account:        account.name,
+				User:           user.name,
+				ClaimsTemplate: adoptedUserClaims(user.claims),
+			}
+			if user.claims.Issuer != account.claims.Subject {
+				params.UseSigningKey = user.claims.Issuer
+			}
+			if user.claims.Expires > 0 && user.claims.Expires > user.claims.IssuedAt {
+				params.ExpirationS = user.claims.Expires - user.claims.IssuedAt
+			}
+			err = addUserIssue(ctx, storage, params)
+			if err != nil {
+				return fmt.Errorf("user %s/%s: %w", account.name, user.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+type nscImportSeed struct {
+	path string
+	seed []byte
+	kind string
+}
+
+// seedEntries maps the seeds of the imported keys to their nkey paths
+func (imp *nscImport) seedEntries() []nscImportSeed {
+	operator := imp.operator
+	seeds := []nscImportSeed{
+		{getOperatorNkeyPath(operator), imp.seeds[imp.claims.Subject], "operator"},
+	}
+	for _, key := range imp.claims.SigningKeys {
+		seeds = append(seeds, nscImportSeed{getOperatorSigningNkeyPath(operator, key), imp.seeds[key], "operator"})
+	}
+	for _, account := range imp.accounts {
+		seeds = append(seeds, nscImportSeed{getAccountNkeyPath(operator, account.name), imp.seeds[account.claims.Subject], "account"})
+		for _, key := range account.claims.SigningKeys.Keys() {
+			seeds = append(seeds, nscImportSeed{getAccountSigningNkeyPath(operator, account.name, key), imp.seeds[key], "account"})
+		}
+		for _, user := range account.users {
+			seeds = append(seeds, nscImportSeed{getUserNkeyPath(operator, account.name, user.name), imp.seeds[user.claims.Subject], "user"})
+		}
+	}
+	return seeds
+}
+
+// removeNscImport removes the issues and nkeys of a failed import
+func removeNscImport(ctx context.Context, storage logical.Storage, imp *nscImport) error {
+	_, err := deleteOperatorIssueCascade(ctx, storage, imp.operator)
+	if err != nil {
+		return err
+	}
+	for _, seed := range imp.seedEntries() {
+		err = deleteNkey(ctx, storage, seed.path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createResponseImportNsc(imp *nscImport) (*logical.Response, error) {
+	data := &ImportNscData{
+		Operator: imp.operator,
+		Accounts: []string{},
+		Users:    []string{},
+	}
+	for _, account := range imp.accounts {
+		data.Accounts = append(data.Accounts, account.name)
+		for _, user := range account.users {
+			data.Users = append(data.Users, account.name+"/"+user.name)
+		}
+	}
+	sort.Strings(data.Accounts)
+	sort.Strings(data.Users)
+
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	resp := &logical.Response{Data: rval}
+	for _, warning := range imp.warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
@@ -0,0 +1,220 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNscStore builds the content of an nsc store with an operator signing
+// key, a system account, an account signing users with its signing key and
+// a user whose seed is missing from the keys directory
+type testNscStore struct {
+	operator, operatorSigning nkeys.KeyPair
+	sys, account, accSigning  nkeys.KeyPair
+	user, sysUser, noSeedUser nkeys.KeyPair
+	data                      map[string]interface{}
+}
+
+func createTestNscStore(t *testing.T) *testNscStore {
+	t.Helper()
+	s := &testNscStore{}
+	pair := func(prefix nkeys.PrefixByte) nkeys.KeyPair {
+		kp, err := nkeys.CreatePair(prefix)
+		require.NoError(t, err)
+		return kp
+	}
+	public := func(kp nkeys.KeyPair) string {
+		pub, err := kp.PublicKey()
+		require.NoError(t, err)
+		return pub
+	}
+	encode := func(claims jwt.Claims, kp nkeys.KeyPair) string {
+		token, err := claims.Encode(kp)
+		require.NoError(t, err)
+		return token
+	}
+	s.operator = pair(nkeys.PrefixByteOperator)
+	s.operatorSigning = pair(nkeys.PrefixByteOperator)
+	s.sys = pair(nkeys.PrefixByteAccount)
+	s.account = pair(nkeys.PrefixByteAccount)
+	s.accSigning = pair(nkeys.PrefixByteAccount)
+	s.user = pair(nkeys.PrefixByteUser)
+	s.sysUser = pair(nkeys.PrefixByteUser)
+	s.noSeedUser = pair(nkeys.PrefixByteUser)
+
+	operatorClaims := jwt.NewOperatorClaims(public(s.operator))
+	operatorClaims.Name = "nscop"
+	operatorClaims.SigningKeys.Add(public(s.operatorSigning))
+	operatorClaims.SystemAccount = public(s.sys)
+
+	sysClaims := jwt.NewAccountClaims(public(s.sys))
+	sysClaims.Name = "SYS"
+	accountClaims := jwt.NewAccountClaims(public(s.account))
+	accountClaims.Name = "ac1"
+	accountClaims.SigningKeys.Add(public(s.accSigning))
+	accountClaims.Limits.Conn = 10
+
+	userClaims := jwt.NewUserClaims(public(s.user))
+	userClaims.Name = "us1"
+	userClaims.IssuerAccount = public(s.account)
+	userClaims.Pub.Allow.Add("foo.>")
+	sysUserClaims := jwt.NewUserClaims(public(s.sysUser))
+	sysUserClaims.Name = "sys"
+	noSeedUserClaims := jwt.NewUserClaims(public(s.noSeedUser))
+	noSeedUserClaims.Name = "us2"
+
+	seed := func(kp nkeys.KeyPair) string {
+		seed, err := kp.Seed()
+		require.NoError(t, err)
+		return string(seed)
+	}
+	s.data = map[string]interface{}{
+		"jwt": encode(operatorClaims, s.operator),
+		"accounts": []string{
+			encode(sysClaims, s.operator),
+			encode(accountClaims, s.operatorSigning),
+		},
+		"users": []string{
+			encode(userClaims, s.accSigning),
+			encode(sysUserClaims, s.sys),
+			encode(noSeedUserClaims, s.account),
+		},
+		"seeds": []string{
+			seed(s.operator), seed(s.operatorSigning), seed(s.sys), seed(s.account),
+			seed(s.accSigning), seed(s.user), seed(s.sysUser),
+		},
+	}
+	return s
+}
+
+func TestImportNsc(t *testing.T) {
+	publicKey := func(kp nkeys.KeyPair) string {
+		pub, err := kp.PublicKey()
+		require.NoError(t, err)
+		return pub
+	}
+
+	t.Run("issues reproduce the subjects of the store", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		store := createTestNscStore(t)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/nsc/op1",
+			Storage:   reqStorage,
+			Data:      store.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []interface{}{"ac1", "sys"}, resp.Data["accounts"])
+		assert.Equal(t, []interface{}{"ac1/us1", "sys/sys"}, resp.Data["users"])
+		assert.Len(t, resp.Warnings, 2)
+
+		opJWT, err := readOperatorJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1"})
+		require.NoError(t, err)
+		operatorClaims, err := jwt.DecodeOperatorClaims(opJWT.JWT)
+		require.NoError(t, err)
+		assert.Equal(t, publicKey(store.operator), operatorClaims.Subject)
+		assert.Equal(t, publicKey(store.sys), operatorClaims.SystemAccount)
+		assert.Equal(t, "nscop", operatorClaims.Name)
+		assert.Contains(t, operatorClaims.SigningKeys, publicKey(store.operatorSigning))
+
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		accountClaims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.Equal(t, publicKey(store.account), accountClaims.Subject)
+		assert.Equal(t, publicKey(store.operatorSigning), accountClaims.Issuer)
+		assert.True(t, accountClaims.SigningKeys.Contains(publicKey(store.accSigning)))
+		assert.Equal(t, int64(10), accountClaims.Limits.Conn)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		userJWT, err := jwt.ParseDecoratedJWT([]byte(resp.Data["creds"].(string)))
+		require.NoError(t, err)
+		userClaims, err := jwt.DecodeUserClaims(userJWT)
+		require.NoError(t, err)
+		assert.Equal(t, publicKey(store.user), userClaims.Subject)
+		assert.Equal(t, publicKey(store.accSigning), userClaims.Issuer)
+		assert.Equal(t, publicKey(store.account), userClaims.IssuerAccount)
+		assert.True(t, userClaims.Pub.Allow.Contains("foo.>"))
+
+		issue, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{
+			Operator: "op1",
+			Account:  "ac1",
+			User:     "us2",
+		})
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+	})
+
+	t.Run("existing operator is rejected", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/nsc/op1",
+			Storage:   reqStorage,
+			Data:      createTestNscStore(t).data,
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("missing account seed stores nothing", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		store := createTestNscStore(t)
+		seeds := store.data["seeds"].([]string)
+		store.data["seeds"] = seeds[:3]
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/nsc/op1",
+			Storage:   reqStorage,
+			Data:      store.data,
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+
+		// only the storage version of the mount is left
+		keys, err := logical.CollectKeys(context.Background(), reqStorage)
+		require.NoError(t, err)
+		assert.Equal(t, []string{storageVersionPath}, keys)
+	})
+
+	t.Run("account of another operator is rejected", func(t *testing.T) {
+		b, reqStorage := getTestBackend(t)
+		store := createTestNscStore(t)
+		other := createTestNscStore(t)
+		store.data["accounts"] = append(store.data["accounts"].([]string), other.data["accounts"].([]string)[1])
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "import/nsc/op1",
+			Storage:   reqStorage,
+			Data:      store.data,
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
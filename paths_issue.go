@@ -2,17 +2,24 @@ package natsbackend
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"regexp"
 
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 )
 
 func pathIssue(b *NatsBackend) []*framework.Path {
 	paths := []*framework.Path{}
 	paths = append(paths, pathOperatorIssue(b)...)
+	paths = append(paths, pathOperatorSigningKeyRotation(b)...)
 	paths = append(paths, pathAccountIssue(b)...)
+	paths = append(paths, pathAccountSigningKeyRotation(b)...)
 	paths = append(paths, pathUserIssue(b)...)
+	paths = append(paths, pathLinkIssue(b)...)
 	return paths
 }
 
@@ -30,3 +37,211 @@ func listIssues(ctx context.Context, storage logical.Storage, path string) ([]st
 	}
 	return issues, nil
 }
+
+// casField is the check-and-set field shared by all issue paths
+var casField = &framework.FieldSchema{
+	Type:        framework.TypeInt,
+	Description: "Version the issue must have for the write to succeed. 0 only allows creating a new issue",
+	Required:    false,
+}
+
+// deletionProtectionField refuses deletes of operator and account issues while set
+var deletionProtectionField = &framework.FieldSchema{
+	Type:        framework.TypeBool,
+	Description: "Refuse to delete the issue until this is cleared (default: false)",
+	Required:    false,
+}
+
+// checkOperatorDeletionProtection refuses the delete of a protected operator.
+// A cascading delete is also refused for protected accounts of the operator,
+// a plain delete for a protected system account deleted with the operator.
+func checkOperatorDeletionProtection(ctx context.Context, storage logical.Storage, operator string, cascade bool) error {
+	blockers, err := operatorDeletionBlockers(ctx, storage, operator, cascade)
+	if err != nil {
+		return err
+	}
+	if len(blockers) > 0 {
+		return errConflict("%s", blockers[0])
+	}
+	return nil
+}
+
+// operatorDeletionBlockers lists the deletion protections refusing the
+// delete of the operator, see checkOperatorDeletionProtection
+func operatorDeletionBlockers(ctx context.Context, storage logical.Storage, operator string, cascade bool) ([]string, error) {
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var blockers []string
+	if issue != nil && issue.DeletionProtection {
+		blockers = append(blockers, fmt.Sprintf("deletion protection is enabled for operator %s", operator))
+	}
+	var accounts []string
+	if cascade {
+		accounts, err = listAccountIssues(ctx, storage, operator)
+		if err != nil {
+			return nil, err
+		}
+	} else if issue != nil && issue.CreateSystemAccount {
+		// the generated system account is deleted with the operator
+		accounts = []string{DefaultSysAccountName}
+	}
+	for _, account := range accounts {
+		blocker, err := accountDeletionBlocker(ctx, storage, operator, account)
+		if err != nil {
+			return nil, err
+		}
+		if blocker != "" {
+			blockers = append(blockers, blocker)
+		}
+	}
+	return blockers, nil
+}
+
+// checkAccountDeletionProtection refuses the delete of a protected account
+func checkAccountDeletionProtection(ctx context.Context, storage logical.Storage, operator string, account string) error {
+	blocker, err := accountDeletionBlocker(ctx, storage, operator, account)
+	if err != nil {
+		return err
+	}
+	if blocker != "" {
+		return errConflict("%s", blocker)
+	}
+	return nil
+}
+
+// accountDeletionBlocker returns why the account is protected from deletion,
+// or "" if it is not
+func accountDeletionBlocker(ctx context.Context, storage logical.Storage, operator string, account string) (string, error) {
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return "", err
+	}
+	if issue != nil && issue.DeletionProtection {
+		return fmt.Sprintf("deletion protection is enabled for account %s", account), nil
+	}
+	return "", nil
+}
+
+// normalizeDurationFields replaces the raw values of duration fields, e.g.
+// "24h", by their seconds so the parameters decode into int64
+func normalizeDurationFields(data *framework.FieldData, fields ...string) {
+	for _, field := range fields {
+		if value, ok := data.GetOk(field); ok {
+			data.Raw[field] = value
+		}
+	}
+}
+
+// checkCAS verifies the version of the stored issue for check-and-set writes.
+// A missing issue has version 0.
+func checkCAS(cas *int64, version int64) error {
+	if cas == nil {
+		return nil
+	}
+	if *cas != version {
+		return errConflict("check-and-set parameter did not match the current version %d of the issue", version)
+	}
+	return nil
+}
+
+// patchIssue applies the RFC 7396 JSON merge patch of the request to the
+// parameters of the stored issue and decodes the result into params
+func patchIssue[P any](data *framework.FieldData, current *P, params *P) error {
+	resource := map[string]interface{}{}
+	err := stm.StructToMap(current, &resource)
+	if err != nil {
+		return err
+	}
+
+	patched, err := framework.HandlePatchOperation(data, resource, func(input map[string]interface{}) (map[string]interface{}, error) {
+		delete(input, "cas")
+		return input, nil
+	})
+	if err != nil {
+		return errInvalid("%w", err)
+	}
+
+	err = json.Unmarshal(patched, params)
+	if err != nil {
+		return errInvalid("%w", err)
+	}
+	return nil
+}
+
+// IssueResult is the state computed while writing an issue. Writes return it
+// along with the issue so that clients don't need to read nkey/ and jwt/ afterwards.
+type IssueResult struct {
+	PublicKey     string                         `json:"publicKey"`
+	SigningKeys   map[string]string              `json:"signingKeys,omitempty"`
+	JWT           string                         `json:"jwt,omitempty"`
+	AccountServer map[string]AccountServerStatus `json:"accountServer,omitempty"`
+}
+
+// getIssueResult reads the public keys of the nkey and the signing nkeys and the
+// JWT stored at the given paths. Missing entries are left empty.
+func getIssueResult(ctx context.Context, storage logical.Storage, nkeyPath string, signingKeyPaths map[string]string, jwtPath string) (*IssueResult, error) {
+	result := &IssueResult{}
+
+	publicKey := func(path string) (string, error) {
+		nkey, err := readNkey(ctx, storage, path)
+		if err != nil || nkey == nil {
+			return "", err
+		}
+		d, err := toNkeyData(nkey)
+		if err != nil {
+			return "", err
+		}
+		return d.PublicKey, nil
+	}
+
+	var err error
+	result.PublicKey, err = publicKey(nkeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, path := range signingKeyPaths {
+		pub, err := publicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if pub == "" {
+			continue
+		}
+		if result.SigningKeys == nil {
+			result.SigningKeys = map[string]string{}
+		}
+		result.SigningKeys[name] = pub
+	}
+
+	if jwtPath != "" {
+		jwt, err := readJWT(ctx, storage, jwtPath)
+		if err != nil {
+			return nil, err
+		}
+		if jwt != nil {
+			result.JWT = jwt.JWT
+		}
+	}
+	return result, nil
+}
+
+// addIssueResult adds the computed state to the issue response
+func addIssueResult(resp *logical.Response, result *IssueResult) error {
+	data := map[string]interface{}{}
+	err := stm.StructToMap(result, &data)
+	if err != nil {
+		return err
+	}
+	for k, v := range data {
+		resp.Data[k] = v
+	}
+	return nil
+}
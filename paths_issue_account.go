@@ -1,14 +1,19 @@
 package natsbackend
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/resolver"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/nats-io/nkeys"
@@ -18,39 +23,48 @@ import (
 )
 
 type IssueAccountStorage struct {
-	Operator      string                 `json:"operator"`
-	Account       string                 `json:"account"`
-	UseSigningKey string                 `json:"useSigningKey"`
-	Claims        v1alpha1.AccountClaims `json:"claims"`
-	Status        IssueAccountStatus     `json:"status"`
+	Operator           string                 `json:"operator"`
+	Account            string                 `json:"account"`
+	UseSigningKey      string                 `json:"useSigningKey"`
+	DeletionProtection bool                   `json:"deletionProtection"`
+	Claims             v1alpha1.AccountClaims `json:"claims"`
+	Version            int64                  `json:"version"`
+	Status             IssueAccountStatus     `json:"status"`
+	SigningKeyRotation *SigningKeyRotation    `json:"signingKeyRotation,omitempty"`
 }
 
 // IssueAccountParameters is the user facing interface for configuring an account issue.
 // Using pascal case on purpose.
 // +k8s:deepcopy-gen=true
 type IssueAccountParameters struct {
-	Operator      string                 `json:"operator"`
-	Account       string                 `json:"account"`
-	UseSigningKey string                 `json:"useSigningKey,omitempty"`
-	Claims        v1alpha1.AccountClaims `json:"claims,omitempty"`
+	Operator           string                 `json:"operator"`
+	Account            string                 `json:"account"`
+	UseSigningKey      string                 `json:"useSigningKey,omitempty"`
+	DeletionProtection bool                   `json:"deletionProtection,omitempty"`
+	Claims             v1alpha1.AccountClaims `json:"claims,omitempty"`
+	CAS                *int64                 `json:"cas,omitempty"`
 }
 
 type IssueAccountData struct {
-	Operator      string                 `json:"operator"`
-	Account       string                 `json:"account"`
-	UseSigningKey string                 `json:"useSigningKey"`
-	Claims        v1alpha1.AccountClaims `json:"claims"`
-	Status        IssueAccountStatus     `json:"status"`
+	Operator           string                 `json:"operator"`
+	Account            string                 `json:"account"`
+	UseSigningKey      string                 `json:"useSigningKey"`
+	DeletionProtection bool                   `json:"deletionProtection"`
+	Claims             v1alpha1.AccountClaims `json:"claims"`
+	Version            int64                  `json:"version"`
+	Status             IssueAccountStatus     `json:"status"`
 }
 
 type IssueAccountStatus struct {
-	Account       IssueStatus         `json:"account"`
-	AccountServer AccountServerStatus `json:"accountServer"`
+	Account            IssueStatus         `json:"account"`
+	AccountServer      AccountServerStatus `json:"accountServer"`
+	SigningKeyRotation *SigningKeyRotation `json:"signingKeyRotation,omitempty"`
 }
 
 type AccountServerStatus struct {
-	Synced   bool  `json:"synced"`
-	LastSync int64 `json:"lastSync"`
+	Synced   bool   `json:"synced"`
+	LastSync int64  `json:"lastSync"`
+	Error    string `json:"error,omitempty"`
 }
 
 func pathAccountIssue(b *NatsBackend) []*framework.Path {
@@ -78,6 +92,10 @@ func pathAccountIssue(b *NatsBackend) []*framework.Path {
 					Description: "Account claims (jwt.AccountClaims from github.com/nats-io/jwt/v2)",
 					Required:    false,
 				},
+				"deletionProtection": deletionProtectionField,
+				"cas":                casField,
+				"cascade":            cascadeField,
+				"dryRun":             dryRunField,
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.CreateOperation: &framework.PathOperation{
@@ -86,6 +104,9 @@ func pathAccountIssue(b *NatsBackend) []*framework.Path {
 				logical.UpdateOperation: &framework.PathOperation{
 					Callback: b.pathAddAccountIssue,
 				},
+				logical.PatchOperation: &framework.PathOperation{
+					Callback: b.pathPatchAccountIssue,
+				},
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.pathReadAccountIssue,
 				},
@@ -119,42 +140,116 @@ func pathAccountIssue(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddAccountIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
-	jsonString, err := json.Marshal(data.Raw)
+	params := IssueAccountParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	unlock, err := b.lockAccountIssue(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	defer unlock()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+	}, func() error {
+		return addAccountIssue(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueAccountResult(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathPatchAccountIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	input := IssueAccountParameters{}
+	err = validate.StrictDecode(data.Raw, &input)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	unlock, err := b.lockAccountIssue(ctx, req.Storage, input.Operator, input.Account)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	defer unlock()
+
+	issue, err := readAccountIssue(ctx, req.Storage, input)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue == nil {
+		return errorResponse(IssueNotFoundError, nil)
 	}
+
 	params := IssueAccountParameters{}
-	json.Unmarshal(jsonString, &params)
-	err = addAccountIssue(ctx, req.Storage, params)
+	err = patchIssue(data, &IssueAccountParameters{
+		Operator:           issue.Operator,
+		Account:            issue.Account,
+		UseSigningKey:      issue.UseSigningKey,
+		DeletionProtection: issue.DeletionProtection,
+		Claims:             issue.Claims,
+	}, &params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("%s: %s", AddingIssueFailedError, err.Error())), nil
+		return errorResponse(DecodeFailedError, err)
 	}
-	return nil, nil
+	params.CAS = input.CAS
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+	}, func() error {
+		return addAccountIssue(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueAccountResult(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
 }
 
 func (b *NatsBackend) pathReadAccountIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueAccountParameters{}
 	json.Unmarshal(jsonString, &params)
 
 	issue, err := readAccountIssue(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingIssueFailedError), nil
+		return errorResponse(ReadingIssueFailedError, err)
 	}
 
 	if issue == nil {
-		return logical.ErrorResponse(IssueNotFoundError), nil
+		return errorResponse(IssueNotFoundError, nil)
 	}
 
 	return createResponseIssueAccountData(issue)
@@ -163,19 +258,19 @@ func (b *NatsBackend) pathReadAccountIssue(ctx context.Context, req *logical.Req
 func (b *NatsBackend) pathListAccountIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueAccountParameters{}
 	json.Unmarshal(jsonString, &params)
 
 	entries, err := listAccountIssues(ctx, req.Storage, params.Operator)
 	if err != nil {
-		return logical.ErrorResponse(ListIssuesFailedError), nil
+		return errorResponse(ListIssuesFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -184,20 +279,81 @@ func (b *NatsBackend) pathListAccountIssue(ctx context.Context, req *logical.Req
 func (b *NatsBackend) pathDeleteAccountIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueAccountParameters{}
 	json.Unmarshal(jsonString, &params)
 
-	// delete issue and all related nkeys and jwt
-	err = deleteAccountIssue(ctx, req.Storage, params)
+	defer b.lockOperator(params.Operator)()
+
+	cascade := data.Get("cascade").(bool)
+	dryRun := data.Get("dryRun").(bool)
+
+	if dryRun {
+		blocker, err := accountDeletionBlocker(ctx, req.Storage, params.Operator, params.Account)
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
+		var blockers []string
+		if blocker != "" {
+			blockers = append(blockers, blocker)
+		}
+		var plan cascadePlan
+		if cascade {
+			plan, err = planAccountCascade(ctx, req.Storage, params.Operator, params.Account)
+		} else {
+			plan, err = planPlainAccountDelete(ctx, req.Storage, params.Operator, params.Account)
+		}
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
+		resp := createResponseCascade(plan.keys(), true)
+		if len(blockers) > 0 {
+			resp.Data["blockers"] = blockers
+		}
+		return resp, nil
+	}
+
+	err = checkAccountDeletionProtection(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(DeleteIssueFailedError, err)
+	}
+
+	entry := &issueWALEntry{
+		Operation: walOperationDelete,
+		Operator:  params.Operator,
+		Account:   params.Account,
+		Cascade:   cascade,
+	}
+	issue, err := readAccountIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue != nil {
+		// keep the deleted entries for restore
+		entry.Tombstone, err = createTombstone(ctx, req.Storage, params.Operator, params.Account)
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
+	}
+
+	// delete issue and all related nkeys and jwt, with cascade all issues below as well
+	var deleted []string
+	err = withIssueWAL(ctx, req.Storage, entry, func() error {
+		var err error
+		deleted, err = entry.deleteIssue(ctx, req.Storage)
+		return err
+	})
 	if err != nil {
-		return logical.ErrorResponse(DeleteIssueFailedError), nil
+		return errorResponse(DeleteIssueFailedError, err)
+	}
+	if cascade {
+		return createResponseCascade(deleted, false), nil
 	}
 	return nil, nil
 }
@@ -313,16 +469,48 @@ func deleteAccountIssue(ctx context.Context, storage logical.Storage, params Iss
 	return deleteFromStorage(ctx, storage, path)
 }
 
+// storeAccountIssueUpdate stores the issue changed by the plugin, e.g. its
+// status or the imports and exports of links. Changed claims raise the
+// version, so check-and-set writes based on the previous claims fail.
 func storeAccountIssueUpdate(ctx context.Context, storage logical.Storage, issue *IssueAccountStorage) (*IssueAccountStorage, error) {
 	path := getAccountIssuePath(issue.Operator, issue.Account)
 
-	err := storeInStorage(ctx, storage, path, issue)
+	stored, err := getFromStorage[IssueAccountStorage](ctx, storage, path)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		if issue.Version < stored.Version {
+			issue.Version = stored.Version
+		}
+		changed, err := accountClaimsChanged(stored.Claims, issue.Claims)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			issue.Version++
+		}
+	}
+
+	err = storeInStorage(ctx, storage, path, issue)
 	if err != nil {
 		return nil, err
 	}
 	return issue, nil
 }
 
+func accountClaimsChanged(stored v1alpha1.AccountClaims, claims v1alpha1.AccountClaims) (bool, error) {
+	a, err := json.Marshal(stored)
+	if err != nil {
+		return false, err
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(a, b), nil
+}
+
 func storeAccountIssue(ctx context.Context, storage logical.Storage, params IssueAccountParameters) (*IssueAccountStorage, error) {
 	path := getAccountIssuePath(params.Operator, params.Account)
 
@@ -330,9 +518,23 @@ func storeAccountIssue(ctx context.Context, storage logical.Storage, params Issu
 	if err != nil {
 		return nil, err
 	}
-	if issue == nil {
+	exists := issue != nil
+	if !exists {
 		issue = &IssueAccountStorage{}
-	} else {
+	}
+	err = checkCAS(params.CAS, issue.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.Validate(schema.Account, params.Claims)
+	if err != nil {
+		return nil, errInvalid("%w", err)
+	}
+	err = issue.SigningKeyRotation.checkSigningKeys(params.Claims.SigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
 		// diff current and incomming signing keys
 		// delete removed signing keys
 		for _, signingKey := range issue.Claims.SigningKeys {
@@ -362,6 +564,8 @@ func storeAccountIssue(ctx context.Context, storage logical.Storage, params Issu
 	issue.Operator = params.Operator
 	issue.Account = params.Account
 	issue.UseSigningKey = params.UseSigningKey
+	issue.DeletionProtection = params.DeletionProtection
+	issue.Version++
 	err = storeInStorage(ctx, storage, path, issue)
 	if err != nil {
 		return nil, err
@@ -373,6 +577,7 @@ func issueAccountNKeys(ctx context.Context, storage logical.Storage, issue Issue
 
 	var refreshTheOperator bool
 	var refreshUsers bool
+	var refreshImporters bool
 
 	// issue account nkey
 	p := NkeyParameters{
@@ -392,6 +597,7 @@ func issueAccountNKeys(ctx context.Context, storage logical.Storage, issue Issue
 			refreshTheOperator = true
 		}
 		refreshUsers = true
+		refreshImporters = true
 	}
 
 	// issue account siginig nkeys
@@ -444,6 +650,16 @@ func issueAccountNKeys(ctx context.Context, storage logical.Storage, issue Issue
 		}
 	}
 
+	if refreshImporters {
+		// force update of all accounts importing from this account
+		// by its name so they reference the new account public key
+		err = updateImportingAccountIssues(ctx, storage, issue)
+		if err != nil {
+			log.Err(err).Str("operator", issue.Operator).Msg("failed to update importing accounts")
+			return err
+		}
+	}
+
 	log.Info().
 		Str("operator", issue.Operator).Str("account", issue.Account).Msgf("nkey assigned")
 
@@ -466,7 +682,7 @@ func issueAccountJWT(ctx context.Context, storage logical.Storage, issue IssueAc
 			log.Error().
 				Str("operator", issue.Operator).Str("account", issue.Account).
 				Msgf("operator nkey does not exist: %s - Cannot create JWT.", issue.Operator)
-			return fmt.Errorf("operator nkey does not exist: %s - Cannot create JWT", issue.Operator)
+			return errPending(errNotFound("operator nkey does not exist: %s - Cannot create JWT", issue.Operator))
 		}
 		seed = data.Seed
 	} else {
@@ -481,7 +697,7 @@ func issueAccountJWT(ctx context.Context, storage logical.Storage, issue IssueAc
 			log.Error().
 				Str("operator", issue.Operator).Str("account", issue.Account).
 				Msgf("operator signing nkey does not exist: %s - Cannot create JWT.", useSigningKey)
-			return fmt.Errorf("operator signing nkey does not exist: %s - Cannot create JWT", useSigningKey)
+			return errPending(errNotFound("operator signing nkey does not exist: %s - Cannot create JWT", useSigningKey))
 		}
 		seed = data.Seed
 	}
@@ -504,7 +720,7 @@ func issueAccountJWT(ctx context.Context, storage logical.Storage, issue IssueAc
 		return fmt.Errorf("could not read account nkey: %s", err)
 	}
 	if data == nil {
-		return fmt.Errorf("account nkey does not exist")
+		return errNotFound("account nkey does not exist")
 	}
 	accountKeyPair, err := nkeys.FromSeed(data.Seed)
 	if err != nil {
@@ -544,14 +760,28 @@ func issueAccountJWT(ctx context.Context, storage logical.Storage, issue IssueAc
 		signingPublicKeys = append(signingPublicKeys, signingKey)
 	}
 
+	// resolve imports referencing accounts by name
+	imports, err := resolveAccountRefs(ctx, storage, issue.Operator, issue.Claims.Imports)
+	if err != nil {
+		return err
+	}
+
+	issue.Claims.Imports = imports
 	issue.Claims.ClaimsData.Subject = accountPublicKey
 	issue.Claims.ClaimsData.Issuer = signingPublicKey
+	if issue.Claims.ClaimsData.Name == "" {
+		issue.Claims.ClaimsData.Name = issue.Account
+	}
 	issue.Claims.ClaimsData.IssuedAt = time.Now().Unix()
 	// TODO: dont know how to handle scopes of signing keys
 	issue.Claims.Account.SigningKeys = signingPublicKeys
 	natsJwt, err := v1alpha1.Convert(&issue.Claims)
 	if err != nil {
-		return fmt.Errorf("could not convert claims to nats jwt: %s", err)
+		return errInvalid("could not convert claims to nats jwt: %w", err)
+	}
+	_, err = validate.Claims(natsJwt)
+	if err != nil {
+		return errInvalid("%w", err)
 	}
 	token, err := natsJwt.Encode(signingKeyPair)
 	if err != nil {
@@ -605,6 +835,78 @@ func updateUserIssues(ctx context.Context, storage logical.Storage, issue IssueA
 	return nil
 }
 
+// resolveAccountRefs returns a copy of the imports where every account
+// reference is replaced by the public key of the referenced account.
+func resolveAccountRefs(ctx context.Context, storage logical.Storage, operator string, imports []v1alpha1.Import) ([]v1alpha1.Import, error) {
+	if imports == nil {
+		return nil, nil
+	}
+	resolved := make([]v1alpha1.Import, len(imports))
+	for i, imp := range imports {
+		if imp.AccountRef != "" {
+			if imp.Account != "" {
+				return nil, errInvalid("import %q: account and accountRef are mutually exclusive", imp.Subject)
+			}
+			publicKey, err := resolveAccountPublicKey(ctx, storage, operator, imp.AccountRef)
+			if err != nil {
+				err = fmt.Errorf("import %q: cannot resolve accountRef %q: %w", imp.Subject, imp.AccountRef, err)
+				var se *statusError
+				if errors.As(err, &se) && se.status == http.StatusNotFound {
+					// completed when the referenced account is issued
+					return nil, errPending(err)
+				}
+				return nil, err
+			}
+			imp.Account = publicKey
+			imp.AccountRef = ""
+		}
+		resolved[i] = imp
+	}
+	return resolved, nil
+}
+
+func importsFromAccountRef(issue *IssueAccountStorage, account string) bool {
+	for _, imp := range issue.Claims.Imports {
+		if imp.AccountRef == account {
+			return true
+		}
+	}
+	return false
+}
+
+func updateImportingAccountIssues(ctx context.Context, storage logical.Storage, issue IssueAccountStorage) error {
+	accounts, err := listAccountIssues(ctx, storage, issue.Operator)
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if account == issue.Account {
+			continue
+		}
+		acc, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: issue.Operator,
+			Account:  account,
+		})
+		if err != nil {
+			return err
+		}
+		if acc == nil || !importsFromAccountRef(acc, issue.Account) {
+			continue
+		}
+		log.Info().Str("operator", issue.Operator).Str("account", account).
+			Msgf("account imports from %s, account will be updated", issue.Account)
+		// an importing account may reference other accounts that
+		// do not exist yet, this must not fail the exporting account
+		err = refreshAccount(ctx, storage, acc)
+		if err != nil {
+			log.Warn().Err(err).Str("operator", issue.Operator).Str("account", account).
+				Msg("failed to update importing account")
+		}
+	}
+	return nil
+}
+
 type AccountResolverAction string
 
 const (
@@ -638,6 +940,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Warn().
 			Str("operator", issue.Operator).Str("account", issue.Account).
 			Msgf("account server url is not set - can't sync account server.")
+		accountServerSyncFailed(issue, "account server url is not set")
 		return nil
 	}
 
@@ -652,6 +955,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Warn().Str("operator", issue.Operator).
 			Str("account", issue.Account).
 			Msg("cannot sync account server: account jwt does not exist")
+		accountServerSyncFailed(issue, "account jwt does not exist")
 		return nil
 	}
 
@@ -668,6 +972,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Warn().Str("operator", issue.Operator).
 			Str("account", issue.Account).
 			Msg("cannot sync account server: system account user template does not exist")
+		accountServerSyncFailed(issue, "system account user template does not exist")
 		return nil
 	}
 
@@ -683,6 +988,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Warn().Str("operator", issue.Operator).
 			Str("account", issue.Account).
 			Msg("cannot sync account server: failed to generate system user credentials")
+		accountServerSyncFailed(issue, "failed to generate system user credentials")
 		return nil
 	}
 
@@ -698,6 +1004,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Error().Str("operator", issue.Operator).
 			Str("account", issue.Account).
 			Msg("cannot sync account server: system account user nkey does not exist")
+		accountServerSyncFailed(issue, "system account user nkey does not exist")
 		return nil
 	}
 
@@ -722,6 +1029,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		log.Error().Str("operator", issue.Operator).
 			Str("account", issue.Account).
 			Msg("cannot sync account server: failed to extract JWT from system user creds")
+		accountServerSyncFailed(issue, "failed to extract JWT from system user creds")
 		return nil
 	}
 
@@ -732,6 +1040,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 			Str("account", issue.Account).
 			Err(err).
 			Msg("cannot create conection to account server")
+		accountServerSyncFailed(issue, err.Error())
 		return nil
 	}
 	defer resolver.CloseConnection()
@@ -744,6 +1053,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 				Str("account", issue.Account).
 				Err(err).
 				Msg("cannot sync account server (add)")
+			accountServerSyncFailed(issue, err.Error())
 			return nil
 		}
 	case action == AccountResolverActionDelete:
@@ -755,6 +1065,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 		} else if operatorNkey == nil {
 			log.Warn().Str("operator", issue.Operator).
 				Msg("cannot sync account server: operator nkey does not exist")
+			accountServerSyncFailed(issue, "operator nkey does not exist")
 			return nil
 		}
 		kp, err := toNkeyData(operatorNkey)
@@ -777,6 +1088,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 			log.Warn().Str("operator", issue.Operator).
 				Str("account", issue.Account).
 				Msg("cannot sync account server: account nkey does not exist")
+			accountServerSyncFailed(issue, "account nkey does not exist")
 			return nil
 		}
 		kp, err = toNkeyData(accNkey)
@@ -797,6 +1109,7 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 				Str("account", issue.Account).
 				Err(err).
 				Msg("cannot sync account server (delete)")
+			accountServerSyncFailed(issue, err.Error())
 			return nil
 		}
 	}
@@ -804,21 +1117,65 @@ func refreshAccountResolver(ctx context.Context, storage logical.Storage, issue
 	// update issue status
 	issue.Status.AccountServer.Synced = true
 	issue.Status.AccountServer.LastSync = time.Now().Unix()
+	issue.Status.AccountServer.Error = ""
 	return nil
 }
 
+// accountServerSyncFailed records why the account could not be synced with the account server
+func accountServerSyncFailed(issue *IssueAccountStorage, reason string) {
+	issue.Status.AccountServer.Synced = false
+	issue.Status.AccountServer.Error = reason
+}
+
 func getAccountIssuePath(operator string, account string) string {
 	return "issue/operator/" + operator + "/account/" + account
 }
 
+// createResponseIssueAccountResult returns the stored account issue with
+// the keys and the jwt computed for it
+func createResponseIssueAccountResult(ctx context.Context, storage logical.Storage, operator string, account string) (*logical.Response, error) {
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return nil, errNotFound("account issue does not exist: %s", account)
+	}
+
+	resp, err := createResponseIssueAccountData(issue)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys := map[string]string{}
+	for _, signingKey := range issue.Claims.SigningKeys {
+		signingKeys[signingKey] = getAccountSigningNkeyPath(operator, account, signingKey)
+	}
+	result, err := getIssueResult(ctx, storage, getAccountNkeyPath(operator, account), signingKeys, getAccountJWTPath(operator, account))
+	if err != nil {
+		return nil, err
+	}
+
+	err = addIssueResult(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return addStoredJWTWarnings(ctx, storage, resp, getAccountJWTPath(operator, account)), nil
+}
+
 func createResponseIssueAccountData(issue *IssueAccountStorage) (*logical.Response, error) {
 	data := &IssueAccountData{
-		Operator:      issue.Operator,
-		Account:       issue.Account,
-		UseSigningKey: issue.UseSigningKey,
-		Claims:        issue.Claims,
-		Status:        issue.Status,
+		Operator:           issue.Operator,
+		Account:            issue.Account,
+		UseSigningKey:      issue.UseSigningKey,
+		DeletionProtection: issue.DeletionProtection,
+		Claims:             issue.Claims,
+		Version:            issue.Version,
+		Status:             issue.Status,
 	}
+	data.Status.SigningKeyRotation = issue.SigningKeyRotation
 
 	rval := map[string]interface{}{}
 	err := stm.StructToMap(data, &rval)
@@ -895,13 +1252,18 @@ func addUserToRevocationList(ctx context.Context, storage logical.Storage, accou
 	if err != nil {
 		return err
 	}
+	return addPublicKeyToRevocationList(ctx, storage, account, userPubKey)
+}
+
+// addPublicKeyToRevocationList revokes all user JWTs issued for the public key
+// until now and pushes the re-signed account JWT
+func addPublicKeyToRevocationList(ctx context.Context, storage logical.Storage, account *IssueAccountStorage, userPubKey string) error {
 	// add user to revocation list and store
 	if account.Claims.Revocations == nil {
 		account.Claims.Revocations = map[string]int64{}
 	}
 	account.Claims.Revocations[userPubKey] = time.Now().Unix()
-	path := getAccountIssuePath(account.Operator, account.Account)
-	err = storeInStorage(ctx, storage, path, &account)
+	_, err := storeAccountIssueUpdate(ctx, storage, account)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,359 @@
+package natsbackend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+)
+
+// RotateAccountSigningKeyParameters starts the rotation of an account signing key
+type RotateAccountSigningKeyParameters struct {
+	Operator      string `json:"operator"`
+	Account       string `json:"account"`
+	SigningKey    string `json:"signingKey"`
+	NewSigningKey string `json:"newSigningKey"`
+	GracePeriodS  int64  `json:"gracePeriod,omitempty"`
+}
+
+func pathAccountSigningKeyRotation(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issue/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "/rotate$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"account": {
+					Type:        framework.TypeString,
+					Description: "account identifier",
+					Required:    false,
+				},
+				"signingKey": {
+					Type:        framework.TypeString,
+					Description: "Name of the account signing key to retire",
+					Required:    true,
+				},
+				"newSigningKey": {
+					Type:        framework.TypeString,
+					Description: "Name of the account signing key replacing it",
+					Required:    true,
+				},
+				"gracePeriod": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Minimum time the retired key stays valid after the users were switched, extended to the longest user expirationS, required for users without expirationS (default: 0)",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRotateAccountSigningKey,
+				},
+			},
+			HelpSynopsis:    `Rotates an account signing key.`,
+			HelpDescription: ``,
+		},
+	}
+}
+
+func (b *NatsBackend) pathRotateAccountSigningKey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := RotateAccountSigningKeyParameters{
+		Operator:      data.Get("operator").(string),
+		Account:       data.Get("account").(string),
+		SigningKey:    data.Get("signingKey").(string),
+		NewSigningKey: data.Get("newSigningKey").(string),
+		GracePeriodS:  int64(data.Get("gracePeriod").(int)),
+	}
+
+	defer b.lockAccount(params.Operator, params.Account)()
+
+	err = rotateAccountSigningKey(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueAccountResult(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+// rotateAccountSigningKey adds the new signing key to the account and
+// advances the rotation as far as possible
+func rotateAccountSigningKey(ctx context.Context, storage logical.Storage, params RotateAccountSigningKeyParameters) error {
+	log.Info().
+		Str("operator", params.Operator).Str("account", params.Account).
+		Str("signingKey", params.SigningKey).Str("newSigningKey", params.NewSigningKey).
+		Msgf("rotate account signing key")
+
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: params.Operator,
+		Account:  params.Account,
+	})
+	if err != nil {
+		return err
+	}
+	if issue == nil {
+		return errNotFound("account issue does not exist: %s", params.Account)
+	}
+	if issue.SigningKeyRotation.active() {
+		return errConflict("rotation of signing key %s is in progress", issue.SigningKeyRotation.From)
+	}
+	if !containsString(issue.Claims.SigningKeys, params.SigningKey) {
+		return errInvalid("signing key does not exist: %s", params.SigningKey)
+	}
+	if params.NewSigningKey == "" || containsString(issue.Claims.SigningKeys, params.NewSigningKey) {
+		return errInvalid("new signing key must be a new name: %s", params.NewSigningKey)
+	}
+	if params.GracePeriodS < 0 {
+		return errInvalid("gracePeriod must not be negative")
+	}
+	if params.GracePeriodS == 0 {
+		nonExpiring, err := nonExpiringAccountUsers(ctx, storage, issue, params.SigningKey)
+		if err != nil {
+			return err
+		}
+		if len(nonExpiring) > 0 {
+			return errInvalid("users without expirationS use signing key %s, their credentials stop working once it is retired, a gracePeriod is required: %s",
+				params.SigningKey, strings.Join(nonExpiring, ", "))
+		}
+	}
+
+	// 1. add the new signing key, the account JWT trusts both keys now
+	issue.SigningKeyRotation = &SigningKeyRotation{
+		From:         params.SigningKey,
+		To:           params.NewSigningKey,
+		Phase:        signingKeyRotationPushing,
+		GracePeriodS: params.GracePeriodS,
+		StartedAt:    time.Now().Unix(),
+		Pending:      []string{issue.Account},
+	}
+	issue.Claims.SigningKeys = append(issue.Claims.SigningKeys, params.NewSigningKey)
+	err = refreshAccount(ctx, storage, issue)
+	if err != nil {
+		return err
+	}
+
+	return advanceAccountSigningKeyRotation(ctx, storage, issue)
+}
+
+// advanceAccountSigningKeyRotation moves the rotation of the account through
+// its phases. Users are only switched to the new key once the account server
+// confirmed the account trusting it, so their new credentials are accepted
+// right away. It is called when the rotation starts and periodically until it
+// completed.
+func advanceAccountSigningKeyRotation(ctx context.Context, storage logical.Storage, issue *IssueAccountStorage) error {
+	rotation := issue.SigningKeyRotation
+	if !rotation.active() {
+		return nil
+	}
+	store := func() error {
+		_, err := storeAccountIssueUpdate(ctx, storage, issue)
+		return err
+	}
+
+	// 2. push the account until the account server confirmed it
+	if rotation.Phase == signingKeyRotationPushing {
+		op, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: issue.Operator,
+		})
+		if err != nil {
+			return err
+		}
+		if op != nil && op.SyncAccountServer && !issue.Status.AccountServer.Synced {
+			err = refreshAccountResolverPush(ctx, storage, issue)
+			if err != nil {
+				return err
+			}
+		}
+		if op == nil || !op.SyncAccountServer || issue.Status.AccountServer.Synced {
+			rotation.Pending = nil
+			rotation.Phase = signingKeyRotationResigning
+		}
+		err = store()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 3. switch the users to the new key, credentials signed with the old
+	// key stay valid until the longest user expiration has passed
+	if rotation.Phase == signingKeyRotationResigning {
+		// credentials of users without expiration are only covered by
+		// an explicit grace period
+		if rotation.GracePeriodS == 0 {
+			held, err := holdAccountSigningKeyRotation(ctx, storage, issue)
+			if held || err != nil {
+				return err
+			}
+		}
+		resigned, longestExpirationS, err := resignAccountUsers(ctx, storage, issue, rotation.From, rotation.To)
+		if err != nil {
+			return err
+		}
+		if longestExpirationS > rotation.GracePeriodS {
+			rotation.GracePeriodS = longestExpirationS
+		}
+		rotation.Resigned = resigned
+		rotation.Phase = signingKeyRotationGrace
+		rotation.RetireAt = time.Now().Unix() + rotation.GracePeriodS
+		err = store()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 4. retire the old key after the grace period
+	if rotation.Phase == signingKeyRotationGrace && time.Now().Unix() >= rotation.RetireAt {
+		log.Info().
+			Str("operator", issue.Operator).Str("account", issue.Account).Str("signingKey", rotation.From).
+			Msgf("retire account signing key")
+
+		// users changed to the old key meanwhile would break, the ones
+		// without expiration hold credentials no grace period covered
+		held, err := holdAccountSigningKeyRotation(ctx, storage, issue)
+		if held || err != nil {
+			return err
+		}
+		_, _, err = resignAccountUsers(ctx, storage, issue, rotation.From, rotation.To)
+		if err != nil {
+			return err
+		}
+
+		signingKeys := []string{}
+		for _, signingKey := range issue.Claims.SigningKeys {
+			if signingKey != rotation.From {
+				signingKeys = append(signingKeys, signingKey)
+			}
+		}
+		issue.Claims.SigningKeys = signingKeys
+		rotation.Phase = signingKeyRotationCompleted
+		rotation.CompletedAt = time.Now().Unix()
+		err = store()
+		if err != nil {
+			return err
+		}
+		err = deleteAccountSigningNkey(ctx, storage, NkeyParameters{
+			Operator: issue.Operator,
+			Account:  issue.Account,
+			Signing:  rotation.From,
+		})
+		if err != nil {
+			return err
+		}
+		return refreshAccount(ctx, storage, issue)
+	}
+	return nil
+}
+
+func periodicAdvanceAccountSigningKeyRotation(ctx context.Context, storage logical.Storage, operator string, account string) error {
+	issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil || issue == nil {
+		return err
+	}
+	return advanceAccountSigningKeyRotation(ctx, storage, issue)
+}
+
+// holdAccountSigningKeyRotation keeps the rotation in its phase while users
+// without expiration use the old key. They are listed in the rotation until
+// they got an expirationS or were switched to another signing key.
+func holdAccountSigningKeyRotation(ctx context.Context, storage logical.Storage, issue *IssueAccountStorage) (bool, error) {
+	rotation := issue.SigningKeyRotation
+	nonExpiring, err := nonExpiringAccountUsers(ctx, storage, issue, rotation.From)
+	if err != nil {
+		return false, err
+	}
+	if len(nonExpiring) == 0 && rotation.Blocking == nil {
+		return false, nil
+	}
+	if len(nonExpiring) > 0 {
+		log.Warn().
+			Str("operator", issue.Operator).Str("account", issue.Account).Str("signingKey", rotation.From).
+			Msgf("users without expirationS hold the rotation: %s", strings.Join(nonExpiring, ", "))
+		rotation.Blocking = nonExpiring
+	} else {
+		rotation.Blocking = nil
+	}
+	_, err = storeAccountIssueUpdate(ctx, storage, issue)
+	return len(nonExpiring) > 0, err
+}
+
+// nonExpiringAccountUsers returns the users of the account using the signing
+// key without expirationS
+func nonExpiringAccountUsers(ctx context.Context, storage logical.Storage, account *IssueAccountStorage, signingKey string) ([]string, error) {
+	users, err := listUserIssues(ctx, storage, IssueUserParameters{
+		Operator: account.Operator,
+		Account:  account.Account,
+	})
+	if err != nil {
+		return nil, err
+	}
+	nonExpiring := []string{}
+	for _, user := range users {
+		issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+			Operator: account.Operator,
+			Account:  account.Account,
+			User:     user,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil && issue.UseSigningKey == signingKey && issue.ExpirationS == 0 {
+			nonExpiring = append(nonExpiring, user)
+		}
+	}
+	return nonExpiring, nil
+}
+
+// resignAccountUsers switches the users of the account using the signing key
+// from to the signing key to. It returns their names and the longest
+// expiration of credentials they were issued with.
+func resignAccountUsers(ctx context.Context, storage logical.Storage, account *IssueAccountStorage, from string, to string) ([]string, int64, error) {
+	users, err := listUserIssues(ctx, storage, IssueUserParameters{
+		Operator: account.Operator,
+		Account:  account.Account,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	resigned := []string{}
+	var longestExpirationS int64
+	for _, user := range users {
+		issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+			Operator: account.Operator,
+			Account:  account.Account,
+			User:     user,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if issue == nil || issue.UseSigningKey != from {
+			continue
+		}
+		issue.UseSigningKey = to
+		issue.Version++
+		err = refreshUser(ctx, storage, issue)
+		if err != nil {
+			return nil, 0, err
+		}
+		resigned = append(resigned, user)
+		if issue.ExpirationS > longestExpirationS {
+			longestExpirationS = issue.ExpirationS
+		}
+	}
+	return resigned, longestExpirationS, nil
+}
@@ -0,0 +1,263 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func accountSigningKeyPublicKey(t *testing.T, storage logical.Storage, signingKey string) string {
+	t.Helper()
+	nkey, err := readAccountSigningNkey(context.Background(), storage, NkeyParameters{
+		Operator: "op1",
+		Account:  "ac1",
+		Signing:  signingKey,
+	})
+	require.NoError(t, err)
+	if nkey == nil {
+		return ""
+	}
+	data, err := toNkeyData(nkey)
+	require.NoError(t, err)
+	return data.PublicKey
+}
+
+func userCredsIssuer(t *testing.T, b *NatsBackend, storage logical.Storage, user string) string {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "creds/operator/op1/account/ac1/user/" + user,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	userJWT, err := jwt.ParseDecoratedJWT([]byte(resp.Data["creds"].(string)))
+	require.NoError(t, err)
+	claims, err := jwt.DecodeUserClaims(userJWT)
+	require.NoError(t, err)
+	return claims.Issuer
+}
+
+func TestAccountSigningKeyRotation(t *testing.T) {
+	setup := func(t *testing.T, users map[string]map[string]interface{}) (*NatsBackend, logical.Storage) {
+		b, reqStorage := getTestBackend(t)
+		for _, setup := range []struct {
+			path string
+			data map[string]interface{}
+		}{
+			{"issue/operator/op1", map[string]interface{}{}},
+			{"issue/operator/op1/account/ac1", map[string]interface{}{
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"signingKeys": []interface{}{"acsk1"},
+					},
+				},
+			}},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      setup.path,
+				Storage:   reqStorage,
+				Data:      setup.data,
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+		for user, data := range users {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "issue/operator/op1/account/ac1/user/" + user,
+				Storage:   reqStorage,
+				Data:      data,
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+		return b, reqStorage
+	}
+	rotate := func(b *NatsBackend, storage logical.Storage, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/ac1/rotate",
+			Storage:   storage,
+			Data:      data,
+		})
+	}
+
+	t.Run("old key is kept until the longest user expiration passed", func(t *testing.T) {
+		b, reqStorage := setup(t, map[string]map[string]interface{}{
+			"us1": {"useSigningKey": "acsk1", "expirationS": 3600},
+			"us2": {"useSigningKey": "acsk1", "expirationS": 60},
+			"us3": {},
+		})
+		oldPublicKey := accountSigningKeyPublicKey(t, reqStorage, "acsk1")
+		assert.Equal(t, oldPublicKey, userCredsIssuer(t, b, reqStorage, "us1"))
+
+		resp, err := rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "acsk1",
+			"newSigningKey": "acsk2",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		rotation := resp.Data["status"].(map[string]interface{})["signingKeyRotation"].(map[string]interface{})
+		assert.Equal(t, "grace", rotation["phase"])
+		assert.Equal(t, float64(3600), rotation["gracePeriod"])
+		assert.Equal(t, []interface{}{"us1", "us2"}, rotation["resigned"])
+
+		// new credentials are signed with the new key, the account trusts both
+		newPublicKey := accountSigningKeyPublicKey(t, reqStorage, "acsk2")
+		assert.Equal(t, newPublicKey, userCredsIssuer(t, b, reqStorage, "us1"))
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.True(t, claims.SigningKeys.Contains(oldPublicKey))
+		assert.True(t, claims.SigningKeys.Contains(newPublicKey))
+
+		// the keys of the rotation can't be dropped and no second rotation starts
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+		resp, err = rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "acsk2",
+			"newSigningKey": "acsk3",
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+
+		// the periodic function retires the key once the grace period is over
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		issue.SigningKeyRotation.RetireAt = time.Now().Add(-time.Minute).Unix()
+		_, err = storeAccountIssueUpdate(context.Background(), reqStorage, issue)
+		require.NoError(t, err)
+		err = b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage})
+		require.NoError(t, err)
+
+		issue, err = readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationCompleted, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"acsk2"}, issue.Claims.SigningKeys)
+		assert.Empty(t, accountSigningKeyPublicKey(t, reqStorage, "acsk1"))
+		accJWT, err = readAccountJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		claims, err = jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.False(t, claims.SigningKeys.Contains(oldPublicKey))
+		assert.True(t, claims.SigningKeys.Contains(newPublicKey))
+	})
+
+	t.Run("rotation without users of the key completes right away", func(t *testing.T) {
+		b, reqStorage := setup(t, map[string]map[string]interface{}{
+			"us1": {},
+		})
+
+		resp, err := rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "acsk1",
+			"newSigningKey": "acsk2",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationCompleted, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"acsk2"}, issue.Claims.SigningKeys)
+	})
+
+	t.Run("users without expiration hold the old key", func(t *testing.T) {
+		b, reqStorage := setup(t, map[string]map[string]interface{}{
+			"us1": {"useSigningKey": "acsk1"},
+			"us2": {},
+		})
+
+		// their credentials never expire, so only a grace period covers them
+		resp, err := rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "acsk1",
+			"newSigningKey": "acsk2",
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+
+		resp, err = rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "acsk1",
+			"newSigningKey": "acsk2",
+			"gracePeriod":   "1m",
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		user, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{Operator: "op1", Account: "ac1", User: "us1"})
+		require.NoError(t, err)
+		assert.Equal(t, "acsk2", user.UseSigningKey)
+		assert.Equal(t, accountSigningKeyPublicKey(t, reqStorage, "acsk2"), userCredsIssuer(t, b, reqStorage, "us1"))
+
+		// a user changed to the old key during the grace period holds it
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/ac1/user/us2",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"useSigningKey": "acsk1"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		expireGracePeriod := func() {
+			issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+			require.NoError(t, err)
+			issue.SigningKeyRotation.RetireAt = time.Now().Add(-time.Minute).Unix()
+			_, err = storeAccountIssueUpdate(context.Background(), reqStorage, issue)
+			require.NoError(t, err)
+			require.NoError(t, b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage}))
+		}
+		expireGracePeriod()
+
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationGrace, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"us2"}, issue.SigningKeyRotation.Blocking)
+		assert.Equal(t, []string{"acsk1", "acsk2"}, issue.Claims.SigningKeys)
+		assert.NotEmpty(t, accountSigningKeyPublicKey(t, reqStorage, "acsk1"))
+
+		// with an expiration the rotation completes
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/ac1/user/us2",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"useSigningKey": "acsk1", "expirationS": 60},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		expireGracePeriod()
+
+		issue, err = readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationCompleted, issue.SigningKeyRotation.Phase)
+		assert.Empty(t, issue.SigningKeyRotation.Blocking)
+		assert.Equal(t, []string{"acsk2"}, issue.Claims.SigningKeys)
+		user, err = readUserIssue(context.Background(), reqStorage, IssueUserParameters{Operator: "op1", Account: "ac1", User: "us2"})
+		require.NoError(t, err)
+		assert.Equal(t, "acsk2", user.UseSigningKey)
+	})
+
+	t.Run("unknown signing key is rejected", func(t *testing.T) {
+		b, reqStorage := setup(t, nil)
+
+		resp, err := rotate(b, reqStorage, map[string]interface{}{
+			"signingKey":    "unknown",
+			"newSigningKey": "acsk2",
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	accountv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,8 +40,8 @@ func TestCRUDAccountIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -92,6 +95,7 @@ func TestCRUDAccountIssue(t *testing.T) {
 			Account:       "ac1",
 			UseSigningKey: "",
 			Claims:        accountv1.AccountClaims{},
+			Version:       1,
 			Status: IssueAccountStatus{
 				Account: IssueStatus{
 					Nkey: true,
@@ -163,6 +167,7 @@ func TestCRUDAccountIssue(t *testing.T) {
 					},
 				},
 			},
+			Version: 2,
 			Status: IssueAccountStatus{
 				Account: IssueStatus{
 					Nkey: true,
@@ -235,8 +240,8 @@ func TestCRUDAccountIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		//////////////////////////
 		// Then recreate the key
@@ -360,8 +365,8 @@ func TestAccountBeforeOperatorCreatingSysAccount(t *testing.T) {
 			Storage:   reqStorage,
 			Data:      map[string]interface{}{},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 		// 1.1b create the account
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.CreateOperation,
@@ -369,8 +374,8 @@ func TestAccountBeforeOperatorCreatingSysAccount(t *testing.T) {
 			Storage:   reqStorage,
 			Data:      map[string]interface{}{},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// 1.2 list the accounts - ac1 should be present
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -554,8 +559,8 @@ func TestAccountBeforeOperatorAndSysAccount(t *testing.T) {
 			Storage:   reqStorage,
 			Data:      map[string]interface{}{},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// 1.2 list the accounts - ac1 should be present
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -767,3 +772,339 @@ func Test_UnmarshalIssueAccountParameters(t *testing.T) {
 	assert.Nil(err)
 	fmt.Printf("%+v\n", claims)
 }
+
+func TestAccountImportByAccountRef(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	importerClaims := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"account": map[string]interface{}{
+				"limits": map[string]interface{}{
+					"imports": -1,
+				},
+				"imports": []interface{}{
+					map[string]interface{}{
+						"name":       "svc",
+						"subject":    "svc.>",
+						"accountRef": "exporter",
+						"type":       "Service",
+					},
+				},
+			},
+		},
+	}
+	readImportAccount := func(t *testing.T) string {
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+			Operator: "op1",
+			Account:  "importer",
+		})
+		assert.NoError(t, err)
+		if !assert.NotNil(t, accJWT) {
+			return ""
+		}
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		assert.NoError(t, err)
+		assert.Len(t, claims.Imports, 1)
+		return claims.Imports[0].Account
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+
+	t.Run("Importer before exporter", func(t *testing.T) {
+		// the referenced account does not exist yet
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/importer",
+			Storage:   reqStorage,
+			Data:      importerClaims,
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+
+		// creating the exporter re-signs the importer
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		exporterPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "exporter")
+		assert.NoError(t, err)
+		assert.Equal(t, exporterPub, readImportAccount(t))
+
+		// the reference is kept in the issue
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+			Operator: "op1",
+			Account:  "importer",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "exporter", issue.Claims.Imports[0].AccountRef)
+		assert.Equal(t, "", issue.Claims.Imports[0].Account)
+	})
+
+	t.Run("Exporter key changes", func(t *testing.T) {
+		seed := genAccountSeed()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "nkey/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"seed": seed,
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		kp, err := nkeys.FromSeed([]byte(seed))
+		assert.NoError(t, err)
+		exporterPub, err := kp.PublicKey()
+		assert.NoError(t, err)
+		assert.Equal(t, exporterPub, readImportAccount(t))
+	})
+
+	t.Run("Failed re-sign on key change is returned", func(t *testing.T) {
+		for _, storage := range []logical.Storage{
+			&failingStorage{Storage: reqStorage, prefix: getAccountJWTPath("op1", "exporter")},
+			reqStorage,
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      "nkey/operator/op1/account/exporter",
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"seed": genAccountSeed(),
+				},
+			})
+			if storage == reqStorage {
+				assert.NoError(t, err)
+				assert.False(t, resp.IsError())
+			} else {
+				assert.Error(t, err)
+				assert.True(t, isErrorResponse(resp))
+			}
+		}
+	})
+
+	t.Run("Account and accountRef are mutually exclusive", func(t *testing.T) {
+		exporterPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "exporter")
+		assert.NoError(t, err)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/importer",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"limits": map[string]interface{}{
+							"imports": -1,
+						},
+						"imports": []interface{}{
+							map[string]interface{}{
+								"subject":    "svc.>",
+								"account":    exporterPub,
+								"accountRef": "exporter",
+								"type":       "Service",
+							},
+						},
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
+
+func TestAccountClaimsValidation(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+
+	t.Run("Blocking issues are rejected", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"limits": map[string]interface{}{
+							"exports": -1,
+						},
+						"exports": []interface{}{
+							map[string]interface{}{
+								"subject": "foo bar",
+								"type":    "Stream",
+							},
+						},
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+		assert.Contains(t, errorMessage(resp), "invalid claims")
+
+		// nothing is signed
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+			Operator: "op1",
+			Account:  "ac1",
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, accJWT)
+	})
+
+	t.Run("Non-blocking issues are returned as warnings", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/ac2",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"claims": map[string]interface{}{
+					"nbf": time.Now().Add(time.Hour).Unix(),
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		assert.Equal(t, []string{"claim is not yet valid"}, resp.Warnings)
+	})
+}
+
+func TestAccountIssuePatch(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	path := "issue/operator/op1/account/ac1"
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+
+	readIssue := func(t *testing.T) IssueAccountData {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      path,
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		var current IssueAccountData
+		stm.MapToStruct(resp.Data, &current)
+		return current
+	}
+
+	t.Run("Patch missing issue", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+		assertErrorStatus(t, err, http.StatusNotFound)
+	})
+
+	t.Run("Create only with cas 0", func(t *testing.T) {
+		for _, status := range []int{0, http.StatusConflict} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      path,
+				Storage:   reqStorage,
+				Data: map[string]interface{}{
+					"cas": 0,
+					"claims": map[string]interface{}{
+						"account": map[string]interface{}{
+							"limits": map[string]interface{}{
+								"subs": 10,
+								"conn": 5,
+							},
+						},
+					},
+				},
+			})
+			if status == 0 {
+				assert.NoError(t, err)
+				assert.False(t, resp.IsError())
+			} else {
+				assert.Error(t, err)
+				assert.True(t, isErrorResponse(resp))
+				assertErrorStatus(t, err, status)
+			}
+		}
+		assert.Equal(t, int64(1), readIssue(t).Version)
+	})
+
+	t.Run("Merge patch", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"cas": 1,
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"limits": map[string]interface{}{
+							"conn":    nil,
+							"payload": 1024,
+						},
+					},
+				},
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		current := readIssue(t)
+		assert.Equal(t, int64(2), current.Version)
+		assert.Equal(t, int64(10), current.Claims.Account.Limits.Subs)
+		assert.Equal(t, int64(1024), current.Claims.Account.Limits.Payload)
+		assert.Equal(t, int64(0), current.Claims.Account.Limits.Conn)
+	})
+
+	t.Run("Reject stale cas", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"cas": 1,
+				"claims": map[string]interface{}{
+					"account": map[string]interface{}{
+						"limits": map[string]interface{}{
+							"subs": 20,
+						},
+					},
+				},
+			},
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+		assertErrorStatus(t, err, http.StatusConflict)
+
+		current := readIssue(t)
+		assert.Equal(t, int64(2), current.Version)
+		assert.Equal(t, int64(10), current.Claims.Account.Limits.Subs)
+	})
+}
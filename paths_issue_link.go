@@ -0,0 +1,711 @@
+package natsbackend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+// linkEntryPrefix prefixes the names of exports and imports managed by links
+const linkEntryPrefix = "link:"
+
+type IssueLinkStorage struct {
+	Operator     string `json:"operator"`
+	Link         string `json:"link"`
+	Exporter     string `json:"exporter"`
+	Importer     string `json:"importer"`
+	Subject      string `json:"subject"`
+	LocalSubject string `json:"localSubject"`
+	Type         string `json:"type"`
+	TokenReq     bool   `json:"tokenReq"`
+	Version      int64  `json:"version"`
+}
+
+// IssueLinkParameters is the user facing interface for configuring a link
+// between an exporting and an importing account of the same operator.
+type IssueLinkParameters struct {
+	Operator     string `json:"operator"`
+	Link         string `json:"link"`
+	Exporter     string `json:"exporter,omitempty"`
+	Importer     string `json:"importer,omitempty"`
+	Subject      string `json:"subject,omitempty"`
+	LocalSubject string `json:"localSubject,omitempty"`
+	Type         string `json:"type,omitempty"`
+	TokenReq     bool   `json:"tokenReq,omitempty"`
+	CAS          *int64 `json:"cas,omitempty"`
+}
+
+type IssueLinkData struct {
+	Operator     string          `json:"operator"`
+	Link         string          `json:"link"`
+	Exporter     string          `json:"exporter"`
+	Importer     string          `json:"importer"`
+	Subject      string          `json:"subject"`
+	LocalSubject string          `json:"localSubject"`
+	Type         string          `json:"type"`
+	TokenReq     bool            `json:"tokenReq"`
+	Version      int64           `json:"version"`
+	Status       IssueLinkStatus `json:"status"`
+}
+
+type IssueLinkStatus struct {
+	Export     bool `json:"export"`
+	Import     bool `json:"import"`
+	Activation bool `json:"activation"`
+}
+
+func pathLinkIssue(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issue/operator/" + framework.GenericNameRegex("operator") + "/link/" + framework.GenericNameRegex("link") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"link": {
+					Type:        framework.TypeString,
+					Description: "link identifier",
+					Required:    false,
+				},
+				"exporter": {
+					Type:        framework.TypeString,
+					Description: "Name of the exporting account",
+					Required:    false,
+				},
+				"importer": {
+					Type:        framework.TypeString,
+					Description: "Name of the importing account",
+					Required:    false,
+				},
+				"subject": {
+					Type:        framework.TypeString,
+					Description: "Subject exported by the exporting account",
+					Required:    false,
+				},
+				"localSubject": {
+					Type:        framework.TypeString,
+					Description: "Subject the import is mapped to in the importing account",
+					Required:    false,
+				},
+				"type": {
+					Type:        framework.TypeString,
+					Description: "Type of the link: Stream or Service",
+					Required:    false,
+				},
+				"tokenReq": {
+					Type:        framework.TypeBool,
+					Description: "Export privately and issue an activation token for the importing account",
+					Required:    false,
+				},
+				"cas": casField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.CreateOperation: &framework.PathOperation{
+					Callback: b.pathAddLinkIssue,
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAddLinkIssue,
+				},
+				logical.PatchOperation: &framework.PathOperation{
+					Callback: b.pathPatchLinkIssue,
+				},
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathReadLinkIssue,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathDeleteLinkIssue,
+				},
+			},
+			HelpSynopsis:    `Manages links between accounts.`,
+			HelpDescription: `A link adds an export to the exporting account and a matching import to the importing account, issues an activation token for private exports and pushes both accounts.`,
+		},
+		{
+			Pattern: "issue/operator/" + framework.GenericNameRegex("operator") + "/link/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathListLinkIssues,
+				},
+			},
+			HelpSynopsis:    "List links between accounts.",
+			HelpDescription: "",
+		},
+	}
+}
+
+func (b *NatsBackend) pathAddLinkIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := IssueLinkParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	err = writeLinkIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueLinkResult(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathPatchLinkIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	input := IssueLinkParameters{}
+	err = validate.StrictDecode(data.Raw, &input)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(input.Operator)()
+
+	issue, err := readLinkIssue(ctx, req.Storage, input)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue == nil {
+		return errorResponse(IssueNotFoundError, nil)
+	}
+
+	params := IssueLinkParameters{}
+	err = patchIssue(data, &IssueLinkParameters{
+		Operator:     issue.Operator,
+		Link:         issue.Link,
+		Exporter:     issue.Exporter,
+		Importer:     issue.Importer,
+		Subject:      issue.Subject,
+		LocalSubject: issue.LocalSubject,
+		Type:         issue.Type,
+		TokenReq:     issue.TokenReq,
+	}, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	params.CAS = input.CAS
+
+	err = writeLinkIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueLinkResult(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathReadLinkIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := IssueLinkParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	issue, err := readLinkIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+
+	if issue == nil {
+		return errorResponse(IssueNotFoundError, nil)
+	}
+
+	status := getIssueLinkStatus(ctx, req.Storage, issue)
+	return createResponseIssueLinkData(issue, status)
+}
+
+func (b *NatsBackend) pathListLinkIssues(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := IssueLinkParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	entries, err := listLinkIssues(ctx, req.Storage, params.Operator)
+	if err != nil {
+		return errorResponse(ListIssuesFailedError, err)
+	}
+
+	return logical.ListResponse(entries), nil
+}
+
+func (b *NatsBackend) pathDeleteLinkIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := IssueLinkParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	// remove export, import and the link itself
+	err = removeLinkIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(DeleteIssueFailedError, err)
+	}
+	return nil, nil
+}
+
+// writeLinkIssue adds or updates the link guarded by the WAL, so a failure
+// does not leave one of the accounts changed. The caller must hold the lock
+// of the operator.
+func writeLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) error {
+	return withIssueWAL(ctx, storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Link:      params.Link,
+		Accounts:  []string{params.Exporter, params.Importer},
+	}, func() error {
+		return addLinkIssue(ctx, storage, params)
+	})
+}
+
+// removeLinkIssue deletes the link guarded by the WAL. The caller must hold
+// the lock of the operator.
+func removeLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) error {
+	return withIssueWAL(ctx, storage, &issueWALEntry{
+		Operation: walOperationDelete,
+		Operator:  params.Operator,
+		Link:      params.Link,
+	}, func() error {
+		return deleteLinkIssue(ctx, storage, params)
+	})
+}
+
+func addLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) error {
+	log.Info().
+		Str("operator", params.Operator).Str("link", params.Link).
+		Str("exporter", params.Exporter).Str("importer", params.Importer).
+		Msgf("issue link")
+
+	params.Type = normalizeLinkType(params.Type)
+	err := validateLinkParameters(params)
+	if err != nil {
+		return err
+	}
+
+	// the accounts must exist before they can be linked
+	for _, account := range []string{params.Exporter, params.Importer} {
+		acc, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: params.Operator,
+			Account:  account,
+		})
+		if err != nil {
+			return err
+		}
+		if acc == nil {
+			return errNotFound("account issue does not exist: %s", account)
+		}
+	}
+
+	// undo a previous version of the link
+	current, err := readLinkIssue(ctx, storage, params)
+	if err != nil {
+		return err
+	}
+	version := int64(0)
+	if current != nil {
+		version = current.Version
+	}
+	err = checkCAS(params.CAS, version)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		err = unlinkAccounts(ctx, storage, current)
+		if err != nil {
+			return err
+		}
+	}
+
+	issue, err := storeLinkIssue(ctx, storage, params)
+	if err != nil {
+		return err
+	}
+
+	return linkAccounts(ctx, storage, issue)
+}
+
+func validateLinkParameters(params IssueLinkParameters) error {
+	if params.Exporter == "" {
+		return errInvalid("exporter is required")
+	}
+	if params.Importer == "" {
+		return errInvalid("importer is required")
+	}
+	if params.Exporter == params.Importer {
+		return errInvalid("exporter and importer must be different accounts")
+	}
+	if params.Subject == "" {
+		return errInvalid("subject is required")
+	}
+	if params.Type != "Stream" && params.Type != "Service" {
+		return errInvalid("invalid link type %q: must be Stream or Service", params.Type)
+	}
+	return nil
+}
+
+// normalizeLinkType accepts the link type in any case, e.g. stream or Stream
+func normalizeLinkType(t string) string {
+	for _, valid := range []string{"Stream", "Service"} {
+		if strings.EqualFold(t, valid) {
+			return valid
+		}
+	}
+	return t
+}
+
+// linkAccounts adds the export and import of the link to the accounts
+// and re-signs and pushes both of them
+func linkAccounts(ctx context.Context, storage logical.Storage, issue *IssueLinkStorage) error {
+	exporter, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Exporter,
+	})
+	if err != nil {
+		return err
+	}
+	if exporter == nil {
+		return errNotFound("account issue does not exist: %s", issue.Exporter)
+	}
+
+	// an existing export of the subject is reused
+	if export := findExport(exporter, issue.Subject); export != nil {
+		if export.Type != issue.Type {
+			return errConflict("export %q of account %s is of type %s", issue.Subject, issue.Exporter, export.Type)
+		}
+		if export.TokenReq != issue.TokenReq {
+			return errConflict("export %q of account %s has tokenReq %t", issue.Subject, issue.Exporter, export.TokenReq)
+		}
+		// a revocation left by unlinking the importer before would also
+		// revoke the activation issued below within the same second
+		if export.TokenReq && len(export.Revocations) > 0 {
+			importerPub, err := resolveAccountPublicKey(ctx, storage, issue.Operator, issue.Importer)
+			if err != nil {
+				return err
+			}
+			delete(export.Revocations, importerPub)
+		}
+	} else {
+		exporter.Claims.Exports = append(exporter.Claims.Exports, v1alpha1.Export{
+			Name:     linkEntryPrefix + issue.Link,
+			Subject:  issue.Subject,
+			Type:     issue.Type,
+			TokenReq: issue.TokenReq,
+		})
+	}
+	err = refreshAccount(ctx, storage, exporter)
+	if err != nil {
+		return err
+	}
+
+	imp := v1alpha1.Import{
+		Name:         linkEntryPrefix + issue.Link,
+		Subject:      issue.Subject,
+		AccountRef:   issue.Exporter,
+		LocalSubject: issue.LocalSubject,
+		Type:         issue.Type,
+	}
+	if export := findExport(exporter, issue.Subject); export != nil && export.TokenReq {
+		activation, err := issueActivation(ctx, storage, ActivationParameters{
+			Operator: issue.Operator,
+			Account:  issue.Exporter,
+			Target:   issue.Importer,
+			Subject:  issue.Subject,
+		})
+		if err != nil {
+			return err
+		}
+		imp.Token = activation.JWT
+	}
+
+	importer, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Importer,
+	})
+	if err != nil {
+		return err
+	}
+	if importer == nil {
+		return errNotFound("account issue does not exist: %s", issue.Importer)
+	}
+	importer.Claims.Imports = append(removeLinkImport(importer.Claims.Imports, issue.Link), imp)
+	return refreshAccount(ctx, storage, importer)
+}
+
+// unlinkAccounts removes the export and import of the link from the accounts
+// and re-signs and pushes both of them
+func unlinkAccounts(ctx context.Context, storage logical.Storage, issue *IssueLinkStorage) error {
+	importer, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Importer,
+	})
+	if err != nil {
+		return err
+	}
+	if importer != nil {
+		importer.Claims.Imports = removeLinkImport(importer.Claims.Imports, issue.Link)
+		err = refreshAccount(ctx, storage, importer)
+		if err != nil {
+			return err
+		}
+	}
+
+	exporter, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Exporter,
+	})
+	if err != nil {
+		return err
+	}
+	if exporter == nil {
+		return nil
+	}
+
+	inUse, err := linkExportInUse(ctx, storage, issue)
+	if err != nil {
+		return err
+	}
+	var exports []v1alpha1.Export
+	for _, export := range exporter.Claims.Exports {
+		if export.Subject != issue.Subject {
+			exports = append(exports, export)
+			continue
+		}
+		if inUse || export.Name != linkEntryPrefix+issue.Link {
+			// the export is kept, so revoke the activation of the importer
+			if export.TokenReq {
+				publicKey, err := resolveAccountPublicKey(ctx, storage, issue.Operator, issue.Importer)
+				if err == nil {
+					if export.Revocations == nil {
+						export.Revocations = map[string]int64{}
+					}
+					export.Revocations[publicKey] = time.Now().Unix()
+				}
+			}
+			exports = append(exports, export)
+		}
+	}
+	exporter.Claims.Exports = exports
+	return refreshAccount(ctx, storage, exporter)
+}
+
+// linkExportInUse checks if another link uses the same export
+func linkExportInUse(ctx context.Context, storage logical.Storage, issue *IssueLinkStorage) (bool, error) {
+	links, err := listLinkIssues(ctx, storage, issue.Operator)
+	if err != nil {
+		return false, err
+	}
+	for _, link := range links {
+		if link == issue.Link {
+			continue
+		}
+		other, err := readLinkIssue(ctx, storage, IssueLinkParameters{
+			Operator: issue.Operator,
+			Link:     link,
+		})
+		if err != nil {
+			return false, err
+		}
+		if other != nil && other.Exporter == issue.Exporter && other.Subject == issue.Subject {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func findExport(issue *IssueAccountStorage, subject string) *v1alpha1.Export {
+	for i := range issue.Claims.Exports {
+		if issue.Claims.Exports[i].Subject == subject {
+			return &issue.Claims.Exports[i]
+		}
+	}
+	return nil
+}
+
+func removeLinkImport(imports []v1alpha1.Import, link string) []v1alpha1.Import {
+	var rval []v1alpha1.Import
+	for _, imp := range imports {
+		if imp.Name != linkEntryPrefix+link {
+			rval = append(rval, imp)
+		}
+	}
+	return rval
+}
+
+func readLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) (*IssueLinkStorage, error) {
+	path := getLinkIssuePath(params.Operator, params.Link)
+	return getFromStorage[IssueLinkStorage](ctx, storage, path)
+}
+
+func listLinkIssues(ctx context.Context, storage logical.Storage, operator string) ([]string, error) {
+	path := getLinkIssuePath(operator, "")
+	return listIssues(ctx, storage, path)
+}
+
+func deleteLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) error {
+	issue, err := readLinkIssue(ctx, storage, params)
+	if err != nil {
+		return err
+	}
+	if issue == nil {
+		// nothing to delete
+		return nil
+	}
+
+	err = unlinkAccounts(ctx, storage, issue)
+	if err != nil {
+		return err
+	}
+
+	path := getLinkIssuePath(issue.Operator, issue.Link)
+	return deleteFromStorage(ctx, storage, path)
+}
+
+func storeLinkIssue(ctx context.Context, storage logical.Storage, params IssueLinkParameters) (*IssueLinkStorage, error) {
+	path := getLinkIssuePath(params.Operator, params.Link)
+
+	issue, err := getFromStorage[IssueLinkStorage](ctx, storage, path)
+	if err != nil {
+		return nil, err
+	}
+	if issue == nil {
+		issue = &IssueLinkStorage{}
+	}
+
+	issue.Operator = params.Operator
+	issue.Link = params.Link
+	issue.Exporter = params.Exporter
+	issue.Importer = params.Importer
+	issue.Subject = params.Subject
+	issue.LocalSubject = params.LocalSubject
+	issue.Type = params.Type
+	issue.TokenReq = params.TokenReq
+	issue.Version++
+	err = storeInStorage(ctx, storage, path, issue)
+	if err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+func getLinkIssuePath(operator string, link string) string {
+	return "issue/operator/" + operator + "/link/" + link
+}
+
+func getIssueLinkStatus(ctx context.Context, storage logical.Storage, issue *IssueLinkStorage) *IssueLinkStatus {
+	var status IssueLinkStatus
+
+	exporter, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Exporter,
+	})
+	if err == nil && exporter != nil && findExport(exporter, issue.Subject) != nil {
+		status.Export = true
+	}
+
+	importer, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Importer,
+	})
+	if err == nil && importer != nil {
+		for _, imp := range importer.Claims.Imports {
+			if imp.Name == linkEntryPrefix+issue.Link {
+				status.Import = true
+				status.Activation = imp.Token != ""
+			}
+		}
+	}
+	return &status
+}
+
+// createResponseIssueLinkResult returns the stored link with its status and
+// the warnings of the JWTs of both accounts
+func createResponseIssueLinkResult(ctx context.Context, storage logical.Storage, params IssueLinkParameters) (*logical.Response, error) {
+	issue, err := readLinkIssue(ctx, storage, params)
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return nil, errNotFound("link issue does not exist: %s", params.Link)
+	}
+
+	status := getIssueLinkStatus(ctx, storage, issue)
+	resp, err := createResponseIssueLinkData(issue, status)
+	if err != nil {
+		return nil, err
+	}
+	resp = addStoredJWTWarnings(ctx, storage, resp, getAccountJWTPath(issue.Operator, issue.Exporter))
+	return addStoredJWTWarnings(ctx, storage, resp, getAccountJWTPath(issue.Operator, issue.Importer)), nil
+}
+
+func createResponseIssueLinkData(issue *IssueLinkStorage, status *IssueLinkStatus) (*logical.Response, error) {
+	data := &IssueLinkData{
+		Operator:     issue.Operator,
+		Link:         issue.Link,
+		Exporter:     issue.Exporter,
+		Importer:     issue.Importer,
+		Subject:      issue.Subject,
+		LocalSubject: issue.LocalSubject,
+		Type:         issue.Type,
+		TokenReq:     issue.TokenReq,
+		Version:      issue.Version,
+		Status:       *status,
+	}
+
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: rval,
+	}
+	return resp, nil
+}
@@ -0,0 +1,299 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkIssue(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	// accounts must allow exports and imports
+	limits := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"account": map[string]interface{}{
+				"limits": map[string]interface{}{
+					"imports":         -1,
+					"exports":         -1,
+					"wildcardExports": true,
+				},
+			},
+		},
+	}
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{}},
+		{"issue/operator/op1/account/exporter", limits},
+		{"issue/operator/op1/account/importer", limits},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+
+	exporterPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "exporter")
+	require.NoError(t, err)
+	importerPub, err := resolveAccountPublicKey(context.Background(), reqStorage, "op1", "importer")
+	require.NoError(t, err)
+
+	readAccountClaims := func(t *testing.T, account string) *jwt.AccountClaims {
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{
+			Operator: "op1",
+			Account:  account,
+		})
+		require.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		return claims
+	}
+
+	t.Run("Create private service link", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"exporter":     "exporter",
+				"importer":     "importer",
+				"subject":      "svc.>",
+				"localSubject": "remote.svc.>",
+				"type":         "service",
+				"tokenReq":     true,
+			},
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		assert.Equal(t, "Service", resp.Data["type"])
+		assert.Equal(t, map[string]interface{}{
+			"export":     true,
+			"import":     true,
+			"activation": true,
+		}, resp.Data["status"])
+
+		exporter := readAccountClaims(t, "exporter")
+		require.Len(t, exporter.Exports, 1)
+		assert.Equal(t, jwt.Subject("svc.>"), exporter.Exports[0].Subject)
+		assert.True(t, exporter.Exports[0].TokenReq)
+
+		importer := readAccountClaims(t, "importer")
+		require.Len(t, importer.Imports, 1)
+		assert.Equal(t, exporterPub, importer.Imports[0].Account)
+		assert.Equal(t, jwt.RenamingSubject("remote.svc.>"), importer.Imports[0].LocalSubject)
+		activation, err := jwt.DecodeActivationClaims(importer.Imports[0].Token)
+		assert.NoError(t, err)
+		assert.Equal(t, importerPub, activation.Subject)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "issue/operator/op1/link/",
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		assert.Equal(t, []string{"svc"}, resp.Data["keys"])
+	})
+
+	t.Run("Reject invalid links", func(t *testing.T) {
+		for _, data := range []map[string]interface{}{
+			// missing importer
+			{"exporter": "exporter", "subject": "foo", "type": "Stream"},
+			// same account
+			{"exporter": "exporter", "importer": "exporter", "subject": "foo", "type": "Stream"},
+			// unknown account
+			{"exporter": "exporter", "importer": "unknown", "subject": "foo", "type": "Stream"},
+			// invalid type
+			{"exporter": "exporter", "importer": "importer", "subject": "foo", "type": "other"},
+			// type of existing export does not match
+			{"exporter": "exporter", "importer": "importer", "subject": "svc.>", "type": "Stream"},
+			// existing export requires a token
+			{"exporter": "exporter", "importer": "importer", "subject": "svc.>", "type": "Service"},
+			// unknown field
+			{"exporter": "exporter", "importer": "importer", "subject": "foo", "type": "Stream", "tokenRequired": true},
+		} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.CreateOperation,
+				Path:      "issue/operator/op1/link/invalid",
+				Storage:   reqStorage,
+				Data:      data,
+			})
+			assert.Error(t, err)
+			assert.True(t, isErrorResponse(resp), "%v", data)
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/link/invalid",
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+	})
+
+	t.Run("Patch link raises the version of the accounts", func(t *testing.T) {
+		before, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "importer"})
+		require.NoError(t, err)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"localSubject": "other.svc.>"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		after, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "importer"})
+		require.NoError(t, err)
+		assert.Greater(t, after.Version, before.Version)
+
+		// a write based on the claims before the link changed them is refused
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/importer",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"claims": limits["claims"],
+				"cas":    before.Version,
+			},
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"unknown": true},
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("Delete link", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+
+		assert.Empty(t, readAccountClaims(t, "exporter").Exports)
+		assert.Empty(t, readAccountClaims(t, "importer").Imports)
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "issue/operator/op1/link/svc",
+			Storage:   reqStorage,
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("Failed link leaves the exporter unchanged", func(t *testing.T) {
+		// the importer does not allow imports, so signing it fails
+		// after the export was added
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/limited",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		before := readAccountClaims(t, "exporter")
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/link/failing",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"exporter": "exporter",
+				"importer": "limited",
+				"subject":  "failing.>",
+				"type":     "Stream",
+			},
+		})
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
+
+		assert.Equal(t, before.Exports, readAccountClaims(t, "exporter").Exports)
+		link, err := readLinkIssue(context.Background(), reqStorage, IssueLinkParameters{Operator: "op1", Link: "failing"})
+		require.NoError(t, err)
+		assert.Nil(t, link)
+		ids, err := framework.ListWAL(context.Background(), reqStorage)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+
+	t.Run("Relink through a shared export", func(t *testing.T) {
+		exporterClaims := map[string]interface{}{
+			"account": map[string]interface{}{
+				"limits": limits["claims"].(map[string]interface{})["account"].(map[string]interface{})["limits"],
+				"exports": []interface{}{
+					map[string]interface{}{"name": "data", "subject": "data.>", "type": "Service", "tokenReq": true},
+				},
+			},
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/account/exporter",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"claims": exporterClaims},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		for _, operation := range []logical.Operation{logical.CreateOperation, logical.PatchOperation} {
+			data := map[string]interface{}{
+				"exporter": "exporter",
+				"importer": "importer",
+				"subject":  "data.>",
+				"type":     "service",
+				"tokenReq": true,
+			}
+			if operation == logical.PatchOperation {
+				// the update unlinks and links the accounts again
+				data = map[string]interface{}{"localSubject": "remote.data.>"}
+			}
+			resp, err = b.HandleRequest(context.Background(), &logical.Request{
+				Operation: operation,
+				Path:      "issue/operator/op1/link/data",
+				Storage:   reqStorage,
+				Data:      data,
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+
+		exporter := readAccountClaims(t, "exporter")
+		require.Len(t, exporter.Exports, 1)
+		importer := readAccountClaims(t, "importer")
+		require.Len(t, importer.Imports, 1)
+		activation, err := jwt.DecodeActivationClaims(importer.Imports[0].Token)
+		require.NoError(t, err)
+		assert.False(t, exporter.Exports[0].Revocations.IsRevoked(importerPub, time.Unix(activation.IssuedAt, 0)))
+	})
+}
@@ -14,14 +14,19 @@ import (
 	accountv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/common"
 	operatorv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/operator/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
 )
 
 type IssueOperatorStorage struct {
 	Operator            string                    `json:"operator"`
 	CreateSystemAccount bool                      `json:"createSystemAccount"`
 	SyncAccountServer   bool                      `json:"syncAccountServer"`
+	DeletionProtection  bool                      `json:"deletionProtection"`
 	Claims              operatorv1.OperatorClaims `json:"claims"`
+	Version             int64                     `json:"version"`
+	SigningKeyRotation  *SigningKeyRotation       `json:"signingKeyRotation,omitempty"`
 }
 
 // IssueOperatorParameters
@@ -30,21 +35,26 @@ type IssueOperatorParameters struct {
 	Operator            string                    `json:"operator"`
 	CreateSystemAccount bool                      `json:"createSystemAccount,omitempty"`
 	SyncAccountServer   bool                      `json:"syncAccountServer,omitempty"`
+	DeletionProtection  bool                      `json:"deletionProtection,omitempty"`
 	Claims              operatorv1.OperatorClaims `json:"claims,omitempty"`
+	CAS                 *int64                    `json:"cas,omitempty"`
 }
 
 type IssueOperatorData struct {
 	Operator            string                    `json:"operator"`
 	CreateSystemAccount bool                      `json:"createSystemAccount"`
 	SyncAccountServer   bool                      `json:"syncAccountServer"`
+	DeletionProtection  bool                      `json:"deletionProtection"`
 	Claims              operatorv1.OperatorClaims `json:"claims"`
+	Version             int64                     `json:"version"`
 	Status              IssueOperatorStatus       `json:"status"`
 }
 
 type IssueOperatorStatus struct {
-	Operator          IssueStatus `json:"operator"`
-	SystemAccount     IssueStatus `json:"systemAccount"`
-	SystemAccountUser IssueStatus `json:"systemAccountUser"`
+	Operator           IssueStatus         `json:"operator"`
+	SystemAccount      IssueStatus         `json:"systemAccount"`
+	SystemAccountUser  IssueStatus         `json:"systemAccountUser"`
+	SigningKeyRotation *SigningKeyRotation `json:"signingKeyRotation,omitempty"`
 }
 
 type IssueStatus struct {
@@ -77,6 +87,10 @@ func pathOperatorIssue(b *NatsBackend) []*framework.Path {
 					Description: "Sync account jwt's with account server",
 					Required:    false,
 				},
+				"deletionProtection": deletionProtectionField,
+				"cas":                casField,
+				"cascade":            cascadeField,
+				"dryRun":             dryRunField,
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.CreateOperation: &framework.PathOperation{
@@ -85,6 +99,9 @@ func pathOperatorIssue(b *NatsBackend) []*framework.Path {
 				logical.UpdateOperation: &framework.PathOperation{
 					Callback: b.pathAddOperatorIssue,
 				},
+				logical.PatchOperation: &framework.PathOperation{
+					Callback: b.pathPatchOperatorIssue,
+				},
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.pathReadOperatorIssue,
 				},
@@ -112,43 +129,106 @@ func pathOperatorIssue(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddOperatorIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
-	jsonString, err := json.Marshal(data.Raw)
+	params := IssueOperatorParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+	}, func() error {
+		return addOperatorIssue(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueOperatorResult(ctx, req.Storage, params.Operator)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathPatchOperatorIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	input := IssueOperatorParameters{}
+	err = validate.StrictDecode(data.Raw, &input)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
+
+	defer b.lockOperator(input.Operator)()
+
+	issue, err := readOperatorIssue(ctx, req.Storage, input)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue == nil {
+		return errorResponse(IssueNotFoundError, nil)
+	}
+
 	params := IssueOperatorParameters{}
-	json.Unmarshal(jsonString, &params)
+	err = patchIssue(data, &IssueOperatorParameters{
+		Operator:            issue.Operator,
+		CreateSystemAccount: issue.CreateSystemAccount,
+		SyncAccountServer:   issue.SyncAccountServer,
+		DeletionProtection:  issue.DeletionProtection,
+		Claims:              issue.Claims,
+	}, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	params.CAS = input.CAS
 
-	err = addOperatorIssue(ctx, req.Storage, params)
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+	}, func() error {
+		return addOperatorIssue(ctx, req.Storage, params)
+	})
 	if err != nil {
-		return logical.ErrorResponse(AddingIssueFailedError + ":" + err.Error()), nil
+		return errorResponse(AddingIssueFailedError, err)
 	}
-	return nil, nil
+
+	resp, err := createResponseIssueOperatorResult(ctx, req.Storage, params.Operator)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
 }
 
 func (b *NatsBackend) pathReadOperatorIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueOperatorParameters{}
 	json.Unmarshal(jsonString, &params)
 
 	issue, err := readOperatorIssue(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingIssueFailedError), nil
+		return errorResponse(ReadingIssueFailedError, err)
 	}
 
 	if issue == nil {
-		return logical.ErrorResponse(IssueNotFoundError), nil
+		return errorResponse(IssueNotFoundError, nil)
 	}
 
 	status := getIssueOperatorStatus(ctx, req.Storage, issue)
@@ -158,12 +238,12 @@ func (b *NatsBackend) pathReadOperatorIssue(ctx context.Context, req *logical.Re
 func (b *NatsBackend) pathListOperatorIssues(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	entries, err := listOperatorIssues(ctx, req.Storage)
 	if err != nil {
-		return logical.ErrorResponse(ListIssuesFailedError), nil
+		return errorResponse(ListIssuesFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -172,23 +252,78 @@ func (b *NatsBackend) pathListOperatorIssues(ctx context.Context, req *logical.R
 func (b *NatsBackend) pathDeleteOperatorIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueOperatorParameters{}
 	json.Unmarshal(jsonString, &params)
 
-	// delete issue and all related nkeys and jwt
-	err = deleteOperatorIssue(ctx, req.Storage, params)
+	defer b.lockOperator(params.Operator)()
+
+	cascade := data.Get("cascade").(bool)
+	dryRun := data.Get("dryRun").(bool)
+
+	if dryRun {
+		blockers, err := operatorDeletionBlockers(ctx, req.Storage, params.Operator, cascade)
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
+		var plan cascadePlan
+		if cascade {
+			plan, err = planOperatorCascade(ctx, req.Storage, params.Operator)
+		} else {
+			plan, err = planPlainOperatorDelete(ctx, req.Storage, params.Operator)
+		}
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
+		resp := createResponseCascade(plan.keys(), true)
+		if len(blockers) > 0 {
+			resp.Data["blockers"] = blockers
+		}
+		return resp, nil
+	}
+
+	err = checkOperatorDeletionProtection(ctx, req.Storage, params.Operator, cascade)
 	if err != nil {
-		return logical.ErrorResponse(DeleteIssueFailedError), nil
+		return errorResponse(DeleteIssueFailedError, err)
+	}
+
+	entry := &issueWALEntry{
+		Operation: walOperationDelete,
+		Operator:  params.Operator,
+		Cascade:   cascade,
+	}
+	issue, err := readOperatorIssue(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue != nil {
+		// keep the deleted entries for restore
+		entry.Tombstone, err = createTombstone(ctx, req.Storage, params.Operator, "")
+		if err != nil {
+			return errorResponse(DeleteIssueFailedError, err)
+		}
 	}
-	return nil, nil
 
+	// delete issue and all related nkeys and jwt, with cascade all issues below as well
+	var deleted []string
+	err = withIssueWAL(ctx, req.Storage, entry, func() error {
+		var err error
+		deleted, err = entry.deleteIssue(ctx, req.Storage)
+		return err
+	})
+	if err != nil {
+		return errorResponse(DeleteIssueFailedError, err)
+	}
+	if cascade {
+		return createResponseCascade(deleted, false), nil
+	}
+	return nil, nil
 }
 func addOperatorIssue(ctx context.Context, storage logical.Storage, params IssueOperatorParameters) error {
 	log.Info().
@@ -232,10 +367,21 @@ func refreshAccountResolvers(ctx context.Context, storage logical.Storage, issue
 				return err
 			}
 			for _, account := range accounts {
-				err = refreshAccountResolverPush(ctx, storage, &IssueAccountStorage{
+				acc, err := readAccountIssue(ctx, storage, IssueAccountParameters{
 					Operator: issue.Operator,
 					Account:  account,
 				})
+				if err != nil {
+					return err
+				} else if acc == nil {
+					continue
+				}
+				err = refreshAccountResolverPush(ctx, storage, acc)
+				if err != nil {
+					return err
+				}
+				// keep the outcome of the sync in the account status
+				_, err = storeAccountIssueUpdate(ctx, storage, acc)
 				if err != nil {
 					return err
 				}
@@ -295,6 +441,33 @@ func deleteOperatorIssue(ctx context.Context, storage logical.Storage, params Is
 		return nil
 	}
 
+	// if generated, delete system account first, its push user is not
+	// revoked as re-signing the account requires the operator nkey
+	if issue.CreateSystemAccount {
+		pushUser, err := readUserIssue(ctx, storage, IssueUserParameters{
+			Operator: issue.Operator,
+			Account:  DefaultSysAccountName,
+			User:     DefaultPushUser,
+		})
+		if err != nil {
+			return err
+		}
+		if pushUser != nil {
+			err = removeUserIssue(ctx, storage, pushUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = deleteAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: issue.Operator,
+			Account:  DefaultSysAccountName,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// delete operator nkey
 	nkey := NkeyParameters{
 		Operator: issue.Operator,
@@ -316,26 +489,6 @@ func deleteOperatorIssue(ctx context.Context, storage logical.Storage, params Is
 		}
 	}
 
-	// if generated, delete system account
-	if issue.CreateSystemAccount {
-		err := deleteUserIssue(ctx, storage, IssueUserParameters{
-			Operator: issue.Operator,
-			Account:  DefaultSysAccountName,
-			User:     DefaultPushUser,
-		})
-		if err != nil {
-			return err
-		}
-
-		err = deleteAccountIssue(ctx, storage, IssueAccountParameters{
-			Operator: issue.Operator,
-			Account:  DefaultSysAccountName,
-		})
-		if err != nil {
-			return err
-		}
-	}
-
 	// delete operator jwt
 	jwt := JWTParameters{
 		Operator: issue.Operator,
@@ -357,9 +510,23 @@ func storeOperatorIssue(ctx context.Context, storage logical.Storage, params Iss
 	if err != nil {
 		return nil, err
 	}
-	if issue == nil {
+	exists := issue != nil
+	if !exists {
 		issue = &IssueOperatorStorage{}
-	} else {
+	}
+	err = checkCAS(params.CAS, issue.Version)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.Validate(schema.Operator, params.Claims)
+	if err != nil {
+		return nil, errInvalid("%w", err)
+	}
+	err = issue.SigningKeyRotation.checkSigningKeys(params.Claims.SigningKeys)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
 		// diff current and incomming signing keys
 		// delete removed signing keys
 		for _, signingKey := range issue.Claims.SigningKeys {
@@ -390,6 +557,8 @@ func storeOperatorIssue(ctx context.Context, storage logical.Storage, params Iss
 	issue.Claims.SigningKeys = params.Claims.SigningKeys
 	issue.Claims.AccountServerURL = params.Claims.AccountServerURL
 	issue.SyncAccountServer = params.SyncAccountServer
+	issue.DeletionProtection = params.DeletionProtection
+	issue.Version++
 	err = storeInStorage(ctx, storage, path, issue)
 	if err != nil {
 		return nil, err
@@ -524,9 +693,17 @@ func issueOperatorJWT(ctx context.Context, storage logical.Storage, issue IssueO
 
 	issue.Claims.ClaimsData.Subject = operatorPublicKey
 	issue.Claims.ClaimsData.Issuer = operatorPublicKey
+	if issue.Claims.ClaimsData.Name == "" {
+		// nsc and other tools identify entities by name
+		issue.Claims.ClaimsData.Name = issue.Operator
+	}
 	issue.Claims.Operator.SystemAccount = sysAccountPublicKey
 	issue.Claims.Operator.SigningKeys = signingPublicKeys
 	natsJwt := operatorv1.Convert(&issue.Claims)
+	_, err = validate.Claims(natsJwt)
+	if err != nil {
+		return errInvalid("%w", err)
+	}
 	token, err := natsJwt.Encode(operatorKeyPair)
 	if err != nil {
 		return fmt.Errorf("could not encode operator jwt: %s", err)
@@ -655,6 +832,7 @@ func getOperatorIssuePath(operator string) string {
 
 func getIssueOperatorStatus(ctx context.Context, storage logical.Storage, issue *IssueOperatorStorage) *IssueOperatorStatus {
 	var status IssueOperatorStatus
+	status.SigningKeyRotation = issue.SigningKeyRotation
 
 	// operator status
 	nkey, err := readOperatorNkey(ctx, storage, NkeyParameters{
@@ -711,12 +889,67 @@ func getIssueOperatorStatus(ctx context.Context, storage logical.Storage, issue
 	return &status
 }
 
+// createResponseIssueOperatorResult returns the stored operator issue with
+// the keys, the jwt and the account server sync outcome computed for it
+func createResponseIssueOperatorResult(ctx context.Context, storage logical.Storage, operator string) (*logical.Response, error) {
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: operator,
+	})
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return nil, errNotFound("operator issue does not exist: %s", operator)
+	}
+
+	status := getIssueOperatorStatus(ctx, storage, issue)
+	resp, err := createResponseIssueOperatorData(issue, status)
+	if err != nil {
+		return nil, err
+	}
+
+	signingKeys := map[string]string{}
+	for _, signingKey := range issue.Claims.SigningKeys {
+		signingKeys[signingKey] = getOperatorSigningNkeyPath(operator, signingKey)
+	}
+	result, err := getIssueResult(ctx, storage, getOperatorNkeyPath(operator), signingKeys, getOperatorJWTPath(operator))
+	if err != nil {
+		return nil, err
+	}
+
+	if issue.SyncAccountServer {
+		accounts, err := listAccountIssues(ctx, storage, operator)
+		if err != nil {
+			return nil, err
+		}
+		result.AccountServer = map[string]AccountServerStatus{}
+		for _, account := range accounts {
+			acc, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+				Operator: operator,
+				Account:  account,
+			})
+			if err != nil {
+				return nil, err
+			} else if acc != nil {
+				result.AccountServer[account] = acc.Status.AccountServer
+			}
+		}
+	}
+
+	err = addIssueResult(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return addStoredJWTWarnings(ctx, storage, resp, getOperatorJWTPath(operator)), nil
+}
+
 func createResponseIssueOperatorData(issue *IssueOperatorStorage, status *IssueOperatorStatus) (*logical.Response, error) {
 	data := &IssueOperatorData{
 		Operator:            issue.Operator,
 		CreateSystemAccount: issue.CreateSystemAccount,
 		SyncAccountServer:   issue.SyncAccountServer,
+		DeletionProtection:  issue.DeletionProtection,
 		Claims:              issue.Claims,
+		Version:             issue.Version,
 		Status:              *status,
 	}
 
@@ -0,0 +1,348 @@
+package natsbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSigningKeyGracePeriod is how long a rotated signing key stays valid
+const DefaultSigningKeyGracePeriod = 24 * time.Hour
+
+type signingKeyRotationPhase string
+
+const (
+	// the issues signed with the old key are re-signed with the new one
+	signingKeyRotationResigning signingKeyRotationPhase = "resigning"
+	// the changed accounts are pushed until the account server confirmed all of them
+	signingKeyRotationPushing signingKeyRotationPhase = "pushing"
+	// the old key stays valid until retireAt
+	signingKeyRotationGrace signingKeyRotationPhase = "grace"
+	// the old key is retired
+	signingKeyRotationCompleted signingKeyRotationPhase = "completed"
+)
+
+// SigningKeyRotation is the progress of replacing the signing key From by To
+type SigningKeyRotation struct {
+	From         string                  `json:"from"`
+	To           string                  `json:"to"`
+	Phase        signingKeyRotationPhase `json:"phase"`
+	GracePeriodS int64                   `json:"gracePeriod"`
+	StartedAt    int64                   `json:"startedAt"`
+	RetireAt     int64                   `json:"retireAt,omitempty"`
+	CompletedAt  int64                   `json:"completedAt,omitempty"`
+	// Resigned are the accounts or users that were re-signed with the new key
+	Resigned []string `json:"resigned,omitempty"`
+	// Pending are the changed accounts not yet confirmed by the account server
+	Pending []string `json:"pending,omitempty"`
+	// Blocking are the users without expiration still using the old key
+	Blocking []string `json:"blocking,omitempty"`
+}
+
+// RotateOperatorSigningKeyParameters starts the rotation of an operator signing key
+type RotateOperatorSigningKeyParameters struct {
+	Operator      string `json:"operator"`
+	SigningKey    string `json:"signingKey"`
+	NewSigningKey string `json:"newSigningKey"`
+	GracePeriodS  *int64 `json:"gracePeriod,omitempty"`
+}
+
+func pathOperatorSigningKeyRotation(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "issue/operator/" + framework.GenericNameRegex("operator") + "/rotate$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": {
+					Type:        framework.TypeString,
+					Description: "operator identifier",
+					Required:    false,
+				},
+				"signingKey": {
+					Type:        framework.TypeString,
+					Description: "Name of the operator signing key to retire",
+					Required:    true,
+				},
+				"newSigningKey": {
+					Type:        framework.TypeString,
+					Description: "Name of the operator signing key replacing it",
+					Required:    true,
+				},
+				"gracePeriod": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How long the retired key stays valid after all accounts were confirmed (default: 24h)",
+					Required:    false,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRotateOperatorSigningKey,
+				},
+			},
+			HelpSynopsis:    `Rotates an operator signing key.`,
+			HelpDescription: ``,
+		},
+	}
+}
+
+func (b *NatsBackend) pathRotateOperatorSigningKey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := RotateOperatorSigningKeyParameters{
+		Operator:      data.Get("operator").(string),
+		SigningKey:    data.Get("signingKey").(string),
+		NewSigningKey: data.Get("newSigningKey").(string),
+	}
+	if gracePeriod, ok := data.GetOk("gracePeriod"); ok {
+		seconds := int64(gracePeriod.(int))
+		params.GracePeriodS = &seconds
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	err = rotateOperatorSigningKey(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueOperatorResult(ctx, req.Storage, params.Operator)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+// rotateOperatorSigningKey adds the new signing key to the operator and
+// advances the rotation as far as possible
+func rotateOperatorSigningKey(ctx context.Context, storage logical.Storage, params RotateOperatorSigningKeyParameters) error {
+	log.Info().
+		Str("operator", params.Operator).
+		Str("signingKey", params.SigningKey).Str("newSigningKey", params.NewSigningKey).
+		Msgf("rotate operator signing key")
+
+	issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+		Operator: params.Operator,
+	})
+	if err != nil {
+		return err
+	}
+	if issue == nil {
+		return errNotFound("operator issue does not exist: %s", params.Operator)
+	}
+	if issue.SigningKeyRotation.active() {
+		return errConflict("rotation of signing key %s is in progress", issue.SigningKeyRotation.From)
+	}
+	if !containsString(issue.Claims.SigningKeys, params.SigningKey) {
+		return errInvalid("signing key does not exist: %s", params.SigningKey)
+	}
+	if params.NewSigningKey == "" || containsString(issue.Claims.SigningKeys, params.NewSigningKey) {
+		return errInvalid("new signing key must be a new name: %s", params.NewSigningKey)
+	}
+	gracePeriod := int64(DefaultSigningKeyGracePeriod / time.Second)
+	if params.GracePeriodS != nil {
+		gracePeriod = *params.GracePeriodS
+	}
+	if gracePeriod < 0 {
+		return errInvalid("gracePeriod must not be negative")
+	}
+
+	// 1. add the new signing key
+	issue.SigningKeyRotation = &SigningKeyRotation{
+		From:         params.SigningKey,
+		To:           params.NewSigningKey,
+		Phase:        signingKeyRotationResigning,
+		GracePeriodS: gracePeriod,
+		StartedAt:    time.Now().Unix(),
+	}
+	issue.Claims.SigningKeys = append(issue.Claims.SigningKeys, params.NewSigningKey)
+	issue.Version++
+	err = storeInStorage(ctx, storage, getOperatorIssuePath(issue.Operator), issue)
+	if err != nil {
+		return err
+	}
+	err = refreshOperator(ctx, storage, issue)
+	if err != nil {
+		return err
+	}
+
+	return advanceOperatorSigningKeyRotation(ctx, storage, issue)
+}
+
+// advanceOperatorSigningKeyRotation moves the rotation of the operator through
+// its phases. It is called when the rotation starts and periodically until it
+// completed.
+func advanceOperatorSigningKeyRotation(ctx context.Context, storage logical.Storage, issue *IssueOperatorStorage) error {
+	rotation := issue.SigningKeyRotation
+	if !rotation.active() {
+		return nil
+	}
+	store := func() error {
+		return storeInStorage(ctx, storage, getOperatorIssuePath(issue.Operator), issue)
+	}
+
+	// 2. re-sign every account using the old key
+	if rotation.Phase == signingKeyRotationResigning {
+		resigned, err := resignOperatorAccounts(ctx, storage, issue.Operator, rotation.From, rotation.To)
+		if err != nil {
+			return err
+		}
+		rotation.Resigned = resigned
+		rotation.Pending = resigned
+		rotation.Phase = signingKeyRotationPushing
+		err = store()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 3. push the re-signed accounts until all of them are confirmed
+	if rotation.Phase == signingKeyRotationPushing {
+		pending, err := pushPendingAccounts(ctx, storage, issue, rotation.Pending)
+		if err != nil {
+			return err
+		}
+		rotation.Pending = pending
+		if len(pending) == 0 {
+			rotation.Phase = signingKeyRotationGrace
+			rotation.RetireAt = time.Now().Unix() + rotation.GracePeriodS
+		}
+		err = store()
+		if err != nil {
+			return err
+		}
+	}
+
+	// 4. retire the old key after the grace period
+	if rotation.Phase == signingKeyRotationGrace && time.Now().Unix() >= rotation.RetireAt {
+		log.Info().
+			Str("operator", issue.Operator).Str("signingKey", rotation.From).
+			Msgf("retire operator signing key")
+
+		// accounts changed to the old key meanwhile would break
+		_, err := resignOperatorAccounts(ctx, storage, issue.Operator, rotation.From, rotation.To)
+		if err != nil {
+			return err
+		}
+
+		signingKeys := []string{}
+		for _, signingKey := range issue.Claims.SigningKeys {
+			if signingKey != rotation.From {
+				signingKeys = append(signingKeys, signingKey)
+			}
+		}
+		issue.Claims.SigningKeys = signingKeys
+		rotation.Phase = signingKeyRotationCompleted
+		rotation.CompletedAt = time.Now().Unix()
+		issue.Version++
+		err = store()
+		if err != nil {
+			return err
+		}
+		err = deleteOperatorSigningNkey(ctx, storage, NkeyParameters{
+			Operator: issue.Operator,
+			Signing:  rotation.From,
+		})
+		if err != nil {
+			return err
+		}
+		return refreshOperator(ctx, storage, issue)
+	}
+	return nil
+}
+
+// resignOperatorAccounts switches the accounts signed with the signing key
+// from to the signing key to and returns their names
+func resignOperatorAccounts(ctx context.Context, storage logical.Storage, operator string, from string, to string) ([]string, error) {
+	accounts, err := listAccountIssues(ctx, storage, operator)
+	if err != nil {
+		return nil, err
+	}
+	resigned := []string{}
+	for _, account := range accounts {
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: operator,
+			Account:  account,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue == nil || issue.UseSigningKey != from {
+			continue
+		}
+		issue.UseSigningKey = to
+		issue.Version++
+		err = refreshAccount(ctx, storage, issue)
+		if err != nil {
+			return nil, err
+		}
+		resigned = append(resigned, account)
+	}
+	return resigned, nil
+}
+
+// pushPendingAccounts pushes the accounts to the account server and returns
+// those the account server did not confirm. Without account server sync
+// there is nothing to confirm.
+func pushPendingAccounts(ctx context.Context, storage logical.Storage, op *IssueOperatorStorage, accounts []string) ([]string, error) {
+	if !op.SyncAccountServer {
+		return nil, nil
+	}
+	pending := []string{}
+	for _, account := range accounts {
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: op.Operator,
+			Account:  account,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue == nil {
+			// deleted meanwhile
+			continue
+		}
+		err = refreshAccountResolverPush(ctx, storage, issue)
+		if err != nil {
+			return nil, err
+		}
+		_, err = storeAccountIssueUpdate(ctx, storage, issue)
+		if err != nil {
+			return nil, err
+		}
+		if !issue.Status.AccountServer.Synced {
+			pending = append(pending, account)
+		}
+	}
+	return pending, nil
+}
+
+// active reports whether the rotation has not completed yet
+func (r *SigningKeyRotation) active() bool {
+	return r != nil && r.Phase != signingKeyRotationCompleted
+}
+
+// checkSigningKeys refuses signing keys dropping a key of an active rotation
+func (r *SigningKeyRotation) checkSigningKeys(signingKeys []string) error {
+	if !r.active() {
+		return nil
+	}
+	for _, signingKey := range []string{r.From, r.To} {
+		if !containsString(signingKeys, signingKey) {
+			return errConflict("signing key %s is being rotated", signingKey)
+		}
+	}
+	return nil
+}
+
+func containsString(a []string, x string) bool {
+	for _, n := range a {
+		if x == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOperatorSigningKeyRotation(t *testing.T, operator map[string]interface{}) (*NatsBackend, logical.Storage) {
+	t.Helper()
+	b, reqStorage := getTestBackend(t)
+
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", operator},
+		{"issue/operator/op1/account/ac1", map[string]interface{}{"useSigningKey": "opsk1"}},
+		{"issue/operator/op1/account/ac2", map[string]interface{}{}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	return b, reqStorage
+}
+
+func operatorSigningKeyPublicKey(t *testing.T, storage logical.Storage, signingKey string) string {
+	t.Helper()
+	nkey, err := readOperatorSigningNkey(context.Background(), storage, NkeyParameters{
+		Operator: "op1",
+		Signing:  signingKey,
+	})
+	require.NoError(t, err)
+	if nkey == nil {
+		return ""
+	}
+	data, err := toNkeyData(nkey)
+	require.NoError(t, err)
+	return data.PublicKey
+}
+
+func accountIssuer(t *testing.T, storage logical.Storage, account string) string {
+	t.Helper()
+	accJWT, err := readAccountJWT(context.Background(), storage, JWTParameters{
+		Operator: "op1",
+		Account:  account,
+	})
+	require.NoError(t, err)
+	claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+	require.NoError(t, err)
+	return claims.Issuer
+}
+
+func TestOperatorSigningKeyRotation(t *testing.T) {
+	operator := map[string]interface{}{
+		"claims": map[string]interface{}{
+			"operator": map[string]interface{}{
+				"signingKeys": []interface{}{"opsk1"},
+			},
+		},
+	}
+
+	t.Run("rotation without grace period completes right away", func(t *testing.T) {
+		b, reqStorage := setupOperatorSigningKeyRotation(t, operator)
+		ac2Issuer := accountIssuer(t, reqStorage, "ac2")
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/rotate",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"signingKey":    "opsk1",
+				"newSigningKey": "opsk2",
+				"gracePeriod":   0,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		rotation := resp.Data["status"].(map[string]interface{})["signingKeyRotation"].(map[string]interface{})
+		assert.Equal(t, "completed", rotation["phase"])
+		assert.Equal(t, []interface{}{"ac1"}, rotation["resigned"])
+
+		issue, err := readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"opsk2"}, issue.Claims.SigningKeys)
+		assert.Empty(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk1"))
+
+		ac1, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, "opsk2", ac1.UseSigningKey)
+		assert.Equal(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk2"), accountIssuer(t, reqStorage, "ac1"))
+		assert.Equal(t, ac2Issuer, accountIssuer(t, reqStorage, "ac2"))
+	})
+
+	t.Run("old key is retired after the grace period", func(t *testing.T) {
+		b, reqStorage := setupOperatorSigningKeyRotation(t, operator)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/rotate",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"signingKey":    "opsk1",
+				"newSigningKey": "opsk2",
+				"gracePeriod":   "1h",
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationGrace, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"opsk1", "opsk2"}, issue.Claims.SigningKeys)
+		assert.NotEmpty(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk1"))
+		assert.Equal(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk2"), accountIssuer(t, reqStorage, "ac1"))
+
+		// the keys of the rotation can't be dropped and no second rotation starts
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+			Data:      operator,
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/rotate",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"signingKey":    "opsk2",
+				"newSigningKey": "opsk3",
+			},
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+
+		// the periodic function retires the key once the grace period is over
+		issue.SigningKeyRotation.RetireAt = time.Now().Add(-time.Minute).Unix()
+		err = storeInStorage(context.Background(), reqStorage, getOperatorIssuePath("op1"), issue)
+		require.NoError(t, err)
+		err = b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage})
+		require.NoError(t, err)
+
+		issue, err = readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationCompleted, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"opsk2"}, issue.Claims.SigningKeys)
+		assert.Empty(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk1"))
+	})
+
+	t.Run("unconfirmed accounts keep the rotation pushing", func(t *testing.T) {
+		b, reqStorage := setupOperatorSigningKeyRotation(t, map[string]interface{}{
+			"syncAccountServer": true,
+			"claims": map[string]interface{}{
+				"operator": map[string]interface{}{
+					"signingKeys":      []interface{}{"opsk1"},
+					"accountServerURL": "nats://localhost:4222",
+				},
+			},
+		})
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "issue/operator/op1/rotate",
+			Storage:   reqStorage,
+			Data: map[string]interface{}{
+				"signingKey":    "opsk1",
+				"newSigningKey": "opsk2",
+				"gracePeriod":   0,
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Equal(t, signingKeyRotationPushing, issue.SigningKeyRotation.Phase)
+		assert.Equal(t, []string{"ac1"}, issue.SigningKeyRotation.Pending)
+		assert.NotEmpty(t, operatorSigningKeyPublicKey(t, reqStorage, "opsk1"))
+	})
+}
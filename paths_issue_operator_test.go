@@ -27,8 +27,8 @@ func TestCRUDOperatorIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -70,6 +70,7 @@ func TestCRUDOperatorIssue(t *testing.T) {
 		expected = IssueOperatorData{
 			Operator: "op1",
 			Claims:   v1alpha1.OperatorClaims{},
+			Version:  1,
 			Status: IssueOperatorStatus{
 				Operator: IssueStatus{
 					Nkey: true,
@@ -137,6 +138,7 @@ func TestCRUDOperatorIssue(t *testing.T) {
 					AccountServerURL: "http://localhost:9090",
 				},
 			},
+			Version: 2,
 			Status: IssueOperatorStatus{
 				Operator: IssueStatus{
 					Nkey: true,
@@ -217,8 +219,8 @@ func TestCRUDOperatorIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		//////////////////////////
 		// Then recreate the key
@@ -384,8 +386,8 @@ func TestCRUDOperatorIssue(t *testing.T) {
 			Path:      "nkey/operator/op1",
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// read the jwt
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -393,8 +395,8 @@ func TestCRUDOperatorIssue(t *testing.T) {
 			Path:      "jwt/operator/op1",
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// read a signing key
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -402,8 +404,8 @@ func TestCRUDOperatorIssue(t *testing.T) {
 			Path:      "nkey/operator/op1/signing/key2",
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 	})
 
@@ -551,13 +553,101 @@ func TestCRUDOperatorIssue(t *testing.T) {
 		assert.False(t, resp.IsError())
 		assert.Equal(t, []string{DefaultSysAccountName}, resp.Data["keys"])
 
-		// delete the issue
+		// delete the issue together with the system account
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
 			Path:      path,
 			Storage:   reqStorage,
 		})
 		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.False(t, resp.IsError())
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "nkey/operator/opsys/account",
+			Storage:   reqStorage,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.IsError())
+		assert.Equal(t, nil, resp.Data["keys"])
+	})
+}
+
+func TestOperatorIssueWriteResult(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"syncAccountServer": true,
+			"claims": map[string]interface{}{
+				"operator": map[string]interface{}{
+					"signingKeys":      []interface{}{"opsk1"},
+					"accountServerURL": "nats://localhost:4222",
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+
+	// the write returns the issue, its keys and the jwt
+	nkey, err := readOperatorNkey(context.Background(), reqStorage, NkeyParameters{Operator: "op1"})
+	assert.NoError(t, err)
+	nkeyData, err := toNkeyData(nkey)
+	assert.NoError(t, err)
+	signingNkey, err := readOperatorSigningNkey(context.Background(), reqStorage, NkeyParameters{Operator: "op1", Signing: "opsk1"})
+	assert.NoError(t, err)
+	signingNkeyData, err := toNkeyData(signingNkey)
+	assert.NoError(t, err)
+	opJWT, err := readOperatorJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "op1", resp.Data["operator"])
+	assert.Equal(t, nkeyData.PublicKey, resp.Data["publicKey"])
+	assert.Equal(t, map[string]interface{}{"opsk1": signingNkeyData.PublicKey}, resp.Data["signingKeys"])
+	assert.Equal(t, opJWT.JWT, resp.Data["jwt"])
+	assert.NotNil(t, resp.Data["status"])
+	assert.NotContains(t, resp.Data, "seed")
+
+	// accounts can't be pushed without the system account push user,
+	// the reason is reported by the next write of the operator
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1/account/ac1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+	status := IssueAccountStatus{}
+	stm.MapToStruct(resp.Data["status"].(map[string]interface{}), &status)
+	assert.False(t, status.AccountServer.Synced)
+	assert.Equal(t, "system account user template does not exist", status.AccountServer.Error)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"syncAccountServer": true,
+			"claims": map[string]interface{}{
+				"operator": map[string]interface{}{
+					"accountServerURL": "nats://localhost:4222",
+				},
+			},
+		},
 	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+	assert.NotContains(t, resp.Data, "signingKeys")
+	assert.Equal(t, map[string]interface{}{
+		"ac1": map[string]interface{}{
+			"synced":   false,
+			"lastSync": float64(0),
+			"error":    "system account user template does not exist",
+		},
+	}, resp.Data["accountServer"])
 }
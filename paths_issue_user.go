@@ -8,44 +8,53 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/rs/zerolog/log"
 
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/user/v1alpha1"
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
 )
 
 type IssueUserStorage struct {
-	Operator       string              `json:"operator"`
-	Account        string              `json:"account"`
-	User           string              `json:"user"`
-	UseSigningKey  string              `json:"useSigningKey"`
-	ClaimsTemplate v1alpha1.UserClaims `json:"claimsTemplate"`
-	ExpirationS    int64               `json:"expirationS,omitempty"`  
-	Status         IssueUserStatus     `json:"status"`
+	Operator        string              `json:"operator"`
+	Account         string              `json:"account"`
+	User            string              `json:"user"`
+	UseSigningKey   string              `json:"useSigningKey"`
+	ClaimsTemplate  v1alpha1.UserClaims `json:"claimsTemplate"`
+	ExpirationS     int64               `json:"expirationS,omitempty"`
+	RotationPeriodS int64               `json:"rotationPeriod,omitempty"`
+	Version         int64               `json:"version"`
+	Status          IssueUserStatus     `json:"status"`
 }
 
 // IssueUserParameters is the user facing interface for configuring a user issue.
 // Using pascal case on purpose.
 // +k8s:deepcopy-gen=true
 type IssueUserParameters struct {
-	Operator       string              `json:"operator"`
-	Account        string              `json:"account"`
-	User           string              `json:"user"`
-	UseSigningKey  string              `json:"useSigningKey,omitempty"`
-	ClaimsTemplate v1alpha1.UserClaims `json:"claimsTemplate,omitempty"`
-	ExpirationS    int64               `json:"expirationS,omitempty"`
+	Operator        string              `json:"operator"`
+	Account         string              `json:"account"`
+	User            string              `json:"user"`
+	UseSigningKey   string              `json:"useSigningKey,omitempty"`
+	ClaimsTemplate  v1alpha1.UserClaims `json:"claimsTemplate,omitempty"`
+	ExpirationS     int64               `json:"expirationS,omitempty"`
+	RotationPeriodS int64               `json:"rotationPeriod,omitempty"`
+	CAS             *int64              `json:"cas,omitempty"`
 }
 
 type IssueUserData struct {
-	Operator       string              `json:"operator"`
-	Account        string              `json:"account"`
-	User           string              `json:"user"`
-	UseSigningKey  string              `json:"useSigningKey"`
-	ClaimsTemplate v1alpha1.UserClaims `json:"claimsTemplate"`
-	ExpirationS    int64               `json:"expirationS"`
-	Status         IssueUserStatus     `json:"status"`
+	Operator        string              `json:"operator"`
+	Account         string              `json:"account"`
+	User            string              `json:"user"`
+	UseSigningKey   string              `json:"useSigningKey"`
+	ClaimsTemplate  v1alpha1.UserClaims `json:"claimsTemplate"`
+	ExpirationS     int64               `json:"expirationS"`
+	RotationPeriodS int64               `json:"rotationPeriod"`
+	Version         int64               `json:"version"`
+	Status          IssueUserStatus     `json:"status"`
 }
 
 type IssueUserStatus struct {
-	User IssueStatus `json:"user"`
+	User         IssueStatus       `json:"user"`
+	NkeyRotation *UserNkeyRotation `json:"nkeyRotation,omitempty"`
 }
 
 func pathUserIssue(b *NatsBackend) []*framework.Path {
@@ -83,6 +92,12 @@ func pathUserIssue(b *NatsBackend) []*framework.Path {
 					Description: "JWT expiration time in seconds from now",
 					Required:    false,
 				},
+				"rotationPeriod": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Time after which the user nkey is replaced periodically, 0 disables the rotation",
+					Required:    false,
+				},
+				"cas": casField,
 			},
 			Operations: map[logical.Operation]framework.OperationHandler{
 				logical.CreateOperation: &framework.PathOperation{
@@ -91,6 +106,9 @@ func pathUserIssue(b *NatsBackend) []*framework.Path {
 				logical.UpdateOperation: &framework.PathOperation{
 					Callback: b.pathAddUserIssue,
 				},
+				logical.PatchOperation: &framework.PathOperation{
+					Callback: b.pathPatchUserIssue,
+				},
 				logical.ReadOperation: &framework.PathOperation{
 					Callback: b.pathReadUserIssue,
 				},
@@ -126,57 +144,160 @@ func pathUserIssue(b *NatsBackend) []*framework.Path {
 	}
 }
 
+// renameDeprecatedClaimsTemplateKeys moves claimsTemplate.nats, the key
+// documented before the claims template was decoded strictly, to
+// claimsTemplate.user and returns a warning for it
+func renameDeprecatedClaimsTemplateKeys(raw map[string]interface{}) ([]string, error) {
+	template, ok := raw["claimsTemplate"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	nats, ok := template["nats"]
+	if !ok {
+		return nil, nil
+	}
+	if _, ok := template["user"]; ok {
+		return nil, errInvalid("%s: claimsTemplate.nats: deprecated alias of claimsTemplate.user, both are set", validate.InvalidKeysError)
+	}
+	delete(template, "nats")
+	template["user"] = nats
+	return []string{"claimsTemplate.nats is deprecated, use claimsTemplate.user"}, nil
+}
+
 func (b *NatsBackend) pathAddUserIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-    err := data.Validate()
-    if err != nil {
-        return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
-    }
-
-    jsonString, err := json.Marshal(data.Raw)
-    if err != nil {
-        return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
-    }
-    
-    params := IssueUserParameters{}
-    err = json.Unmarshal(jsonString, &params) // Handle the error!
-    if err != nil {
-        log.Error().Err(err).Msg("Failed to unmarshal parameters")
-        return logical.ErrorResponse("Failed to parse parameters"), logical.ErrInvalidRequest
-    }
-
-    // Add debug logging
-    log.Debug().
-        Interface("claimsTemplate", params.ClaimsTemplate).
-        Int64("expirationS", params.ExpirationS).
-        Msg("Parsed parameters")
-
-    err = addUserIssue(ctx, req.Storage, params)
-    if err != nil {
-        return logical.ErrorResponse(AddingIssueFailedError), nil
-    }
-    return nil, nil
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	warnings, err := renameDeprecatedClaimsTemplateKeys(data.Raw)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	normalizeDurationFields(data, "rotationPeriod")
+
+	params := IssueUserParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to unmarshal parameters")
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	// Add debug logging
+	log.Debug().
+		Interface("claimsTemplate", params.ClaimsTemplate).
+		Int64("expirationS", params.ExpirationS).
+		Msg("Parsed parameters")
+
+	defer b.lockUserIssue(params.Operator, params.Account, params.User)()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+		User:      params.User,
+	}, func() error {
+		return addUserIssue(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueUserResult(ctx, req.Storage, params.Operator, params.Account, params.User)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathPatchUserIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	warnings, err := renameDeprecatedClaimsTemplateKeys(data.Raw)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	normalizeDurationFields(data, "rotationPeriod")
+
+	input := IssueUserParameters{}
+	err = validate.StrictDecode(data.Raw, &input)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockUserIssue(input.Operator, input.Account, input.User)()
+
+	issue, err := readUserIssue(ctx, req.Storage, input)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	if issue == nil {
+		return errorResponse(IssueNotFoundError, nil)
+	}
+
+	params := IssueUserParameters{}
+	err = patchIssue(data, &IssueUserParameters{
+		Operator:        issue.Operator,
+		Account:         issue.Account,
+		User:            issue.User,
+		UseSigningKey:   issue.UseSigningKey,
+		ClaimsTemplate:  issue.ClaimsTemplate,
+		ExpirationS:     issue.ExpirationS,
+		RotationPeriodS: issue.RotationPeriodS,
+	}, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+	params.CAS = input.CAS
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+		User:      params.User,
+	}, func() error {
+		return addUserIssue(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+
+	resp, err := createResponseIssueUserResult(ctx, req.Storage, params.Operator, params.Account, params.User)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
 }
 
 func (b *NatsBackend) pathReadUserIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueUserParameters{}
 	json.Unmarshal(jsonString, &params)
 
 	issue, err := readUserIssue(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingIssueFailedError), nil
+		return errorResponse(ReadingIssueFailedError, err)
 	}
 
 	if issue == nil {
-		return logical.ErrorResponse(IssueNotFoundError), nil
+		return errorResponse(IssueNotFoundError, nil)
 	}
 
 	return createResponseIssueUserData(issue)
@@ -185,19 +306,19 @@ func (b *NatsBackend) pathReadUserIssue(ctx context.Context, req *logical.Reques
 func (b *NatsBackend) pathListUserIssues(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	jsonString, err := json.Marshal(data.Raw)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 	params := IssueUserParameters{}
 	json.Unmarshal(jsonString, &params)
 
 	entries, err := listUserIssues(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListIssuesFailedError), nil
+		return errorResponse(ListIssuesFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -206,19 +327,28 @@ func (b *NatsBackend) pathListUserIssues(ctx context.Context, req *logical.Reque
 func (b *NatsBackend) pathDeleteUserIssue(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params IssueUserParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockUserIssue(params.Operator, params.Account, params.User)()
+
 	// delete issue and all related nkeys (no more JWT deletion)
-	err = deleteUserIssue(ctx, req.Storage, params)
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationDelete,
+		Operator:  params.Operator,
+		Account:   params.Account,
+		User:      params.User,
+	}, func() error {
+		return deleteUserIssue(ctx, req.Storage, params)
+	})
 	if err != nil {
-		return logical.ErrorResponse(DeleteIssueFailedError), nil
+		return errorResponse(DeleteIssueFailedError, err)
 	}
 	return nil, nil
 }
@@ -307,15 +437,27 @@ func deleteUserIssue(ctx context.Context, storage logical.Storage, params IssueU
 		if err != nil {
 			return err
 		}
+		// a replaced nkey still in its overlap window is revoked as well
+		if rotation := issue.Status.NkeyRotation; rotation != nil && rotation.PreviousPublicKey != "" {
+			err = addPublicKeyToRevocationList(ctx, storage, account, rotation.PreviousPublicKey)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
+	return removeUserIssue(ctx, storage, issue)
+}
+
+// removeUserIssue deletes the user issue and its nkey without revoking the user
+func removeUserIssue(ctx context.Context, storage logical.Storage, issue *IssueUserStorage) error {
 	// delete user nkey
 	nkey := NkeyParameters{
 		Operator: issue.Operator,
 		Account:  issue.Account,
 		User:     issue.User,
 	}
-	err = deleteUserNkey(ctx, storage, nkey)
+	err := deleteUserNkey(ctx, storage, nkey)
 	if err != nil {
 		return err
 	}
@@ -345,14 +487,28 @@ func storeUserIssue(ctx context.Context, storage logical.Storage, params IssueUs
 	if issue == nil {
 		issue = &IssueUserStorage{}
 	}
+	err = checkCAS(params.CAS, issue.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.RotationPeriodS < 0 {
+		return nil, errInvalid("rotationPeriod must not be negative")
+	}
+	err = schema.Validate(schema.User, params.ClaimsTemplate, schema.IgnoreStrings(hasTemplateVariables))
+	if err != nil {
+		return nil, errInvalid("%w", err)
+	}
 
 	issue.ClaimsTemplate = params.ClaimsTemplate
 	issue.ExpirationS = params.ExpirationS
+	issue.RotationPeriodS = params.RotationPeriodS
 	issue.Operator = params.Operator
 	issue.Account = params.Account
 	issue.User = params.User
 	issue.UseSigningKey = params.UseSigningKey
-	
+	issue.Version++
+
 	err = storeInStorage(ctx, storage, path, issue)
 	if err != nil {
 		return nil, err
@@ -386,15 +542,48 @@ func getUserIssuePath(operator string, account string, user string) string {
 	return "issue/operator/" + operator + "/account/" + account + "/user/" + user
 }
 
+// createResponseIssueUserResult returns the stored user issue with the public
+// key computed for it. User JWTs are generated when credentials are read.
+func createResponseIssueUserResult(ctx context.Context, storage logical.Storage, operator string, account string, user string) (*logical.Response, error) {
+	issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+		Operator: operator,
+		Account:  account,
+		User:     user,
+	})
+	if err != nil {
+		return nil, err
+	} else if issue == nil {
+		return nil, errNotFound("user issue does not exist: %s", user)
+	}
+
+	resp, err := createResponseIssueUserData(issue)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := getIssueResult(ctx, storage, getUserNkeyPath(operator, account, user), nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = addIssueResult(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
 func createResponseIssueUserData(issue *IssueUserStorage) (*logical.Response, error) {
 	data := &IssueUserData{
-		Operator:       issue.Operator,
-		Account:        issue.Account,
-		User:           issue.User,
-		UseSigningKey:  issue.UseSigningKey,
-		ClaimsTemplate: issue.ClaimsTemplate,
-		ExpirationS:   issue.ExpirationS,
-		Status:         issue.Status,
+		Operator:        issue.Operator,
+		Account:         issue.Account,
+		User:            issue.User,
+		UseSigningKey:   issue.UseSigningKey,
+		ClaimsTemplate:  issue.ClaimsTemplate,
+		ExpirationS:     issue.ExpirationS,
+		RotationPeriodS: issue.RotationPeriodS,
+		Version:         issue.Version,
+		Status:          issue.Status,
 	}
 
 	rval := map[string]interface{}{}
@@ -421,4 +610,4 @@ func updateUserStatus(ctx context.Context, storage logical.Storage, issue *Issue
 	} else {
 		issue.Status.User.Nkey = false
 	}
-}
\ No newline at end of file
+}
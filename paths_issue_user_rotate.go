@@ -0,0 +1,206 @@
+package natsbackend
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/nkeys"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultUserNkeyRotationOverlap is how long the replaced nkey of a user
+// without expirationS stays valid
+const DefaultUserNkeyRotationOverlap = time.Hour
+
+// UserNkeyRotation is the state of the periodic rotation of a user nkey
+type UserNkeyRotation struct {
+	// RotatedAt is when the current nkey was issued, or the rotation was enabled
+	RotatedAt int64 `json:"rotatedAt,omitempty"`
+	// PreviousPublicKey is the replaced nkey, it is revoked at RevokeAt
+	PreviousPublicKey string `json:"previousPublicKey,omitempty"`
+	RevokeAt          int64  `json:"revokeAt,omitempty"`
+	// Revoked are the earlier nkeys revoked in the account until all
+	// credentials issued for them expired
+	Revoked []RevokedUserNkey `json:"revoked,omitempty"`
+}
+
+// RevokedUserNkey is a replaced user nkey revoked in the account
+type RevokedUserNkey struct {
+	PublicKey string `json:"publicKey"`
+	// PruneAt is when the revocation is removed from the account again
+	PruneAt int64 `json:"pruneAt"`
+}
+
+// rotateUserNkeyIfDue replaces the user nkey when its rotationPeriod elapsed
+// and revokes the replaced one in the account once the overlap window passed.
+// Credentials read before the rotation keep working during the overlap.
+func rotateUserNkeyIfDue(ctx context.Context, storage logical.Storage, issue *IssueUserStorage) error {
+	rotation := issue.Status.NkeyRotation
+	now := time.Now().Unix()
+
+	if rotation != nil && rotation.PreviousPublicKey != "" && now >= rotation.RevokeAt {
+		err := revokePreviousUserNkey(ctx, storage, issue)
+		if err != nil {
+			return err
+		}
+	}
+	if rotation != nil {
+		err := pruneRevokedUserNkeys(ctx, storage, issue, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if issue.RotationPeriodS <= 0 {
+		if rotation != nil && rotation.PreviousPublicKey == "" && rotation.RotatedAt != 0 {
+			// the clock starts again when the rotation is enabled
+			rotation.RotatedAt = 0
+			if len(rotation.Revoked) == 0 {
+				issue.Status.NkeyRotation = nil
+			}
+			_, err := storeUserIssueUpdate(ctx, storage, issue)
+			return err
+		}
+		return nil
+	}
+	if rotation == nil || rotation.RotatedAt == 0 {
+		if rotation == nil {
+			issue.Status.NkeyRotation = &UserNkeyRotation{}
+		}
+		issue.Status.NkeyRotation.RotatedAt = now
+		_, err := storeUserIssueUpdate(ctx, storage, issue)
+		return err
+	}
+	if rotation.PreviousPublicKey != "" || now < rotation.RotatedAt+issue.RotationPeriodS {
+		return nil
+	}
+	return rotateUserNkey(ctx, storage, issue)
+}
+
+func rotateUserNkey(ctx context.Context, storage logical.Storage, issue *IssueUserStorage) error {
+	nkey, err := readUserNkey(ctx, storage, NkeyParameters{
+		Operator: issue.Operator,
+		Account:  issue.Account,
+		User:     issue.User,
+	})
+	if err != nil {
+		return err
+	}
+	if nkey == nil {
+		return errNotFound("user nkey does not exist")
+	}
+	previous, err := toNkeyData(nkey)
+	if err != nil {
+		return err
+	}
+
+	seed, err := createSeed(nkeys.PrefixByteUser)
+	if err != nil {
+		return err
+	}
+	err = storeInStorage(ctx, storage, getUserNkeyPath(issue.Operator, issue.Account, issue.User), &NKeyStorage{Seed: seed})
+	if err != nil {
+		return err
+	}
+
+	overlap := int64(DefaultUserNkeyRotationOverlap / time.Second)
+	if issue.ExpirationS > 0 {
+		overlap = issue.ExpirationS
+	}
+	now := time.Now().Unix()
+	rotation := issue.Status.NkeyRotation
+	rotation.RotatedAt = now
+	rotation.PreviousPublicKey = previous.PublicKey
+	rotation.RevokeAt = now + overlap
+
+	log.Info().
+		Str("operator", issue.Operator).Str("account", issue.Account).Str("user", issue.User).
+		Int64("revokeAt", rotation.RevokeAt).
+		Msg("user nkey rotated")
+
+	_, err = storeUserIssueUpdate(ctx, storage, issue)
+	return err
+}
+
+// revokePreviousUserNkey adds the replaced nkey of the user to the revocation
+// list of the account and pushes the re-signed account JWT. Credentials of
+// users with expirationS expire, so their revocation is pruned afterwards.
+func revokePreviousUserNkey(ctx context.Context, storage logical.Storage, issue *IssueUserStorage) error {
+	rotation := issue.Status.NkeyRotation
+	account, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Account,
+	})
+	if err != nil {
+		return err
+	}
+	if account != nil {
+		err = addPublicKeyToRevocationList(ctx, storage, account, rotation.PreviousPublicKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().
+		Str("operator", issue.Operator).Str("account", issue.Account).Str("user", issue.User).
+		Msg("previous user nkey revoked")
+
+	if issue.ExpirationS > 0 {
+		rotation.Revoked = append(rotation.Revoked, RevokedUserNkey{
+			PublicKey: rotation.PreviousPublicKey,
+			PruneAt:   time.Now().Unix() + issue.ExpirationS,
+		})
+	}
+	rotation.PreviousPublicKey = ""
+	rotation.RevokeAt = 0
+	_, err = storeUserIssueUpdate(ctx, storage, issue)
+	return err
+}
+
+// pruneRevokedUserNkeys removes the revocations of replaced nkeys from the
+// account once all credentials issued for them expired
+func pruneRevokedUserNkeys(ctx context.Context, storage logical.Storage, issue *IssueUserStorage, now int64) error {
+	rotation := issue.Status.NkeyRotation
+	revoked := []RevokedUserNkey{}
+	pruned := []string{}
+	for _, r := range rotation.Revoked {
+		if now >= r.PruneAt {
+			pruned = append(pruned, r.PublicKey)
+		} else {
+			revoked = append(revoked, r)
+		}
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	account, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+		Operator: issue.Operator,
+		Account:  issue.Account,
+	})
+	if err != nil {
+		return err
+	}
+	if account != nil {
+		for _, publicKey := range pruned {
+			delete(account.Claims.Revocations, publicKey)
+		}
+		err = refreshAccount(ctx, storage, account)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().
+		Str("operator", issue.Operator).Str("account", issue.Account).Str("user", issue.User).
+		Strs("publicKeys", pruned).
+		Msg("revocations of expired user nkeys pruned")
+
+	if len(revoked) == 0 {
+		revoked = nil
+	}
+	rotation.Revoked = revoked
+	_, err = storeUserIssueUpdate(ctx, storage, issue)
+	return err
+}
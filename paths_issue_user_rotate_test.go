@@ -0,0 +1,166 @@
+package natsbackend
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserNkeyRotation(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{}},
+		{"issue/operator/op1/account/ac1", map[string]interface{}{}},
+		{"issue/operator/op1/account/ac1/user/us1", map[string]interface{}{"rotationPeriod": 3600, "expirationS": 60}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	userPublicKey := func() string {
+		nkey, err := readUserNkey(context.Background(), reqStorage, NkeyParameters{
+			Operator: "op1",
+			Account:  "ac1",
+			User:     "us1",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, nkey)
+		data, err := toNkeyData(nkey)
+		require.NoError(t, err)
+		return data.PublicKey
+	}
+	readUser := func() *IssueUserStorage {
+		issue, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{
+			Operator: "op1",
+			Account:  "ac1",
+			User:     "us1",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, issue)
+		return issue
+	}
+	accountRevocations := func() jwt.RevocationList {
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		return claims.Revocations
+	}
+	periodic := func() {
+		err := b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage})
+		require.NoError(t, err)
+	}
+	oldPublicKey := userPublicKey()
+
+	t.Run("rotation period starts", func(t *testing.T) {
+		periodic()
+		issue := readUser()
+		require.NotNil(t, issue.Status.NkeyRotation)
+		assert.Greater(t, issue.Status.NkeyRotation.RotatedAt, int64(0))
+		assert.Equal(t, oldPublicKey, userPublicKey())
+	})
+
+	t.Run("elapsed period replaces the nkey", func(t *testing.T) {
+		issue := readUser()
+		issue.Status.NkeyRotation.RotatedAt = time.Now().Add(-2 * time.Hour).Unix()
+		_, err := storeUserIssueUpdate(context.Background(), reqStorage, issue)
+		require.NoError(t, err)
+		periodic()
+
+		newPublicKey := userPublicKey()
+		assert.NotEqual(t, oldPublicKey, newPublicKey)
+		issue = readUser()
+		assert.Equal(t, oldPublicKey, issue.Status.NkeyRotation.PreviousPublicKey)
+		assert.InDelta(t, time.Now().Unix()+60, issue.Status.NkeyRotation.RevokeAt, 5)
+		// the old nkey keeps working during the overlap window
+		assert.NotContains(t, accountRevocations(), oldPublicKey)
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "issue/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		rotation := resp.Data["status"].(map[string]interface{})["nkeyRotation"].(map[string]interface{})
+		assert.Equal(t, oldPublicKey, rotation["previousPublicKey"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "creds/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		userJWT, err := jwt.ParseDecoratedJWT([]byte(resp.Data["creds"].(string)))
+		require.NoError(t, err)
+		claims, err := jwt.DecodeUserClaims(userJWT)
+		require.NoError(t, err)
+		assert.Equal(t, newPublicKey, claims.Subject)
+	})
+
+	t.Run("old nkey is revoked after the overlap window", func(t *testing.T) {
+		issue := readUser()
+		issue.Status.NkeyRotation.RevokeAt = time.Now().Add(-time.Minute).Unix()
+		_, err := storeUserIssueUpdate(context.Background(), reqStorage, issue)
+		require.NoError(t, err)
+		periodic()
+
+		assert.Contains(t, accountRevocations(), oldPublicKey)
+		issue = readUser()
+		assert.Empty(t, issue.Status.NkeyRotation.PreviousPublicKey)
+		require.Len(t, issue.Status.NkeyRotation.Revoked, 1)
+		assert.Equal(t, oldPublicKey, issue.Status.NkeyRotation.Revoked[0].PublicKey)
+		assert.InDelta(t, time.Now().Unix()+60, issue.Status.NkeyRotation.Revoked[0].PruneAt, 5)
+	})
+
+	t.Run("revocation is pruned once the old credentials expired", func(t *testing.T) {
+		issue := readUser()
+		issue.Status.NkeyRotation.Revoked[0].PruneAt = time.Now().Add(-time.Minute).Unix()
+		_, err := storeUserIssueUpdate(context.Background(), reqStorage, issue)
+		require.NoError(t, err)
+		periodic()
+
+		assert.NotContains(t, accountRevocations(), oldPublicKey)
+		issue = readUser()
+		assert.Empty(t, issue.Status.NkeyRotation.Revoked)
+	})
+
+	t.Run("negative rotation period is rejected", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"rotationPeriod": -1},
+		})
+		// durations are validated by the framework
+		assert.NoError(t, err)
+		assert.True(t, isErrorResponse(resp))
+		assert.Contains(t, errorMessage(resp), "cannot provide negative value")
+	})
+
+	t.Run("rotation period accepts durations", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/account/ac1/user/us1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"rotationPeriod": "24h"},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, int64(24*3600), readUser().RotationPeriodS)
+	})
+}
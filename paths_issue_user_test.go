@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"testing"
@@ -12,6 +13,7 @@ import (
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/nats-io/jwt/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gonum.org/v1/gonum/stat/combin"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/common"
@@ -53,8 +55,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -119,6 +121,7 @@ func TestCRUDUserIssue(t *testing.T) {
 			User:           "us1",
 			UseSigningKey:  "",
 			ClaimsTemplate: userv1.UserClaims{},
+			Version:        1,
 			Status: IssueUserStatus{
 				User: IssueStatus{
 					Nkey: true,
@@ -196,6 +199,7 @@ func TestCRUDUserIssue(t *testing.T) {
 					},
 				},
 			},
+			Version: 2,
 			Status: IssueUserStatus{
 				User: IssueStatus{
 					Nkey: true,
@@ -269,8 +273,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		//////////////////////////
 		// Then recreate the key
@@ -390,7 +394,7 @@ func TestCRUDUserIssue(t *testing.T) {
 			assert.Contains(t, err.Error(), "unsupported path")
 		} else {
 			// If no error, then response should indicate error
-			assert.True(t, resp.IsError())
+			assert.True(t, isErrorResponse(resp))
 		}
 
 		//////////////////////////
@@ -433,8 +437,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      "nkey/operator/op1/account/ac1/user/us1",
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		//////////////////////////
 		// read the jwt (should fail)
@@ -448,7 +452,7 @@ func TestCRUDUserIssue(t *testing.T) {
 		if err != nil {
 			assert.Contains(t, err.Error(), "unsupported path")
 		} else {
-			assert.True(t, resp.IsError())
+			assert.True(t, isErrorResponse(resp))
 		}
 
 		//////////////////////////
@@ -459,8 +463,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      "creds/operator/op1/account/ac1/user/us1",
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 	})
 
 	t.Run("Test sys account with default-push user", func(t *testing.T) {
@@ -547,7 +551,7 @@ func TestCRUDUserIssue(t *testing.T) {
 		if err != nil {
 			assert.Contains(t, err.Error(), "unsupported path")
 		} else {
-			assert.True(t, resp.IsError())
+			assert.True(t, isErrorResponse(resp))
 		}
 
 		//////////////////////////
@@ -584,8 +588,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      nkeyUserPath,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		//////////////////////////
 		// read the jwt
@@ -599,7 +603,7 @@ func TestCRUDUserIssue(t *testing.T) {
 		if err != nil {
 			assert.Contains(t, err.Error(), "unsupported path")
 		} else {
-			assert.True(t, resp.IsError())
+			assert.True(t, isErrorResponse(resp))
 		}
 
 		//////////////////////////
@@ -610,8 +614,8 @@ func TestCRUDUserIssue(t *testing.T) {
 			Path:      credsUserPath,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 	})
 
 }
@@ -756,7 +760,7 @@ func listPath(b *NatsBackend, reqStorage logical.Storage, path string, expected
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error listing nkeys: %s", resp.Error().Error())
+		return fmt.Errorf("error listing nkeys: %s", errorMessage(resp))
 	}
 
 	if !reflect.DeepEqual(resp.Data, expected) {
@@ -776,7 +780,7 @@ func checkOperatorJWTForSysAccount(b *NatsBackend, reqStorage logical.Storage, o
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error reading operator JWT: %s", resp.Error().Error())
+		return fmt.Errorf("error reading operator JWT: %s", errorMessage(resp))
 	}
 	var current JWTData
 	stm.MapToStruct(resp.Data, &current)
@@ -795,7 +799,7 @@ func checkOperatorJWTForSysAccount(b *NatsBackend, reqStorage logical.Storage, o
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error reading sys account JWT: %s", resp.Error().Error())
+		return fmt.Errorf("error reading sys account JWT: %s", errorMessage(resp))
 	}
 	var sysAccount JWTData
 	stm.MapToStruct(resp.Data, &sysAccount)
@@ -820,7 +824,7 @@ func checkAccountJWTForOperator(b *NatsBackend, reqStorage logical.Storage, oper
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error reading operator JWT: %s", resp.Error().Error())
+		return fmt.Errorf("error reading operator JWT: %s", errorMessage(resp))
 	}
 	var operator JWTData
 	stm.MapToStruct(resp.Data, &operator)
@@ -838,7 +842,7 @@ func checkAccountJWTForOperator(b *NatsBackend, reqStorage logical.Storage, oper
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error reading account JWT: %s", resp.Error().Error())
+		return fmt.Errorf("error reading account JWT: %s", errorMessage(resp))
 	}
 	var account JWTData
 	stm.MapToStruct(resp.Data, &account)
@@ -864,7 +868,7 @@ func checkUserCredsGeneration(b *NatsBackend, reqStorage logical.Storage, operat
 		return err
 	}
 	if resp.IsError() {
-		return fmt.Errorf("error generating user creds: %s", resp.Error().Error())
+		return fmt.Errorf("error generating user creds: %s", errorMessage(resp))
 	}
 
 	// Verify that creds response contains expected fields
@@ -897,3 +901,138 @@ func checkUserCredsGeneration(b *NatsBackend, reqStorage logical.Storage, operat
 
 	return nil
 }
+
+func TestUserIssueStrictDecoding(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			data:     map[string]interface{}{"expirationSec": 3600},
+			expected: "expirationSec: unknown field",
+		},
+		{
+			data: map[string]interface{}{
+				"claimsTemplate": map[string]interface{}{
+					"user": map[string]interface{}{
+						"pubb": map[string]interface{}{},
+					},
+				},
+			},
+			expected: "claimsTemplate.user.pubb: unknown field",
+		},
+		{
+			data: map[string]interface{}{
+				"claimsTemplate": map[string]interface{}{
+					"user": map[string]interface{}{
+						"pub": map[string]interface{}{
+							"allow": []interface{}{"foo", "bar", 1},
+						},
+					},
+				},
+			},
+			expected: "claimsTemplate.user.pub.allow[2]: expected string, got number",
+		},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/ac1/user/u1",
+			Storage:   reqStorage,
+			Data:      tc.data,
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+		assert.Contains(t, errorMessage(resp), tc.expected)
+	}
+
+	// nothing is stored
+	issue, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{
+		Operator: "op1",
+		Account:  "ac1",
+		User:     "u1",
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, issue)
+}
+
+func TestUserIssueDeprecatedNatsKey(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	path := "issue/operator/op1/account/ac1/user/u1"
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      path,
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"nats": map[string]interface{}{
+					"pub": map[string]interface{}{"allow": []string{"app.>"}},
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.IsError())
+	assert.Equal(t, []string{"claimsTemplate.nats is deprecated, use claimsTemplate.user"}, resp.Warnings)
+
+	issue, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{
+		Operator: "op1",
+		Account:  "ac1",
+		User:     "u1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.>"}, issue.ClaimsTemplate.Pub.Allow)
+
+	// both keys are ambiguous
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      path,
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"nats": map[string]interface{}{},
+				"user": map[string]interface{}{},
+			},
+		},
+	})
+	assertErrorStatus(t, err, http.StatusBadRequest)
+	assert.Contains(t, errorMessage(resp), "claimsTemplate.nats")
+}
+
+func TestUserDeleteRaisesAccountVersion(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, path := range []string{
+		"issue/operator/op1",
+		"issue/operator/op1/account/ac1",
+		"issue/operator/op1/account/ac1/user/us1",
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	account, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+	require.NoError(t, err)
+	version := account.Version
+
+	// the revocation of the deleted user changes the account claims
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1/account/ac1/user/us1",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	account, err = readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+	require.NoError(t, err)
+	assert.Len(t, account.Claims.Revocations, 1)
+	assert.Greater(t, account.Version, version)
+}
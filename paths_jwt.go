@@ -2,11 +2,10 @@ package natsbackend
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"regexp"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/nats-io/jwt/v2"
@@ -34,6 +33,7 @@ func pathJWT(b *NatsBackend) []*framework.Path {
 	paths := []*framework.Path{}
 	paths = append(paths, pathOperatorJWT(b)...)
 	paths = append(paths, pathAccountJWT(b)...)
+	paths = append(paths, pathAdoptJWT(b)...)
 	return paths
 }
 
@@ -102,12 +102,42 @@ func listJWTs(ctx context.Context, storage logical.Storage, path string) ([]stri
 func validateJWT[T any, P interface{ *T }](token string) error {
 	claims, err := jwt.Decode(token)
 	if err != nil {
-		return fmt.Errorf("error decoding jwt: %s", err.Error())
+		return errInvalid("error decoding jwt: %w", err)
 	}
 	_, ok := claims.(P)
 	if !ok {
-		return errors.New("jwt token has wrong claim type")
+		return errInvalid("jwt token has wrong claim type")
 	}
 
 	return nil
 }
+
+// addJWTWarnings adds the non-blocking validation issues of the jwt
+// as warnings to the response. Blocking issues are rejected before signing.
+func addJWTWarnings(resp *logical.Response, token string) *logical.Response {
+	claims, err := jwt.Decode(token)
+	if err != nil {
+		return resp
+	}
+	warnings, _ := validate.Claims(claims)
+	if len(warnings) == 0 {
+		return resp
+	}
+	if resp == nil {
+		resp = &logical.Response{}
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp
+}
+
+// addStoredJWTWarnings adds the non-blocking validation issues
+// of the jwt stored at path as warnings to the response
+func addStoredJWTWarnings(ctx context.Context, storage logical.Storage, resp *logical.Response, path string) *logical.Response {
+	stored, err := readJWT(ctx, storage, path)
+	if err != nil || stored == nil {
+		return resp
+	}
+	return addJWTWarnings(resp, stored.JWT)
+}
@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -72,18 +71,20 @@ func pathAccountJWT(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddAccountJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	err = addAccountJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(AddingJWTFailedError), nil
+		return errorResponse(AddingJWTFailedError, err)
 	}
 	return nil, nil
 }
@@ -91,22 +92,22 @@ func (b *NatsBackend) pathAddAccountJWT(ctx context.Context, req *logical.Reques
 func (b *NatsBackend) pathReadAccountJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	jwt, err := readAccountJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingJWTFailedError), nil
+		return errorResponse(ReadingJWTFailedError, err)
 	}
 
 	if jwt == nil {
-		return logical.ErrorResponse(JwtNotFoundError), nil
+		return errorResponse(JwtNotFoundError, nil)
 	}
 
 	return createResponseJWTData(jwt)
@@ -115,18 +116,18 @@ func (b *NatsBackend) pathReadAccountJWT(ctx context.Context, req *logical.Reque
 func (b *NatsBackend) pathListAccountJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listAccountJWTs(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListJWTsFailedError), nil
+		return errorResponse(ListJWTsFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -135,19 +136,21 @@ func (b *NatsBackend) pathListAccountJWT(ctx context.Context, req *logical.Reque
 func (b *NatsBackend) pathDeleteAccountJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	// when a key is given, store it
 	err = deleteAccountJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteJWTFailedError), nil
+		return errorResponse(DeleteJWTFailedError, err)
 	}
 	return nil, nil
 }
@@ -168,7 +171,7 @@ func addAccountJWT(ctx context.Context, storage logical.Storage, params JWTParam
 		Msg("create/update account jwt")
 
 	if params.JWT == "" {
-		return fmt.Errorf("account JWT is required")
+		return errInvalid("account JWT is required")
 	} else {
 		err := validateJWT[jwt.AccountClaims](params.JWT)
 		if err != nil {
@@ -22,8 +22,8 @@ func TestCRUDAccountJWTs(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -86,8 +86,8 @@ func TestCRUDAccountJWTs(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// then recreate the key and read and delete it
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -180,8 +180,8 @@ func TestCRUDAccountJWTs(t *testing.T) {
 				"jwt": createOperatorJWT(),
 			},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.CreateOperation,
@@ -191,8 +191,8 @@ func TestCRUDAccountJWTs(t *testing.T) {
 				"jwt": "wrong jwt",
 			},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 	})
 
@@ -0,0 +1,488 @@
+package natsbackend
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/common"
+	operatorv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/operator/v1alpha1"
+	userv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/user/v1alpha1"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+// AdoptJWTParameters selects the JWT an issue is created from. Without a
+// JWT the stored operator or account JWT is adopted.
+type AdoptJWTParameters struct {
+	Operator string `json:"operator"`
+	Account  string `json:"account,omitempty"`
+	User     string `json:"user,omitempty"`
+	JWT      string `json:"jwt,omitempty"`
+	CAS      *int64 `json:"cas,omitempty"`
+}
+
+func pathAdoptJWT(b *NatsBackend) []*framework.Path {
+	operatorField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "operator identifier",
+		Required:    false,
+	}
+	accountField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "account identifier",
+		Required:    false,
+	}
+	return []*framework.Path{
+		{
+			Pattern: "jwt/operator/" + framework.GenericNameRegex("operator") + "/adopt$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"jwt": {
+					Type:        framework.TypeString,
+					Description: "Operator JWT to adopt (default: the stored operator JWT)",
+					Required:    false,
+				},
+				"cas": casField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAdoptOperatorJWT,
+				},
+			},
+			HelpSynopsis:    `Creates the operator issue from an operator JWT signed by the operator nkey.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "jwt/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "/adopt$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"account":  accountField,
+				"jwt": {
+					Type:        framework.TypeString,
+					Description: "Account JWT to adopt (default: the stored account JWT)",
+					Required:    false,
+				},
+				"cas": casField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAdoptAccountJWT,
+				},
+			},
+			HelpSynopsis:    `Creates the account issue from an account JWT signed by a key of the operator.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "jwt/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "/user/" + framework.GenericNameRegex("user") + "/adopt$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"account":  accountField,
+				"user": {
+					Type:        framework.TypeString,
+					Description: "user identifier",
+					Required:    false,
+				},
+				"jwt": {
+					Type:        framework.TypeString,
+					Description: "User JWT to adopt",
+					Required:    true,
+				},
+				"cas": casField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathAdoptUserJWT,
+				},
+			},
+			HelpSynopsis:    `Creates the user issue from a user JWT signed by a key of the account.`,
+			HelpDescription: ``,
+		},
+	}
+}
+
+func (b *NatsBackend) pathAdoptOperatorJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := AdoptJWTParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+	}, func() error {
+		return adoptOperatorJWT(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AdoptingJWTFailedError, err)
+	}
+
+	resp, err := createResponseIssueOperatorResult(ctx, req.Storage, params.Operator)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathAdoptAccountJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := AdoptJWTParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	unlock, err := b.lockAccountIssue(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	defer unlock()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+	}, func() error {
+		return adoptAccountJWT(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AdoptingJWTFailedError, err)
+	}
+
+	resp, err := createResponseIssueAccountResult(ctx, req.Storage, params.Operator, params.Account)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathAdoptUserJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	params := AdoptJWTParameters{}
+	err = validate.StrictDecode(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockUserIssue(params.Operator, params.Account, params.User)()
+
+	err = withIssueWAL(ctx, req.Storage, &issueWALEntry{
+		Operation: walOperationWrite,
+		Operator:  params.Operator,
+		Account:   params.Account,
+		User:      params.User,
+	}, func() error {
+		return adoptUserJWT(ctx, req.Storage, params)
+	})
+	if err != nil {
+		return errorResponse(AdoptingJWTFailedError, err)
+	}
+
+	resp, err := createResponseIssueUserResult(ctx, req.Storage, params.Operator, params.Account, params.User)
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	return resp, nil
+}
+
+// adoptOperatorJWT replaces the claims of the operator issue by the claims
+// of a JWT self-signed by the operator nkey of the mount. The settings of an
+// existing issue are kept.
+func adoptOperatorJWT(ctx context.Context, storage logical.Storage, params AdoptJWTParameters) error {
+	token := params.JWT
+	if token == "" {
+		stored, err := readOperatorJWT(ctx, storage, JWTParameters{Operator: params.Operator})
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			return errNotFound("operator jwt does not exist: %s", params.Operator)
+		}
+		token = stored.JWT
+	}
+	claims, err := jwt.DecodeOperatorClaims(token)
+	if err != nil {
+		return errInvalid("cannot decode operator jwt: %w", err)
+	}
+
+	publicKey, err := storedNkeyPublicKey(ctx, storage, getOperatorNkeyPath(params.Operator))
+	if err != nil {
+		return err
+	}
+	if publicKey == "" {
+		return errNotFound("operator nkey does not exist: %s", params.Operator)
+	}
+	if claims.Subject != publicKey || claims.Issuer != publicKey {
+		return errInvalid("operator jwt is not signed by the nkey of operator %s", params.Operator)
+	}
+	if claims.SystemAccount != "" {
+		sysKey, err := storedNkeyPublicKey(ctx, storage, getAccountNkeyPath(params.Operator, DefaultSysAccountName))
+		if err != nil {
+			return err
+		}
+		if claims.SystemAccount != sysKey {
+			return errInvalid("system account %s is not the %s account of operator %s", claims.SystemAccount, DefaultSysAccountName, params.Operator)
+		}
+	}
+	names, err := signingNkeyNames(ctx, storage, getOperatorSigningNkeyPath(params.Operator, ""), claims.SigningKeys)
+	if err != nil {
+		return err
+	}
+
+	issueParams := IssueOperatorParameters{
+		Operator: params.Operator,
+		Claims:   adoptedOperatorClaims(claims, names),
+		CAS:      params.CAS,
+	}
+	existing, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{Operator: params.Operator})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		issueParams.CreateSystemAccount = existing.CreateSystemAccount
+		issueParams.SyncAccountServer = existing.SyncAccountServer
+		issueParams.DeletionProtection = existing.DeletionProtection
+	}
+
+	log.Info().Str("operator", params.Operator).Msg("adopt operator jwt")
+	return addOperatorIssue(ctx, storage, issueParams)
+}
+
+// adoptAccountJWT replaces the claims of the account issue by the claims of
+// a JWT of the account nkey signed by the operator nkey or one of its
+// signing nkeys
+func adoptAccountJWT(ctx context.Context, storage logical.Storage, params AdoptJWTParameters) error {
+	token := params.JWT
+	if token == "" {
+		stored, err := readAccountJWT(ctx, storage, JWTParameters{Operator: params.Operator, Account: params.Account})
+		if err != nil {
+			return err
+		}
+		if stored == nil {
+			return errNotFound("account jwt does not exist: %s", params.Account)
+		}
+		token = stored.JWT
+	}
+	claims, err := jwt.DecodeAccountClaims(token)
+	if err != nil {
+		return errInvalid("cannot decode account jwt: %w", err)
+	}
+
+	publicKey, err := storedNkeyPublicKey(ctx, storage, getAccountNkeyPath(params.Operator, params.Account))
+	if err != nil {
+		return err
+	}
+	if publicKey == "" {
+		return errNotFound("account nkey does not exist: %s", params.Account)
+	}
+	if claims.Subject != publicKey {
+		return errInvalid("account jwt subject %s is not the nkey of account %s", claims.Subject, params.Account)
+	}
+	useSigningKey, err := adoptedIssuer(ctx, storage, claims.Issuer, getOperatorNkeyPath(params.Operator), getOperatorSigningNkeyPath(params.Operator, ""))
+	if err != nil {
+		return err
+	}
+	names, err := signingNkeyNames(ctx, storage, getAccountSigningNkeyPath(params.Operator, params.Account, ""), claims.SigningKeys.Keys())
+	if err != nil {
+		return err
+	}
+
+	issueParams := IssueAccountParameters{
+		Operator:      params.Operator,
+		Account:       params.Account,
+		UseSigningKey: useSigningKey,
+		Claims:        adoptedAccountClaims(claims, names),
+		CAS:           params.CAS,
+	}
+	existing, err := readAccountIssue(ctx, storage, IssueAccountParameters{Operator: params.Operator, Account: params.Account})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		issueParams.DeletionProtection = existing.DeletionProtection
+	}
+
+	log.Info().Str("operator", params.Operator).Str("account", params.Account).Msg("adopt account jwt")
+	return addAccountIssue(ctx, storage, issueParams)
+}
+
+// adoptUserJWT replaces the claims template of the user issue by the claims
+// of a JWT of the user nkey signed by the account nkey or one of its signing
+// nkeys. The expiration of the issue is the lifetime of the JWT.
+func adoptUserJWT(ctx context.Context, storage logical.Storage, params AdoptJWTParameters) error {
+	if params.JWT == "" {
+		return errInvalid("user jwt is required")
+	}
+	claims, err := jwt.DecodeUserClaims(params.JWT)
+	if err != nil {
+		return errInvalid("cannot decode user jwt: %w", err)
+	}
+
+	publicKey, err := storedNkeyPublicKey(ctx, storage, getUserNkeyPath(params.Operator, params.Account, params.User))
+	if err != nil {
+		return err
+	}
+	if publicKey == "" {
+		return errNotFound("user nkey does not exist: %s", params.User)
+	}
+	if claims.Subject != publicKey {
+		return errInvalid("user jwt subject %s is not the nkey of user %s", claims.Subject, params.User)
+	}
+	accountKey, err := storedNkeyPublicKey(ctx, storage, getAccountNkeyPath(params.Operator, params.Account))
+	if err != nil {
+		return err
+	}
+	if claims.IssuerAccount != "" && claims.IssuerAccount != accountKey {
+		return errInvalid("user jwt is issued for account %s, not %s", claims.IssuerAccount, params.Account)
+	}
+	useSigningKey, err := adoptedIssuer(ctx, storage, claims.Issuer, getAccountNkeyPath(params.Operator, params.Account), getAccountSigningNkeyPath(params.Operator, params.Account, ""))
+	if err != nil {
+		return err
+	}
+
+	issueParams := IssueUserParameters{
+		Operator:       params.Operator,
+		Account:        params.Account,
+		User:           params.User,
+		UseSigningKey:  useSigningKey,
+		ClaimsTemplate: adoptedUserClaims(claims),
+		CAS:            params.CAS,
+	}
+	if claims.Expires > claims.IssuedAt {
+		issueParams.ExpirationS = claims.Expires - claims.IssuedAt
+	}
+	existing, err := readUserIssue(ctx, storage, IssueUserParameters{Operator: params.Operator, Account: params.Account, User: params.User})
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		issueParams.RotationPeriodS = existing.RotationPeriodS
+	}
+
+	log.Info().Str("operator", params.Operator).Str("account", params.Account).Str("user", params.User).Msg("adopt user jwt")
+	return addUserIssue(ctx, storage, issueParams)
+}
+
+// adoptedIssuer returns the name of the signing nkey that issued a JWT, or
+// "" if it was issued by the identity nkey. Other issuers are rejected.
+func adoptedIssuer(ctx context.Context, storage logical.Storage, issuer string, nkeyPath string, signingPath string) (string, error) {
+	publicKey, err := storedNkeyPublicKey(ctx, storage, nkeyPath)
+	if err != nil {
+		return "", err
+	}
+	if publicKey == "" {
+		return "", errNotFound("nkey does not exist: %s", nkeyPath)
+	}
+	if issuer == publicKey {
+		return "", nil
+	}
+	names, err := signingNkeyNames(ctx, storage, signingPath, []string{issuer})
+	if err != nil {
+		return "", err
+	}
+	return names[issuer], nil
+}
+
+// storedNkeyPublicKey returns the public key of the nkey stored at path,
+// "" if there is none
+func storedNkeyPublicKey(ctx context.Context, storage logical.Storage, path string) (string, error) {
+	nkey, err := readNkey(ctx, storage, path)
+	if err != nil {
+		return "", err
+	}
+	if nkey == nil {
+		return "", nil
+	}
+	data, err := toNkeyData(nkey)
+	if err != nil {
+		return "", err
+	}
+	return data.PublicKey, nil
+}
+
+// signingNkeyNames maps the public keys to the names of the signing nkeys
+// stored below path. Public keys without signing nkey are rejected.
+func signingNkeyNames(ctx context.Context, storage logical.Storage, path string, publicKeys []string) (map[string]string, error) {
+	names := map[string]string{}
+	if len(publicKeys) == 0 {
+		return names, nil
+	}
+	stored, err := listNkeys(ctx, storage, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range stored {
+		publicKey, err := storedNkeyPublicKey(ctx, storage, path+name)
+		if err != nil {
+			return nil, err
+		}
+		names[publicKey] = name
+	}
+	for _, publicKey := range publicKeys {
+		if _, ok := names[publicKey]; !ok {
+			return nil, errInvalid("signing key %s has no nkey in the mount", publicKey)
+		}
+	}
+	return names, nil
+}
+
+// signingKeyNames renames the public keys of signing keys, keys without
+// name keep their public key
+func signingKeyNames(publicKeys []string, names map[string]string) []string {
+	var keys []string
+	for _, publicKey := range publicKeys {
+		if name, ok := names[publicKey]; ok {
+			keys = append(keys, name)
+		} else {
+			keys = append(keys, publicKey)
+		}
+	}
+	return keys
+}
+
+// adoptedOperatorClaims rebuilds the claims of an operator issue from a
+// decoded JWT. Subject, issuer and system account are set when signing.
+func adoptedOperatorClaims(claims *jwt.OperatorClaims, names map[string]string) operatorv1.OperatorClaims {
+	adopted := operatorv1.ConvertFromNats(claims)
+	adopted.ClaimsData = common.ClaimsData{Name: claims.Name}
+	adopted.SystemAccount = ""
+	adopted.SigningKeys = signingKeyNames(adopted.SigningKeys, names)
+	return *adopted
+}
+
+// adoptedAccountClaims rebuilds the claims of an account issue from a
+// decoded JWT
+func adoptedAccountClaims(claims *jwt.AccountClaims, names map[string]string) v1alpha1.AccountClaims {
+	adopted := v1alpha1.ConvertFromNats(claims)
+	adopted.ClaimsData = common.ClaimsData{Name: claims.Name}
+	adopted.SigningKeys = signingKeyNames(adopted.SigningKeys, names)
+	return *adopted
+}
+
+// adoptedUserClaims rebuilds the claims template of a user issue from a
+// decoded JWT
+func adoptedUserClaims(claims *jwt.UserClaims) userv1.UserClaims {
+	adopted := userv1.ConvertFromNats(claims)
+	adopted.ClaimsData = common.ClaimsData{Name: claims.Name}
+	adopted.IssuerAccount = ""
+	return *adopted
+}
@@ -0,0 +1,163 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdoptJWT(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{
+			"claims": map[string]interface{}{
+				"operator": map[string]interface{}{"signingKeys": []interface{}{"opsk1"}},
+			},
+		}},
+		{"issue/operator/op1/account/ac1", map[string]interface{}{
+			"claims": map[string]interface{}{
+				"account": map[string]interface{}{"signingKeys": []interface{}{"acsk1"}},
+			},
+		}},
+		{"issue/operator/op1/account/ac1/user/us1", map[string]interface{}{}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	keyPair := func(path string) nkeys.KeyPair {
+		nkey, err := readNkey(context.Background(), reqStorage, path)
+		require.NoError(t, err)
+		require.NotNil(t, nkey)
+		kp, err := nkeys.FromSeed(nkey.Seed)
+		require.NoError(t, err)
+		return kp
+	}
+	publicKey := func(kp nkeys.KeyPair) string {
+		pub, err := kp.PublicKey()
+		require.NoError(t, err)
+		return pub
+	}
+	adopt := func(path string, token string) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"jwt": token},
+		})
+	}
+	operatorKey := keyPair(getOperatorNkeyPath("op1"))
+	operatorSigningKey := keyPair(getOperatorSigningNkeyPath("op1", "opsk1"))
+	accountKey := keyPair(getAccountNkeyPath("op1", "ac1"))
+	accountSigningKey := keyPair(getAccountSigningNkeyPath("op1", "ac1", "acsk1"))
+	userKey := keyPair(getUserNkeyPath("op1", "ac1", "us1"))
+
+	t.Run("operator", func(t *testing.T) {
+		claims := jwt.NewOperatorClaims(publicKey(operatorKey))
+		claims.SigningKeys.Add(publicKey(operatorSigningKey))
+		claims.OperatorServiceURLs.Add("nats://localhost:4222")
+		token, err := claims.Encode(operatorKey)
+		require.NoError(t, err)
+
+		resp, err := adopt("jwt/operator/op1/adopt", token)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readOperatorIssue(context.Background(), reqStorage, IssueOperatorParameters{Operator: "op1"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"opsk1"}, issue.Claims.SigningKeys)
+		assert.Equal(t, []string{"nats://localhost:4222"}, issue.Claims.OperatorServiceURLs)
+	})
+
+	t.Run("stored account jwt", func(t *testing.T) {
+		claims := jwt.NewAccountClaims(publicKey(accountKey))
+		claims.SigningKeys.Add(publicKey(accountSigningKey))
+		claims.Limits.Conn = 5
+		token, err := claims.Encode(operatorSigningKey)
+		require.NoError(t, err)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "jwt/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"jwt": token},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = adopt("jwt/operator/op1/account/ac1/adopt", "")
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		assert.Equal(t, "opsk1", issue.UseSigningKey)
+		assert.Equal(t, []string{"acsk1"}, issue.Claims.SigningKeys)
+		assert.Equal(t, int64(5), issue.Claims.Limits.Conn)
+
+		// the reissued jwt keeps the adopted claims
+		accJWT, err := readAccountJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		reissued, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.Equal(t, publicKey(operatorSigningKey), reissued.Issuer)
+		assert.Equal(t, int64(5), reissued.Limits.Conn)
+	})
+
+	t.Run("user", func(t *testing.T) {
+		claims := jwt.NewUserClaims(publicKey(userKey))
+		claims.IssuerAccount = publicKey(accountKey)
+		claims.Pub.Allow.Add("foo.>")
+		claims.Expires = time.Now().Add(time.Minute).Unix()
+		token, err := claims.Encode(accountSigningKey)
+		require.NoError(t, err)
+
+		resp, err := adopt("jwt/operator/op1/account/ac1/user/us1/adopt", token)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		issue, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{Operator: "op1", Account: "ac1", User: "us1"})
+		require.NoError(t, err)
+		assert.Equal(t, "acsk1", issue.UseSigningKey)
+		assert.InDelta(t, 60, issue.ExpirationS, 2)
+		assert.Equal(t, []string{"foo.>"}, issue.ClaimsTemplate.Permissions.Pub.Allow)
+		assert.Empty(t, issue.ClaimsTemplate.IssuerAccount)
+	})
+
+	t.Run("jwt signed outside the mount is rejected", func(t *testing.T) {
+		foreign, err := nkeys.CreateOperator()
+		require.NoError(t, err)
+		token, err := jwt.NewAccountClaims(publicKey(accountKey)).Encode(foreign)
+		require.NoError(t, err)
+
+		resp, err := adopt("jwt/operator/op1/account/ac1/adopt", token)
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("subject without nkey is rejected", func(t *testing.T) {
+		other, err := nkeys.CreateAccount()
+		require.NoError(t, err)
+		token, err := jwt.NewAccountClaims(publicKey(other)).Encode(operatorKey)
+		require.NoError(t, err)
+
+		resp, err := adopt("jwt/operator/op1/account/ac1/adopt", token)
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.True(t, isErrorResponse(resp))
+	})
+}
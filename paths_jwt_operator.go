@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -61,18 +60,20 @@ func (b *NatsBackend) pathAddOperatorJWT(ctx context.Context, req *logical.Reque
 
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	err = addOperatorJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(AddingJWTFailedError), nil
+		return errorResponse(AddingJWTFailedError, err)
 	}
 	return nil, nil
 
@@ -81,22 +82,22 @@ func (b *NatsBackend) pathAddOperatorJWT(ctx context.Context, req *logical.Reque
 func (b *NatsBackend) pathReadOperatorJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	jwt, err := readOperatorJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingJWTFailedError), nil
+		return errorResponse(ReadingJWTFailedError, err)
 	}
 
 	if jwt == nil {
-		return logical.ErrorResponse(JwtNotFoundError), nil
+		return errorResponse(JwtNotFoundError, nil)
 	}
 
 	return createResponseJWTData(jwt)
@@ -105,12 +106,12 @@ func (b *NatsBackend) pathReadOperatorJWT(ctx context.Context, req *logical.Requ
 func (b *NatsBackend) pathListOperatorJWTs(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	entries, err := listOperatorJWTs(ctx, req.Storage)
 	if err != nil {
-		return logical.ErrorResponse(ListJWTsFailedError), nil
+		return errorResponse(ListJWTsFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -119,19 +120,21 @@ func (b *NatsBackend) pathListOperatorJWTs(ctx context.Context, req *logical.Req
 func (b *NatsBackend) pathDeleteOperatorJWT(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params JWTParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	// when a key is given, store it
 	err = deleteOperatorJWT(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteJWTFailedError), nil
+		return errorResponse(DeleteJWTFailedError, err)
 	}
 	return nil, nil
 }
@@ -152,7 +155,7 @@ func addOperatorJWT(ctx context.Context, storage logical.Storage, params JWTPara
 		Msg("create/update operator jwt")
 
 	if params.JWT == "" {
-		return fmt.Errorf("operator JWT is required")
+		return errInvalid("operator JWT is required")
 	} else {
 		err := validateJWT[jwt.OperatorClaims](params.JWT)
 		if err != nil {
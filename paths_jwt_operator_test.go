@@ -41,8 +41,8 @@ func TestCRUDOperatorJWTs(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -127,8 +127,8 @@ func TestCRUDOperatorJWTs(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// then recreate the key and read and delete it
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -221,8 +221,8 @@ func TestCRUDOperatorJWTs(t *testing.T) {
 				"jwt": createAccountJWT(),
 			},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.CreateOperation,
@@ -232,8 +232,8 @@ func TestCRUDOperatorJWTs(t *testing.T) {
 				"jwt": "wrong jwt",
 			},
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 	})
 
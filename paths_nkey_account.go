@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -72,18 +71,46 @@ func pathAccountNkey(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddAccountNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	issue, err := readAccountIssue(ctx, req.Storage, IssueAccountParameters{
+		Operator: params.Operator,
+		Account:  params.Account,
+	})
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
 	}
 
 	err = addAccountNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("%s: %s", AddingNkeyFailedError, err.Error())), nil
+		return errorResponse(AddingNkeyFailedError, err)
+	}
+	if issue == nil {
+		return nil, nil
+	}
+
+	// the account key might have changed, so re-sign the account
+	// and all accounts importing from it by name, an account still
+	// waiting for its operator is signed once the operator is created
+	err = refreshAccount(ctx, req.Storage, issue)
+	if isPending(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
+	}
+	err = updateImportingAccountIssues(ctx, req.Storage, *issue)
+	if err != nil {
+		return errorResponse(AddingIssueFailedError, err)
 	}
 	return nil, nil
 }
@@ -91,22 +118,22 @@ func (b *NatsBackend) pathAddAccountNkey(ctx context.Context, req *logical.Reque
 func (b *NatsBackend) pathReadAccountNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	nkey, err := readAccountNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingNkeyFailedError), nil
+		return errorResponse(ReadingNkeyFailedError, err)
 	}
 
 	if nkey == nil {
-		return logical.ErrorResponse(NkeyNotFoundError), nil
+		return errorResponse(NkeyNotFoundError, nil)
 	}
 
 	return createResponseNkeyData(nkey)
@@ -115,18 +142,18 @@ func (b *NatsBackend) pathReadAccountNkey(ctx context.Context, req *logical.Requ
 func (b *NatsBackend) pathListAccountNkeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listAccountNkeys(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListNkeysFailedError), nil
+		return errorResponse(ListNkeysFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -135,19 +162,21 @@ func (b *NatsBackend) pathListAccountNkeys(ctx context.Context, req *logical.Req
 func (b *NatsBackend) pathDeleteAccountNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	// when a key is given, store it
 	err = deleteAccountNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteNkeyFailedError), nil
+		return errorResponse(DeleteNkeyFailedError, err)
 	}
 	return nil, nil
 }
@@ -81,18 +81,20 @@ func pathAccountSigningNkey(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddAccountSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockAccount(params.Operator, params.Account)()
+
 	err = addAccountSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse("%s: %s", AddingNkeyFailedError, err.Error()), nil
+		return errorResponse(AddingNkeyFailedError, err)
 	}
 	return nil, nil
 }
@@ -100,22 +102,22 @@ func (b *NatsBackend) pathAddAccountSigningNkey(ctx context.Context, req *logica
 func (b *NatsBackend) pathReadAccountSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	nkey, err := readAccountSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingNkeyFailedError), nil
+		return errorResponse(ReadingNkeyFailedError, err)
 	}
 
 	if nkey == nil {
-		return logical.ErrorResponse(NkeyNotFoundError), nil
+		return errorResponse(NkeyNotFoundError, nil)
 	}
 
 	return createResponseNkeyData(nkey)
@@ -124,18 +126,18 @@ func (b *NatsBackend) pathReadAccountSigningNkey(ctx context.Context, req *logic
 func (b *NatsBackend) pathListAccountSigningNkeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listAccountSigningNkeys(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListNkeysFailedError), nil
+		return errorResponse(ListNkeysFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -144,19 +146,21 @@ func (b *NatsBackend) pathListAccountSigningNkeys(ctx context.Context, req *logi
 func (b *NatsBackend) pathDeleteAccountSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockAccount(params.Operator, params.Account)()
+
 	// when a key is given, store it
 	err = deleteAccountSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteNkeyFailedError), nil
+		return errorResponse(DeleteNkeyFailedError, err)
 	}
 	return nil, nil
 }
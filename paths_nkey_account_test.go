@@ -6,9 +6,16 @@ import (
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/nkeys"
 	"github.com/stretchr/testify/assert"
 )
 
+func genAccountSeed() string {
+	key, _ := nkeys.CreateAccount()
+	seed, _ := key.Seed()
+	return string(seed)
+}
+
 func TestCRUDAccountNKeys(t *testing.T) {
 	b, reqStorage := getTestBackend(t)
 
@@ -22,8 +29,8 @@ func TestCRUDAccountNKeys(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
 			Operation: logical.DeleteOperation,
@@ -89,8 +96,8 @@ func TestCRUDAccountNKeys(t *testing.T) {
 			Path:      path,
 			Storage:   reqStorage,
 		})
-		assert.NoError(t, err)
-		assert.True(t, resp.IsError())
+		assert.Error(t, err)
+		assert.True(t, isErrorResponse(resp))
 
 		// then recreate the key and read and delete it
 		resp, err = b.HandleRequest(context.Background(), &logical.Request{
@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -60,18 +59,20 @@ func pathOperatorNkey(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddOperatorNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	err = addOperatorNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("%s: %s", AddingNkeyFailedError, err.Error())), nil
+		return errorResponse(AddingNkeyFailedError, err)
 	}
 	return nil, nil
 }
@@ -79,22 +80,22 @@ func (b *NatsBackend) pathAddOperatorNkey(ctx context.Context, req *logical.Requ
 func (b *NatsBackend) pathReadOperatorNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	nkey, err := readOperatorNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingNkeyFailedError), nil
+		return errorResponse(ReadingNkeyFailedError, err)
 	}
 
 	if nkey == nil {
-		return logical.ErrorResponse(NkeyNotFoundError), nil
+		return errorResponse(NkeyNotFoundError, nil)
 	}
 
 	return createResponseNkeyData(nkey)
@@ -103,12 +104,12 @@ func (b *NatsBackend) pathReadOperatorNkey(ctx context.Context, req *logical.Req
 func (b *NatsBackend) pathListOperatorNkeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	entries, err := listOperatorNkeys(ctx, req.Storage)
 	if err != nil {
-		return logical.ErrorResponse(ListNkeysFailedError), nil
+		return errorResponse(ListNkeysFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -117,19 +118,21 @@ func (b *NatsBackend) pathListOperatorNkeys(ctx context.Context, req *logical.Re
 func (b *NatsBackend) pathDeleteOperatorNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	// when a key is given, store it
 	err = deleteOperatorNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteNkeyFailedError), nil
+		return errorResponse(DeleteNkeyFailedError, err)
 	}
 	return nil, nil
 }
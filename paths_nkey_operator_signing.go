@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -72,18 +71,20 @@ func pathOperatorSigningNkey(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddOperatorSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	err = addOperatorSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("%s: %s", AddingNkeyFailedError, err.Error())), nil
+		return errorResponse(AddingNkeyFailedError, err)
 	}
 	return nil, nil
 }
@@ -91,22 +92,22 @@ func (b *NatsBackend) pathAddOperatorSigningNkey(ctx context.Context, req *logic
 func (b *NatsBackend) pathReadOperatorSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	nkey, err := readOperatorSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingNkeyFailedError), nil
+		return errorResponse(ReadingNkeyFailedError, err)
 	}
 
 	if nkey == nil {
-		return logical.ErrorResponse(NkeyNotFoundError), nil
+		return errorResponse(NkeyNotFoundError, nil)
 	}
 
 	return createResponseNkeyData(nkey)
@@ -115,18 +116,18 @@ func (b *NatsBackend) pathReadOperatorSigningNkey(ctx context.Context, req *logi
 func (b *NatsBackend) pathListOperatorSigningNkeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listOperatorSigningNkeys(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListNkeysFailedError), nil
+		return errorResponse(ListNkeysFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -135,19 +136,21 @@ func (b *NatsBackend) pathListOperatorSigningNkeys(ctx context.Context, req *log
 func (b *NatsBackend) pathDeleteOperatorSigningNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockOperator(params.Operator)()
+
 	// when a key is given, store it
 	err = deleteOperatorSigningNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteNkeyFailedError), nil
+		return errorResponse(DeleteNkeyFailedError, err)
 	}
 	return nil, nil
 }
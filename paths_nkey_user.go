@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -82,18 +81,20 @@ func pathUserNkey(b *NatsBackend) []*framework.Path {
 func (b *NatsBackend) pathAddUserNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockUserIssue(params.Operator, params.Account, params.User)()
+
 	err = addUserNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("%s: %s", AddingNkeyFailedError, err.Error())), nil
+		return errorResponse(AddingNkeyFailedError, err)
 	}
 	return nil, nil
 }
@@ -101,22 +102,22 @@ func (b *NatsBackend) pathAddUserNkey(ctx context.Context, req *logical.Request,
 func (b *NatsBackend) pathReadUserNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	nkey, err := readUserNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ReadingNkeyFailedError), nil
+		return errorResponse(ReadingNkeyFailedError, err)
 	}
 
 	if nkey == nil {
-		return logical.ErrorResponse(NkeyNotFoundError), nil
+		return errorResponse(NkeyNotFoundError, nil)
 	}
 
 	return createResponseNkeyData(nkey)
@@ -125,18 +126,18 @@ func (b *NatsBackend) pathReadUserNkey(ctx context.Context, req *logical.Request
 func (b *NatsBackend) pathListUserNkeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
 	entries, err := listUserNkeys(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(ListNkeysFailedError), nil
+		return errorResponse(ListNkeysFailedError, err)
 	}
 
 	return logical.ListResponse(entries), nil
@@ -145,19 +146,21 @@ func (b *NatsBackend) pathListUserNkeys(ctx context.Context, req *logical.Reques
 func (b *NatsBackend) pathDeleteUserNkey(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	err := data.Validate()
 	if err != nil {
-		return logical.ErrorResponse(InvalidParametersError), logical.ErrInvalidRequest
+		return errorResponse(InvalidParametersError, err)
 	}
 
 	var params NkeyParameters
 	err = stm.MapToStruct(data.Raw, &params)
 	if err != nil {
-		return logical.ErrorResponse(DecodeFailedError), logical.ErrInvalidRequest
+		return errorResponse(DecodeFailedError, err)
 	}
 
+	defer b.lockUserIssue(params.Operator, params.Account, params.User)()
+
 	// when a key is given, store it
 	err = deleteUserNkey(ctx, req.Storage, params)
 	if err != nil {
-		return logical.ErrorResponse(DeleteNkeyFailedError), nil
+		return errorResponse(DeleteNkeyFailedError, err)
 	}
 	return nil, nil
 }
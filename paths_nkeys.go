@@ -2,7 +2,6 @@ package natsbackend
 
 import (
 	"context"
-	"fmt"
 	"regexp"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
@@ -81,7 +80,7 @@ func validateSeed(seed []byte, kind string) error {
 	}
 
 	if prefix != expected {
-		return fmt.Errorf("wrong seed type")
+		return errInvalid("wrong seed type")
 	}
 
 	return nil
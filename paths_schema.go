@@ -0,0 +1,95 @@
+package natsbackend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/schema"
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+type SchemaParameters struct {
+	Kind string `json:"kind"`
+}
+
+// SchemaData carries the JSON Schema the claims of the kind are validated
+// against on write
+type SchemaData struct {
+	Kind   string                 `json:"kind"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+func pathSchema(b *NatsBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "schema/claims/(?P<kind>" + strings.Join(schema.Kinds(), "|") + ")$",
+			Fields: map[string]*framework.FieldSchema{
+				"kind": {
+					Type:        framework.TypeString,
+					Description: "Kind of the claims: operator, account or user",
+					Required:    true,
+				},
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathReadSchema,
+				},
+			},
+			HelpSynopsis:    `Returns the JSON Schema of claims.`,
+			HelpDescription: `Returns the JSON Schema of the operator or account claims or the user claims template. Claims are validated against it on write.`,
+		},
+		{
+			Pattern: "schema/claims/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathListSchemas,
+				},
+			},
+			HelpSynopsis:    `Lists the kinds of claims with a JSON Schema.`,
+			HelpDescription: ``,
+		},
+	}
+}
+
+func (b *NatsBackend) pathReadSchema(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params SchemaParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	claimsSchema, err := schema.Get(params.Kind)
+	if err != nil {
+		return errorResponse(ReadingSchemaFailedError, err)
+	}
+
+	resp, err := createResponseSchemaData(&SchemaData{
+		Kind:   params.Kind,
+		Schema: claimsSchema,
+	})
+	if err != nil {
+		return errorResponse(ReadingSchemaFailedError, err)
+	}
+	return resp, nil
+}
+
+func (b *NatsBackend) pathListSchemas(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	return logical.ListResponse(schema.Kinds()), nil
+}
+
+func createResponseSchemaData(data *SchemaData) (*logical.Response, error) {
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{Data: rval}, nil
+}
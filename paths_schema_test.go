@@ -0,0 +1,81 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimsSchema(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	request := func(t *testing.T, operation logical.Operation, path string, data map[string]interface{}) (*logical.Response, error) {
+		return b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      data,
+		})
+	}
+
+	t.Run("read", func(t *testing.T) {
+		resp, err := request(t, logical.ListOperation, "schema/claims/", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"operator", "account", "user"}, resp.Data["keys"])
+
+		resp, err = request(t, logical.ReadOperation, "schema/claims/user", nil)
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, "user", resp.Data["kind"])
+		schema := resp.Data["schema"].(map[string]interface{})
+		assert.Equal(t, "UserClaims", schema["title"])
+		assert.Contains(t, schema["properties"], "user")
+
+		_, err = request(t, logical.ReadOperation, "schema/claims/link", nil)
+		assert.ErrorIs(t, err, logical.ErrUnsupportedPath)
+	})
+
+	t.Run("claims are validated on write", func(t *testing.T) {
+		resp, err := request(t, logical.UpdateOperation, "issue/operator/op1", map[string]interface{}{})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		resp, err = request(t, logical.UpdateOperation, "issue/operator/op1/account/ac1", map[string]interface{}{})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = request(t, logical.UpdateOperation, "issue/operator/op1/account/ac1/user/us1", map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"user": map[string]interface{}{
+					"allowedConnectionTypes": []interface{}{"mqtt"},
+				},
+			},
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.Contains(t, errorMessage(resp), `user.allowedConnectionTypes[0]: "mqtt" is not one of`)
+
+		resp, err = request(t, logical.UpdateOperation, "issue/operator/op1/account/ac1/user/us1", map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"user": map[string]interface{}{
+					"allowedConnectionTypes": []interface{}{"{{type}}"},
+					"times":                  []interface{}{map[string]interface{}{"start": "{{start}}", "end": "18:00:00"}},
+				},
+			},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = request(t, logical.UpdateOperation, "issue/operator/op1/account/ac1/user/us1", map[string]interface{}{
+			"claimsTemplate": map[string]interface{}{
+				"user": map[string]interface{}{
+					"times": []interface{}{map[string]interface{}{"start": "8am"}},
+				},
+			},
+		})
+		assertErrorStatus(t, err, http.StatusBadRequest)
+		assert.Contains(t, errorMessage(resp), `user.times[0].start: "8am" does not match`)
+	})
+}
@@ -0,0 +1,584 @@
+package natsbackend
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+// TombstoneStorage keeps the storage entries removed by the delete of an
+// operator or account until the retention period of the config expired.
+// Each removed entry is stored below the tombstone by its key, see
+// getTombstoneEntryPath.
+type TombstoneStorage struct {
+	Operator  string `json:"operator"`
+	Account   string `json:"account,omitempty"`
+	DeletedAt int64  `json:"deletedAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// TombstoneParameters identifies the tombstone of an operator or account
+type TombstoneParameters struct {
+	Operator string `json:"operator"`
+	Account  string `json:"account,omitempty"`
+}
+
+type TombstoneData struct {
+	Operator  string   `json:"operator"`
+	Account   string   `json:"account,omitempty"`
+	DeletedAt int64    `json:"deletedAt"`
+	ExpiresAt int64    `json:"expiresAt"`
+	Keys      []string `json:"keys"`
+}
+
+func pathTombstone(b *NatsBackend) []*framework.Path {
+	operatorField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "operator identifier",
+		Required:    false,
+	}
+	accountField := &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: "account identifier",
+		Required:    false,
+	}
+	return []*framework.Path{
+		{
+			Pattern: "tombstone/operator/" + framework.GenericNameRegex("operator") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathReadTombstone,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathDeleteTombstone,
+				},
+			},
+			HelpSynopsis:    `Manages the tombstone of a deleted operator.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "tombstone/operator/" + framework.GenericNameRegex("operator") + "/restore$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestoreTombstone,
+				},
+			},
+			HelpSynopsis:    `Restores a deleted operator from its tombstone.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "tombstone/operator/?$",
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathListTombstones,
+				},
+			},
+			HelpSynopsis:    "pathRoleListHelpSynopsis",
+			HelpDescription: "pathRoleListHelpDescription",
+		},
+		{
+			Pattern: "tombstone/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"account":  accountField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.pathReadTombstone,
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.pathDeleteTombstone,
+				},
+			},
+			HelpSynopsis:    `Manages the tombstone of a deleted account.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "tombstone/operator/" + framework.GenericNameRegex("operator") + "/account/" + framework.GenericNameRegex("account") + "/restore$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+				"account":  accountField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.pathRestoreTombstone,
+				},
+			},
+			HelpSynopsis:    `Restores a deleted account from its tombstone.`,
+			HelpDescription: ``,
+		},
+		{
+			Pattern: "tombstone/operator/" + framework.GenericNameRegex("operator") + "/account/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"operator": operatorField,
+			},
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ListOperation: &framework.PathOperation{
+					Callback: b.pathListTombstones,
+				},
+			},
+			HelpSynopsis:    "pathRoleListHelpSynopsis",
+			HelpDescription: "pathRoleListHelpDescription",
+		},
+	}
+}
+
+func (b *NatsBackend) pathReadTombstone(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params TombstoneParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	tombstone, err := readTombstone(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingTombstoneFailedError, err)
+	}
+	if tombstone == nil {
+		return errorResponse(TombstoneNotFoundError, nil)
+	}
+	keys, err := listTombstoneKeys(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(ReadingTombstoneFailedError, err)
+	}
+	return createResponseTombstoneData(tombstone, keys)
+}
+
+func (b *NatsBackend) pathListTombstones(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params TombstoneParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	path := getTombstonePath("", "")
+	if params.Operator != "" {
+		path = getTombstonePath(params.Operator, "") + "/account/"
+	}
+	entries, err := listIssues(ctx, req.Storage, path)
+	if err != nil {
+		return errorResponse(ListTombstonesFailedError, err)
+	}
+	return logical.ListResponse(entries), nil
+}
+
+func (b *NatsBackend) pathDeleteTombstone(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params TombstoneParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	err = deleteTombstone(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(DeleteTombstoneFailedError, err)
+	}
+	return nil, nil
+}
+
+func (b *NatsBackend) pathRestoreTombstone(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	err := data.Validate()
+	if err != nil {
+		return errorResponse(InvalidParametersError, err)
+	}
+
+	var params TombstoneParameters
+	err = stm.MapToStruct(data.Raw, &params)
+	if err != nil {
+		return errorResponse(DecodeFailedError, err)
+	}
+
+	defer b.lockOperator(params.Operator)()
+
+	warnings, err := restoreTombstone(ctx, req.Storage, params)
+	if err != nil {
+		return errorResponse(RestoreTombstoneFailedError, err)
+	}
+
+	var resp *logical.Response
+	if params.Account == "" {
+		resp, err = createResponseIssueOperatorResult(ctx, req.Storage, params.Operator)
+	} else {
+		resp, err = createResponseIssueAccountResult(ctx, req.Storage, params.Operator, params.Account)
+	}
+	if err != nil {
+		return errorResponse(ReadingIssueFailedError, err)
+	}
+	for _, warning := range warnings {
+		resp.AddWarning(warning)
+	}
+	return resp, nil
+}
+
+func readTombstone(ctx context.Context, storage logical.Storage, params TombstoneParameters) (*TombstoneStorage, error) {
+	path := getTombstonePath(params.Operator, params.Account)
+	return getFromStorage[TombstoneStorage](ctx, storage, path)
+}
+
+// listTombstoneKeys returns the keys of the entries kept by the tombstone in
+// storage order
+func listTombstoneKeys(ctx context.Context, storage logical.Storage, params TombstoneParameters) ([]string, error) {
+	prefix := getTombstoneEntryPath(params.Operator, params.Account, "")
+	entries, err := logical.CollectKeysWithPrefix(ctx, storage, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, entry := range entries {
+		keys = append(keys, strings.TrimPrefix(entry, prefix))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// deleteTombstone deletes the tombstone with the entries it keeps
+func deleteTombstone(ctx context.Context, storage logical.Storage, params TombstoneParameters) error {
+	keys, err := listTombstoneKeys(ctx, storage, params)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		err = storage.Delete(ctx, getTombstoneEntryPath(params.Operator, params.Account, key))
+		if err != nil {
+			return err
+		}
+	}
+	return deleteFromStorage(ctx, storage, getTombstonePath(params.Operator, params.Account))
+}
+
+// createTombstone starts the tombstone of an operator or account that is
+// about to be deleted, replacing an older one. It returns false if
+// tombstones are disabled by the config.
+func createTombstone(ctx context.Context, storage logical.Storage, operator string, account string) (bool, error) {
+	config, err := readConfig(ctx, storage)
+	if err != nil {
+		return false, err
+	}
+	if config.TombstoneRetentionS == 0 {
+		return false, nil
+	}
+
+	// the entries of an older tombstone must not be restored with the new one
+	err = deleteTombstone(ctx, storage, TombstoneParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	tombstone := &TombstoneStorage{
+		Operator:  operator,
+		Account:   account,
+		DeletedAt: now.Unix(),
+		ExpiresAt: now.Add(time.Duration(config.TombstoneRetentionS) * time.Second).Unix(),
+	}
+	err = storeInStorage(ctx, storage, getTombstonePath(operator, account), tombstone)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// tombstoneStorage moves every entry deleted through it to the tombstone
+// of the operator or account before deleting it. The entry is stored in
+// the tombstone first, so an interrupted delete loses no entry.
+type tombstoneStorage struct {
+	logical.Storage
+	operator string
+	account  string
+}
+
+// openTombstoneStorage wraps storage to move deleted entries to the tombstone
+// started by createTombstone
+func openTombstoneStorage(ctx context.Context, storage logical.Storage, operator string, account string) (*tombstoneStorage, error) {
+	tombstone, err := readTombstone(ctx, storage, TombstoneParameters{
+		Operator: operator,
+		Account:  account,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tombstone == nil {
+		_, err := createTombstone(ctx, storage, operator, account)
+		if err != nil {
+			return nil, err
+		}
+		tombstone, err = readTombstone(ctx, storage, TombstoneParameters{
+			Operator: operator,
+			Account:  account,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if tombstone == nil {
+			// tombstones are disabled
+			return nil, nil
+		}
+	}
+	return &tombstoneStorage{
+		Storage:  storage,
+		operator: operator,
+		account:  account,
+	}, nil
+}
+
+func (s *tombstoneStorage) Delete(ctx context.Context, key string) error {
+	entry, err := s.Storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		err = s.Storage.Put(ctx, &logical.StorageEntry{
+			Key:   getTombstoneEntryPath(s.operator, s.account, key),
+			Value: entry.Value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return s.Storage.Delete(ctx, key)
+}
+
+// restoreTombstone writes the entries of the tombstone back and reissues the
+// restored operator or account. Links deleted together with an account are
+// linked again, failures to do so are returned as warnings.
+func restoreTombstone(ctx context.Context, storage logical.Storage, params TombstoneParameters) ([]string, error) {
+	tombstone, err := readTombstone(ctx, storage, params)
+	if err != nil {
+		return nil, err
+	}
+	if tombstone == nil {
+		return nil, errNotFound("tombstone does not exist")
+	}
+	if time.Now().Unix() >= tombstone.ExpiresAt {
+		return nil, errNotFound("tombstone expired")
+	}
+
+	if params.Account != "" {
+		op, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: params.Operator,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			return nil, errNotFound("operator issue does not exist: %s", params.Operator)
+		}
+	}
+
+	keys, err := listTombstoneKeys(ctx, storage, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return nil, errConflict("%s exists, delete it before restoring", key)
+		}
+	}
+
+	log.Info().
+		Str("operator", params.Operator).Str("account", params.Account).
+		Msgf("restore tombstone")
+
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, getTombstoneEntryPath(params.Operator, params.Account, key))
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		err = storage.Put(ctx, &logical.StorageEntry{
+			Key:   key,
+			Value: entry.Value,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = deleteTombstone(ctx, storage, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Account == "" {
+		issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: params.Operator,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil {
+			err = refreshOperator(ctx, storage, issue)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// reissue and push the restored accounts
+	accountPrefix := getAccountIssuePath(params.Operator, "")
+	for _, key := range keys {
+		account := strings.TrimPrefix(key, accountPrefix)
+		if !strings.HasPrefix(key, accountPrefix) || strings.Contains(account, "/") {
+			continue
+		}
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: params.Operator,
+			Account:  account,
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = refreshAccount(ctx, storage, issue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	warnings := []string{}
+	linkPrefix := getLinkIssuePath(params.Operator, "")
+	for _, key := range keys {
+		if !strings.HasPrefix(key, linkPrefix) {
+			continue
+		}
+		issue, err := readLinkIssue(ctx, storage, IssueLinkParameters{
+			Operator: params.Operator,
+			Link:     strings.TrimPrefix(key, linkPrefix),
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = linkAccounts(ctx, storage, issue)
+		if err != nil {
+			warnings = append(warnings, "cannot restore link "+issue.Link+": "+err.Error())
+		}
+	}
+	return warnings, nil
+}
+
+// purgeTombstones deletes the expired tombstones
+func (b *NatsBackend) purgeTombstones(ctx context.Context, storage logical.Storage) error {
+	// operators with account tombstones only are listed as folders
+	entries, err := storage.List(ctx, getTombstonePath("", ""))
+	if err != nil {
+		return err
+	}
+	operators := []string{}
+	for _, entry := range entries {
+		operator := strings.TrimSuffix(entry, "/")
+		if len(operators) == 0 || operators[len(operators)-1] != operator {
+			operators = append(operators, operator)
+		}
+	}
+
+	for _, operator := range operators {
+		err := b.purgeOperatorTombstones(ctx, storage, operator)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeOperatorTombstones deletes the expired tombstones of the operator and its accounts
+func (b *NatsBackend) purgeOperatorTombstones(ctx context.Context, storage logical.Storage, operator string) error {
+	defer b.lockOperator(operator)()
+
+	params := []TombstoneParameters{{Operator: operator}}
+	accounts, err := listIssues(ctx, storage, getTombstonePath(operator, "")+"/account/")
+	if err != nil {
+		return err
+	}
+	for _, account := range accounts {
+		params = append(params, TombstoneParameters{Operator: operator, Account: account})
+	}
+
+	now := time.Now().Unix()
+	for _, p := range params {
+		tombstone, err := readTombstone(ctx, storage, p)
+		if err != nil {
+			return err
+		}
+		if tombstone == nil || now < tombstone.ExpiresAt {
+			continue
+		}
+		log.Info().
+			Str("operator", p.Operator).Str("account", p.Account).
+			Msgf("purge expired tombstone")
+		err = deleteTombstone(ctx, storage, p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createResponseTombstoneData(tombstone *TombstoneStorage, keys []string) (*logical.Response, error) {
+	data := &TombstoneData{
+		Operator:  tombstone.Operator,
+		Account:   tombstone.Account,
+		DeletedAt: tombstone.DeletedAt,
+		ExpiresAt: tombstone.ExpiresAt,
+		Keys:      keys,
+	}
+
+	rval := map[string]interface{}{}
+	err := stm.StructToMap(data, &rval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: rval,
+	}, nil
+}
+
+func getTombstonePath(operator string, account string) string {
+	if account == "" {
+		return "tombstone/operator/" + operator
+	}
+	return "tombstone/operator/" + operator + "/account/" + account
+}
+
+// getTombstoneEntryPath returns the path the tombstone keeps the removed
+// storage entry key at
+func getTombstoneEntryPath(operator string, account string, key string) string {
+	return getTombstonePath(operator, account) + "/entries/" + key
+}
@@ -0,0 +1,364 @@
+package natsbackend
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enableTombstones keeps deleted operators and accounts for restore
+func enableTombstones(t *testing.T, b *NatsBackend, storage logical.Storage) {
+	t.Helper()
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   storage,
+		Data:      map[string]interface{}{"tombstoneRetention": "720h"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+}
+
+func TestAccountTombstone(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	enableTombstones(t, b, reqStorage)
+
+	for _, setup := range []struct {
+		path string
+		data map[string]interface{}
+	}{
+		{"issue/operator/op1", map[string]interface{}{}},
+		{"issue/operator/op1/account/ac1", map[string]interface{}{"deletionProtection": true}},
+		{"issue/operator/op1/account/ac1/user/us1", map[string]interface{}{}},
+	} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      setup.path,
+			Storage:   reqStorage,
+			Data:      setup.data,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	accountPublicKey := func() string {
+		nkey, err := readAccountNkey(context.Background(), reqStorage, NkeyParameters{
+			Operator: "op1",
+			Account:  "ac1",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, nkey)
+		data, err := toNkeyData(nkey)
+		require.NoError(t, err)
+		return data.PublicKey
+	}
+	publicKey := accountPublicKey()
+
+	t.Run("deletion protection refuses the delete", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true},
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+
+		// a cascading operator delete is refused as well
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true},
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+
+		// a dry run returns the plan with the protection as blocker
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true, "dryRun": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Contains(t, resp.Data["deleted"], "issue/operator/op1/account/ac1")
+		assert.Equal(t, []string{"deletion protection is enabled for account ac1"}, resp.Data["blockers"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true, "dryRun": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []string{"deletion protection is enabled for account ac1"}, resp.Data["blockers"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"deletionProtection": false},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, false, resp.Data["deletionProtection"])
+	})
+
+	t.Run("delete moves the entries to the tombstone", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1/account/ac1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"cascade": true},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ListOperation,
+			Path:      "tombstone/operator/op1/account/",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ac1"}, resp.Data["keys"])
+
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "tombstone/operator/op1/account/ac1",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, []interface{}{
+			"issue/operator/op1/account/ac1",
+			"issue/operator/op1/account/ac1/user/us1",
+			"jwt/operator/op1/account/ac1",
+			"nkey/operator/op1/account/ac1",
+			"nkey/operator/op1/account/ac1/user/us1",
+		}, resp.Data["keys"])
+		assert.Greater(t, resp.Data["expiresAt"], resp.Data["deletedAt"])
+
+		// each entry is kept on its own
+		entry, err := reqStorage.Get(context.Background(), getTombstoneEntryPath("op1", "ac1", "nkey/operator/op1/account/ac1"))
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		var nkey NKeyStorage
+		require.NoError(t, entry.DecodeJSON(&nkey))
+		assert.NotEmpty(t, nkey.Seed)
+	})
+
+	t.Run("restore reissues the account with its nkey", func(t *testing.T) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tombstone/operator/op1/account/ac1/restore",
+			Storage:   reqStorage,
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		assert.Equal(t, publicKey, resp.Data["publicKey"])
+		assert.Equal(t, publicKey, accountPublicKey())
+
+		user, err := readUserIssue(context.Background(), reqStorage, IssueUserParameters{
+			Operator: "op1",
+			Account:  "ac1",
+			User:     "us1",
+		})
+		require.NoError(t, err)
+		assert.NotNil(t, user)
+
+		// the tombstone is gone
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tombstone/operator/op1/account/ac1/restore",
+			Storage:   reqStorage,
+		})
+		assertErrorStatus(t, err, http.StatusNotFound)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("restore refuses to overwrite a recreated account", func(t *testing.T) {
+		for _, op := range []logical.Operation{logical.DeleteOperation, logical.CreateOperation} {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: op,
+				Path:      "issue/operator/op1/account/ac1",
+				Storage:   reqStorage,
+				Data:      map[string]interface{}{},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.IsError())
+		}
+
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "tombstone/operator/op1/account/ac1/restore",
+			Storage:   reqStorage,
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+	})
+
+	t.Run("expired tombstones are purged", func(t *testing.T) {
+		tombstone, err := readTombstone(context.Background(), reqStorage, TombstoneParameters{
+			Operator: "op1",
+			Account:  "ac1",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, tombstone)
+		tombstone.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+		err = storeInStorage(context.Background(), reqStorage, getTombstonePath("op1", "ac1"), tombstone)
+		require.NoError(t, err)
+
+		err = b.periodicFunc(context.Background(), &logical.Request{Storage: reqStorage})
+		require.NoError(t, err)
+
+		tombstone, err = readTombstone(context.Background(), reqStorage, TombstoneParameters{
+			Operator: "op1",
+			Account:  "ac1",
+		})
+		require.NoError(t, err)
+		assert.Nil(t, tombstone)
+		keys, err := logical.CollectKeysWithPrefix(context.Background(), reqStorage, getTombstonePath("op1", "ac1"))
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+}
+
+func TestOperatorTombstone(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	enableTombstones(t, b, reqStorage)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"createSystemAccount": true,
+			"deletionProtection":  true,
+		},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	operatorJWT, err := readOperatorJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1"})
+	require.NoError(t, err)
+	require.NotNil(t, operatorJWT)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+	})
+	assertErrorStatus(t, err, http.StatusConflict)
+	assert.True(t, isErrorResponse(resp))
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.PatchOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{"deletionProtection": nil},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	// the system account deleted with the operator is protected on its own
+	for _, protection := range []bool{true, false} {
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.PatchOperation,
+			Path:      "issue/operator/op1/account/" + DefaultSysAccountName,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"deletionProtection": protection},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+		if !protection {
+			break
+		}
+		resp, err = b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+		})
+		assertErrorStatus(t, err, http.StatusConflict)
+		assert.True(t, isErrorResponse(resp))
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "issue/operator/op1",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	sysAccount, err := readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+		Operator: "op1",
+		Account:  DefaultSysAccountName,
+	})
+	require.NoError(t, err)
+	assert.Nil(t, sysAccount)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "tombstone/operator/op1/restore",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+
+	// the operator is back with the same key and its system account
+	restoredJWT, err := readOperatorJWT(context.Background(), reqStorage, JWTParameters{Operator: "op1"})
+	require.NoError(t, err)
+	require.NotNil(t, restoredJWT)
+	assert.Equal(t, operatorJWT.JWT, restoredJWT.JWT)
+	sysAccount, err = readAccountIssue(context.Background(), reqStorage, IssueAccountParameters{
+		Operator: "op1",
+		Account:  DefaultSysAccountName,
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, sysAccount)
+}
+
+func TestTombstoneRetentionConfig(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   reqStorage,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), resp.Data["tombstoneRetention"])
+
+	// tombstones are opt-in, deletes are permanent by default
+	for _, op := range []logical.Operation{logical.CreateOperation, logical.DeleteOperation} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: op,
+			Path:      "issue/operator/op1",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+	tombstone, err := readTombstone(context.Background(), reqStorage, TombstoneParameters{Operator: "op1"})
+	require.NoError(t, err)
+	assert.Nil(t, tombstone)
+	keys, err := logical.CollectKeysWithPrefix(context.Background(), reqStorage, "nkey/")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   reqStorage,
+		Data:      map[string]interface{}{"tombstoneRetention": "168h"},
+	})
+	require.NoError(t, err)
+	require.False(t, resp.IsError())
+	assert.Equal(t, float64(168*3600), resp.Data["tombstoneRetention"])
+}
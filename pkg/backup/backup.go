@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// Version is the format version of the archives written by Seal. Open reads
+// archives up to this version.
+const Version = 1
+
+// Archive holds the storage entries of a mount by their key
+type Archive struct {
+	Version   int               `json:"version"`
+	CreatedAt int64             `json:"createdAt"`
+	Entries   map[string][]byte `json:"entries"`
+	// Checksum is the hex encoded SHA-256 over the version and entries
+	Checksum string `json:"checksum"`
+}
+
+// New creates an archive of the current version holding the entries
+func New(entries map[string][]byte) *Archive {
+	a := &Archive{
+		Version:   Version,
+		CreatedAt: time.Now().Unix(),
+		Entries:   entries,
+	}
+	a.Checksum = a.checksum()
+	return a
+}
+
+// Keys returns the sorted keys of the entries
+func (a *Archive) Keys() []string {
+	keys := make([]string, 0, len(a.Entries))
+	for key := range a.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (a *Archive) checksum() string {
+	h := sha256.New()
+	write := func(b []byte) {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+		h.Write(size[:])
+		h.Write(b)
+	}
+	write([]byte(fmt.Sprint(a.Version)))
+	for _, key := range a.Keys() {
+		write([]byte(key))
+		write(a.Entries[key])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Recipient returns the age recipient for either a passphrase or an age
+// X25519 public key
+func Recipient(passphrase string, recipient string) (age.Recipient, error) {
+	switch {
+	case passphrase != "" && recipient != "":
+		return nil, fmt.Errorf("either passphrase or recipient must be set, not both")
+	case passphrase != "":
+		return age.NewScryptRecipient(passphrase)
+	case recipient != "":
+		return age.ParseX25519Recipient(recipient)
+	default:
+		return nil, fmt.Errorf("passphrase or recipient is required")
+	}
+}
+
+// Identity returns the age identity for either a passphrase or an age
+// X25519 secret key
+func Identity(passphrase string, identity string) (age.Identity, error) {
+	switch {
+	case passphrase != "" && identity != "":
+		return nil, fmt.Errorf("either passphrase or identity must be set, not both")
+	case passphrase != "":
+		return age.NewScryptIdentity(passphrase)
+	case identity != "":
+		return age.ParseX25519Identity(identity)
+	default:
+		return nil, fmt.Errorf("passphrase or identity is required")
+	}
+}
+
+// Seal encrypts the archive for the recipient as ASCII armored age file
+func Seal(a *Archive, recipient age.Recipient) (string, error) {
+	plain, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+	w, err := age.Encrypt(aw, recipient)
+	if err != nil {
+		return "", err
+	}
+	_, err = w.Write(plain)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	if err != nil {
+		return "", err
+	}
+	err = aw.Close()
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Open decrypts an archive sealed by Seal and verifies its version and checksum
+func Open(sealed string, identity age.Identity) (*Archive, error) {
+	r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(sealed)+"\n")), identity)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt archive: %w", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt archive: %w", err)
+	}
+
+	a := &Archive{}
+	err = json.Unmarshal(plain, a)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if a.Version < 1 || a.Version > Version {
+		return nil, fmt.Errorf("unsupported archive version: %d", a.Version)
+	}
+	if a.Checksum != a.checksum() {
+		return nil, fmt.Errorf("archive checksum mismatch")
+	}
+	if a.Entries == nil {
+		a.Entries = map[string][]byte{}
+	}
+	return a, nil
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	entries := map[string][]byte{
+		"config":              []byte(`{"tombstoneRetention":0}`),
+		"nkey/operator/op1":   []byte(`{"seed":"SO..."}`),
+		"issue/operator/op1":  []byte(`{"operator":"op1"}`),
+		"jwt/operator/op1":    []byte(`{"jwt":"ey..."}`),
+		"issue/operator/op1/": {},
+	}
+
+	t.Run("passphrase", func(t *testing.T) {
+		recipient, err := Recipient("secret", "")
+		require.NoError(t, err)
+		sealed, err := Seal(New(entries), recipient)
+		require.NoError(t, err)
+		assert.Contains(t, sealed, "BEGIN AGE ENCRYPTED FILE")
+
+		identity, err := Identity("secret", "")
+		require.NoError(t, err)
+		a, err := Open(sealed, identity)
+		require.NoError(t, err)
+		assert.Equal(t, Version, a.Version)
+		assert.Equal(t, entries, a.Entries)
+
+		identity, err = Identity("wrong", "")
+		require.NoError(t, err)
+		_, err = Open(sealed, identity)
+		assert.Error(t, err)
+	})
+
+	t.Run("x25519", func(t *testing.T) {
+		key, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		recipient, err := Recipient("", key.Recipient().String())
+		require.NoError(t, err)
+		sealed, err := Seal(New(entries), recipient)
+		require.NoError(t, err)
+
+		identity, err := Identity("", key.String())
+		require.NoError(t, err)
+		a, err := Open(sealed, identity)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"config", "issue/operator/op1", "issue/operator/op1/", "jwt/operator/op1", "nkey/operator/op1"}, a.Keys())
+	})
+
+	t.Run("checksum and version are verified", func(t *testing.T) {
+		key, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+
+		tampered := New(map[string][]byte{"config": []byte("a")})
+		tampered.Entries["config"] = []byte("b")
+		sealed, err := Seal(tampered, key.Recipient())
+		require.NoError(t, err)
+		_, err = Open(sealed, key)
+		assert.ErrorContains(t, err, "checksum")
+
+		future := New(entries)
+		future.Version = Version + 1
+		future.Checksum = future.checksum()
+		sealed, err = Seal(future, key.Recipient())
+		require.NoError(t, err)
+		_, err = Open(sealed, key)
+		assert.ErrorContains(t, err, "version")
+	})
+
+	t.Run("exactly one secret", func(t *testing.T) {
+		_, err := Recipient("", "")
+		assert.Error(t, err)
+		_, err = Recipient("secret", "age1")
+		assert.Error(t, err)
+		_, err = Identity("", "")
+		assert.Error(t, err)
+	})
+}
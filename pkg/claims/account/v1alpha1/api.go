@@ -88,14 +88,14 @@ type OperatorLimits struct {
 	common.NatsLimits `json:",inline"`
 	AccountLimits     `json:",inline"`
 	JetStreamLimits   `json:",inline"`
-	// JetStreamTieredLimits as far as i can tell it is only used by NATS internally.
-	// So not exposed to the user for now.
-	// JetStreamTieredLimits `json:"tieredLimits,omitempty"`
+	// JetStream limits per replication tier, e.g. R1 or R3.
+	// Can't be combined with the non-tiered JetStream limits.
+	// +kubebuilder:validation:Optional
+	JetStreamTieredLimits `json:"tieredLimits,omitempty"`
 }
 
-// JetStreamTieredLimits as far as i can tell it is only used by NATS internally.
-// So not exposed to the user for now.
-// type JetStreamTieredLimits map[string]JetStreamLimits
+// JetStreamTieredLimits maps a replication tier, e.g. R1 or R3, to its JetStream limits
+type JetStreamTieredLimits map[string]JetStreamLimits
 
 // JetStreamLimits represents the Jetstream limits for an account
 type JetStreamLimits struct {
@@ -148,7 +148,8 @@ type AccountLimits struct {
 	LeafNodeConn int64 `json:"leafNodeConn,omitempty"`
 }
 
-func convertExportType(t string) (jwt.ExportType, error) {
+// ConvertExportType converts the name of an export or import type into a jwt.ExportType
+func ConvertExportType(t string) (jwt.ExportType, error) {
 	switch t {
 	case "Stream":
 		return jwt.Stream, nil
@@ -169,9 +170,14 @@ type Import struct {
 	// The subject to import
 	// +kubebuilder:validation:Optional
 	Subject string `json:"subject,omitempty"`
-	// The account to import from
+	// The public key of the account to import from
 	// +kubebuilder:validation:Optional
 	Account string `json:"account,omitempty"`
+	// The name of an account of the same operator to import from.
+	// Resolved to the account's public key when the JWT is issued.
+	// Mutually exclusive with account.
+	// +kubebuilder:validation:Optional
+	AccountRef string `json:"accountRef,omitempty"`
 	// The token to use for the import
 	// +kubebuilder:validation:Optional
 	Token string `json:"token,omitempty"`
@@ -211,6 +217,7 @@ type Export struct {
 	ResponseThreshold string `json:"responseThreshold,omitempty"`
 	// The latency for the export.
 	// +kubebuilder:validation:Optional
+	// +nullable
 	Latency *ServiceLatency `json:"serviceLatency,omitempty"`
 	// The account token position for the export
 	// +kubebuilder:validation:Optional
@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	"fmt"
+	"sort"
 	"time"
 
 	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/common"
@@ -20,7 +22,7 @@ func convertImports(in *Account, out *jwt.Account) error {
 			LocalSubject: jwt.RenamingSubject(jwt.Subject(e.LocalSubject)),
 			Share:        e.Share,
 		}
-		t, err := convertExportType(e.Type)
+		t, err := ConvertExportType(e.Type)
 		if err != nil {
 			return err
 		}
@@ -48,7 +50,7 @@ func convertExports(in *Account, out *jwt.Account) error {
 				InfoURL:     e.Info.InfoURL,
 			},
 		}
-		t, err := convertExportType(e.Type)
+		t, err := ConvertExportType(e.Type)
 		if err != nil {
 			return err
 		}
@@ -71,7 +73,23 @@ func convertExports(in *Account, out *jwt.Account) error {
 	return nil
 }
 
-func convertLimits(in *Account, out *jwt.Account) {
+func convertJetStreamLimits(in JetStreamLimits) jwt.JetStreamLimits {
+	return jwt.JetStreamLimits{
+		MemoryStorage:        in.MemoryStorage,
+		DiskStorage:          in.DiskStorage,
+		Streams:              in.Streams,
+		Consumer:             in.Consumer,
+		MaxAckPending:        in.MaxAckPending,
+		MemoryMaxStreamBytes: in.MemoryMaxStreamBytes,
+		DiskMaxStreamBytes:   in.DiskMaxStreamBytes,
+		MaxBytesRequired:     in.MaxBytesRequired,
+	}
+}
+
+func convertLimits(in *Account, out *jwt.Account) error {
+	if len(in.Limits.JetStreamTieredLimits) > 0 && in.Limits.JetStreamLimits != (JetStreamLimits{}) {
+		return fmt.Errorf("jetstream limits and tiered jetstream limits are mutually exclusive")
+	}
 	out.Limits = jwt.OperatorLimits{
 		NatsLimits: jwt.NatsLimits{
 			Subs:    in.Limits.NatsLimits.Subs,
@@ -86,17 +104,15 @@ func convertLimits(in *Account, out *jwt.Account) {
 			Conn:            in.Limits.AccountLimits.Conn,
 			LeafNodeConn:    in.Limits.AccountLimits.LeafNodeConn,
 		},
-		JetStreamLimits: jwt.JetStreamLimits{
-			MemoryStorage:        in.Limits.JetStreamLimits.MemoryStorage,
-			DiskStorage:          in.Limits.JetStreamLimits.DiskStorage,
-			Streams:              in.Limits.JetStreamLimits.Streams,
-			Consumer:             in.Limits.JetStreamLimits.Consumer,
-			MaxAckPending:        in.Limits.JetStreamLimits.MaxAckPending,
-			MemoryMaxStreamBytes: in.Limits.JetStreamLimits.MemoryMaxStreamBytes,
-			DiskMaxStreamBytes:   in.Limits.JetStreamLimits.DiskMaxStreamBytes,
-			MaxBytesRequired:     in.Limits.JetStreamLimits.MaxBytesRequired,
-		},
+		JetStreamLimits: convertJetStreamLimits(in.Limits.JetStreamLimits),
 	}
+	if len(in.Limits.JetStreamTieredLimits) > 0 {
+		out.Limits.JetStreamTieredLimits = make(jwt.JetStreamTieredLimits, len(in.Limits.JetStreamTieredLimits))
+		for tier, limits := range in.Limits.JetStreamTieredLimits {
+			out.Limits.JetStreamTieredLimits[tier] = convertJetStreamLimits(limits)
+		}
+	}
+	return nil
 }
 
 func convertSigningKeyKind(kind string) jwt.ScopeType {
@@ -200,7 +216,10 @@ func Convert(claims *AccountClaims) (*jwt.AccountClaims, error) {
 	if err != nil {
 		return nil, err
 	}
-	convertLimits(&claims.Account, &nats.Account)
+	err = convertLimits(&claims.Account, &nats.Account)
+	if err != nil {
+		return nil, err
+	}
 	convertSigningKeys(&claims.Account, &nats.Account)
 	convertRevocations(&claims.Account, &nats.Account)
 	convertDefaultPermissions(&claims.Account, &nats.Account)
@@ -210,3 +229,154 @@ func Convert(claims *AccountClaims) (*jwt.AccountClaims, error) {
 	nats.GenericFields = common.ConvertGenericFields(&claims.GenericFields)
 	return nats, nil
 }
+
+// ExportTypeName is the inverse of ConvertExportType
+func ExportTypeName(t jwt.ExportType) string {
+	switch t {
+	case jwt.Stream:
+		return "Stream"
+	case jwt.Service:
+		return "Service"
+	}
+	return "Unknown"
+}
+
+func convertImportsFromNats(in *jwt.Account, out *Account) {
+	for _, e := range in.Imports {
+		out.Imports = append(out.Imports, Import{
+			Name:         e.Name,
+			Subject:      string(e.Subject),
+			Account:      e.Account,
+			Token:        e.Token,
+			LocalSubject: string(e.LocalSubject),
+			Type:         ExportTypeName(e.Type),
+			Share:        e.Share,
+		})
+	}
+}
+
+func convertExportsFromNats(in *jwt.Account, out *Account) {
+	for _, e := range in.Exports {
+		export := Export{
+			Name:                 e.Name,
+			Subject:              string(e.Subject),
+			Type:                 ExportTypeName(e.Type),
+			TokenReq:             e.TokenReq,
+			ResponseType:         string(e.ResponseType),
+			AccountTokenPosition: e.AccountTokenPosition,
+			Advertise:            e.Advertise,
+			Info: common.Info{
+				Description: e.Description,
+				InfoURL:     e.InfoURL,
+			},
+		}
+		if e.Revocations != nil {
+			export.Revocations = make(map[string]int64, len(e.Revocations))
+			for k, v := range e.Revocations {
+				export.Revocations[k] = v
+			}
+		}
+		if e.Latency != nil {
+			export.Latency = &ServiceLatency{
+				Sampling: int(e.Latency.Sampling),
+				Results:  string(e.Latency.Results),
+			}
+		}
+		if e.ResponseThreshold != 0 {
+			export.ResponseThreshold = e.ResponseThreshold.String()
+		}
+		out.Exports = append(out.Exports, export)
+	}
+}
+
+func convertJetStreamLimitsFromNats(in jwt.JetStreamLimits) JetStreamLimits {
+	return JetStreamLimits{
+		MemoryStorage:        in.MemoryStorage,
+		DiskStorage:          in.DiskStorage,
+		Streams:              in.Streams,
+		Consumer:             in.Consumer,
+		MaxAckPending:        in.MaxAckPending,
+		MemoryMaxStreamBytes: in.MemoryMaxStreamBytes,
+		DiskMaxStreamBytes:   in.DiskMaxStreamBytes,
+		MaxBytesRequired:     in.MaxBytesRequired,
+	}
+}
+
+func convertLimitsFromNats(in *jwt.Account, out *Account) {
+	out.Limits = OperatorLimits{
+		NatsLimits: common.NatsLimits{
+			Subs:    in.Limits.Subs,
+			Data:    in.Limits.Data,
+			Payload: in.Limits.Payload,
+		},
+		AccountLimits: AccountLimits{
+			Imports:         in.Limits.Imports,
+			Exports:         in.Limits.Exports,
+			WildcardExports: in.Limits.WildcardExports,
+			DisallowBearer:  in.Limits.DisallowBearer,
+			Conn:            in.Limits.Conn,
+			LeafNodeConn:    in.Limits.LeafNodeConn,
+		},
+		JetStreamLimits: convertJetStreamLimitsFromNats(in.Limits.JetStreamLimits),
+	}
+	if len(in.Limits.JetStreamTieredLimits) > 0 {
+		out.Limits.JetStreamTieredLimits = make(JetStreamTieredLimits, len(in.Limits.JetStreamTieredLimits))
+		for tier, limits := range in.Limits.JetStreamTieredLimits {
+			out.Limits.JetStreamTieredLimits[tier] = convertJetStreamLimitsFromNats(limits)
+		}
+	}
+}
+
+func convertMappingsFromNats(in *jwt.Account, out *Account) {
+	if in.Mappings == nil {
+		return
+	}
+	out.Mappings = make(map[string][]WeightedMapping, len(in.Mappings))
+	for k, v := range in.Mappings {
+		mappings := []WeightedMapping{}
+		for _, m := range v {
+			mappings = append(mappings, WeightedMapping{
+				Subject: string(m.Subject),
+				Weight:  m.Weight,
+				Cluster: m.Cluster,
+			})
+		}
+		out.Mappings[string(k)] = mappings
+	}
+}
+
+// ConvertFromNats converts nats account claims into account claims. Signing
+// keys are returned as the public keys found in the JWT, sorted.
+func ConvertFromNats(nats *jwt.AccountClaims) *AccountClaims {
+	claims := &AccountClaims{
+		Account: Account{
+			Info: common.Info{
+				Description: nats.Description,
+				InfoURL:     nats.InfoURL,
+			},
+			DefaultPermissions: common.ConvertPermissionsFromNats(&nats.DefaultPermissions),
+			Authorization: ExternalAuthorization{
+				AuthUsers:       []string(nats.Authorization.AuthUsers),
+				AllowedAccounts: []string(nats.Authorization.AllowedAccounts),
+				XKey:            nats.Authorization.XKey,
+			},
+		},
+	}
+	convertImportsFromNats(&nats.Account, &claims.Account)
+	convertExportsFromNats(&nats.Account, &claims.Account)
+	convertLimitsFromNats(&nats.Account, &claims.Account)
+	convertMappingsFromNats(&nats.Account, &claims.Account)
+	if len(nats.SigningKeys) > 0 {
+		claims.SigningKeys = nats.SigningKeys.Keys()
+		sort.Strings(claims.SigningKeys)
+	}
+	if nats.Revocations != nil {
+		claims.Revocations = make(map[string]int64, len(nats.Revocations))
+		for k, v := range nats.Revocations {
+			claims.Revocations[k] = v
+		}
+	}
+	claims.ClaimsData = common.ConvertClaimsDataFromNats(&nats.ClaimsData)
+	claims.GenericFields = common.ConvertGenericFieldsFromNats(&nats.GenericFields)
+	return claims
+}
@@ -201,3 +201,115 @@ func TestConvert(t *testing.T) {
 	assert.Equal(nats.Authorization.AllowedAccounts[2], "*")
 	assert.Equal(nats.Authorization.XKey, "myxkey")
 }
+
+func TestConvertTieredLimits(t *testing.T) {
+	assert := assert.New(t)
+	claims := AccountClaims{
+		Account: Account{
+			Limits: OperatorLimits{
+				JetStreamTieredLimits: JetStreamTieredLimits{
+					"R1": {
+						MemoryStorage: 1,
+						DiskStorage:   2,
+						Streams:       3,
+					},
+					"R3": {
+						DiskStorage: 4,
+						Consumer:    5,
+					},
+				},
+			},
+		},
+	}
+
+	nats, err := Convert(&claims)
+	assert.NoError(err)
+	assert.Len(nats.Limits.JetStreamTieredLimits, 2)
+	assert.Equal(nats.Limits.JetStreamTieredLimits["R1"].MemoryStorage, int64(1))
+	assert.Equal(nats.Limits.JetStreamTieredLimits["R1"].DiskStorage, int64(2))
+	assert.Equal(nats.Limits.JetStreamTieredLimits["R1"].Streams, int64(3))
+	assert.Equal(nats.Limits.JetStreamTieredLimits["R3"].DiskStorage, int64(4))
+	assert.Equal(nats.Limits.JetStreamTieredLimits["R3"].Consumer, int64(5))
+	assert.Equal(nats.Limits.JetStreamLimits, jwt.JetStreamLimits{})
+
+	// tiered and non-tiered limits can't be mixed
+	claims.Account.Limits.JetStreamLimits.DiskStorage = 6
+	_, err = Convert(&claims)
+	assert.Error(err)
+}
+
+func TestConvertFromNats(t *testing.T) {
+	assert := assert.New(t)
+	claims := &AccountClaims{
+		ClaimsData: common.ClaimsData{
+			Name:    "myaccount",
+			Subject: "subject",
+			Issuer:  "issuer",
+		},
+		Account: Account{
+			Imports: []Import{
+				{
+					Name:         "myimport",
+					Subject:      "mysubject",
+					Account:      "myaccount",
+					LocalSubject: "localsubject",
+					Type:         "Stream",
+					Share:        true,
+				},
+			},
+			Exports: []Export{
+				{
+					Name:              "myexport",
+					Subject:           "mysubject",
+					Type:              "Service",
+					TokenReq:          true,
+					Revocations:       map[string]int64{"r1": 1675804527},
+					ResponseType:      "Stream",
+					ResponseThreshold: "3m0s",
+					Latency: &ServiceLatency{
+						Sampling: 100,
+						Results:  "results",
+					},
+					AccountTokenPosition: 1,
+					Advertise:            true,
+					Info: common.Info{
+						Description: "description",
+						InfoURL:     "infourl",
+					},
+				},
+			},
+			Limits: OperatorLimits{
+				NatsLimits: common.NatsLimits{Subs: -1, Data: -1, Payload: -1},
+				AccountLimits: AccountLimits{
+					Imports: -1,
+					Exports: -1,
+					Conn:    10,
+				},
+				JetStreamLimits: JetStreamLimits{DiskStorage: 100},
+			},
+			SigningKeys: []string{"sk1", "sk2"},
+			Revocations: map[string]int64{"user": 1675804527},
+			DefaultPermissions: common.Permissions{
+				Pub: common.Permission{Allow: []string{"pub.>"}},
+				Resp: &common.ResponsePermission{
+					MaxMsgs: 1,
+					Expires: "5s",
+				},
+			},
+			Mappings: map[string][]WeightedMapping{
+				"mapping": {{Subject: "mysubject", Weight: 100}},
+			},
+			Authorization: ExternalAuthorization{
+				AuthUsers: []string{"myauthuser"},
+				XKey:      "myxkey",
+			},
+			Info: common.Info{
+				Description: "description",
+			},
+		},
+	}
+
+	nats, err := Convert(claims)
+	assert.NoError(err)
+	assert.Equal(claims, ConvertFromNats(nats))
+}
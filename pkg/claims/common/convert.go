@@ -22,3 +22,46 @@ func ConvertClaimsData(in *ClaimsData) jwt.ClaimsData {
 		Subject:   in.Subject,
 	}
 }
+
+func ConvertGenericFieldsFromNats(in *jwt.GenericFields) GenericFields {
+	return GenericFields{
+		Tags:    []string(in.Tags),
+		Type:    string(in.Type),
+		Version: in.Version,
+	}
+}
+
+func ConvertClaimsDataFromNats(in *jwt.ClaimsData) ClaimsData {
+	return ClaimsData{
+		Audience:  in.Audience,
+		Expires:   in.Expires,
+		ID:        in.ID,
+		IssuedAt:  in.IssuedAt,
+		Issuer:    in.Issuer,
+		Name:      in.Name,
+		NotBefore: in.NotBefore,
+		Subject:   in.Subject,
+	}
+}
+
+// ConvertPermissionsFromNats converts the permissions of a nats JWT,
+// durations are formatted the way the forward conversion parses them
+func ConvertPermissionsFromNats(in *jwt.Permissions) Permissions {
+	out := Permissions{
+		Pub: Permission{
+			Allow: []string(in.Pub.Allow),
+			Deny:  []string(in.Pub.Deny),
+		},
+		Sub: Permission{
+			Allow: []string(in.Sub.Allow),
+			Deny:  []string(in.Sub.Deny),
+		},
+	}
+	if in.Resp != nil {
+		out.Resp = &ResponsePermission{
+			MaxMsgs: in.Resp.MaxMsgs,
+			Expires: in.Resp.Expires.String(),
+		}
+	}
+	return out
+}
@@ -49,6 +49,7 @@ type Permissions struct {
 	Sub Permission `json:"sub,omitempty"`
 	// Specifies the response permissions
 	// +kubebuilder:validation:Optional
+	// +nullable
 	Resp *ResponsePermission `json:"resp,omitempty"`
 }
 
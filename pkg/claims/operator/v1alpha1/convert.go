@@ -21,3 +21,21 @@ func Convert(claims *OperatorClaims) *jwt.OperatorClaims {
 	nats.GenericFields = common.ConvertGenericFields(&claims.GenericFields)
 	return nats
 }
+
+// ConvertFromNats converts nats operator claims into operator claims. Signing
+// keys are returned as the public keys found in the JWT.
+func ConvertFromNats(nats *jwt.OperatorClaims) *OperatorClaims {
+	claims := &OperatorClaims{
+		Operator: Operator{
+			SigningKeys:           []string(nats.SigningKeys),
+			AccountServerURL:      nats.AccountServerURL,
+			OperatorServiceURLs:   []string(nats.OperatorServiceURLs),
+			SystemAccount:         nats.SystemAccount,
+			AssertServerVersion:   nats.AssertServerVersion,
+			StrictSigningKeyUsage: nats.StrictSigningKeyUsage,
+		},
+	}
+	claims.ClaimsData = common.ConvertClaimsDataFromNats(&nats.ClaimsData)
+	claims.GenericFields = common.ConvertGenericFieldsFromNats(&nats.GenericFields)
+	return claims
+}
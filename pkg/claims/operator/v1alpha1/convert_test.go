@@ -37,3 +37,21 @@ func TestConvert(t *testing.T) {
 	assert.Equal(nats.GenericFields.Type, jwt.ClaimType("claimtype"))
 	assert.Equal(nats.GenericFields.Version, int(100))
 }
+
+func TestConvertFromNats(t *testing.T) {
+	claims := &OperatorClaims{
+		ClaimsData: common.ClaimsData{
+			Name:    "myoperator",
+			Subject: "subject",
+			Issuer:  "subject",
+		},
+		Operator: Operator{
+			SigningKeys:           []string{"sk1", "sk2"},
+			AccountServerURL:      "nats://localhost:4222",
+			OperatorServiceURLs:   []string{"tls://host:port"},
+			SystemAccount:         "systemaccount",
+			StrictSigningKeyUsage: true,
+		},
+	}
+	assert.Equal(t, claims, ConvertFromNats(Convert(claims)))
+}
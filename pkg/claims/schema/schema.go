@@ -0,0 +1,285 @@
+// Package schema holds the JSON Schemas of the claims types. They are
+// generated from the Go types and their kubebuilder validation markers
+// with `go generate` and used to validate claims on write.
+package schema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/validate"
+)
+
+//go:generate go run -tags generate ../../../hack/schemagen -out .
+
+const (
+	Operator = "operator"
+	Account  = "account"
+	User     = "user"
+)
+
+//go:embed *.schema.json
+var files embed.FS
+
+var (
+	schemas   = map[string]map[string]interface{}{}
+	schemasMu sync.Mutex
+	patterns  sync.Map
+)
+
+// Kinds returns the kinds of claims with a schema
+func Kinds() []string {
+	return []string{Operator, Account, User}
+}
+
+// Raw returns the JSON Schema of the claims kind as stored
+func Raw(kind string) ([]byte, error) {
+	if !isKind(kind) {
+		return nil, fmt.Errorf("unknown claims kind: %s", kind)
+	}
+	return files.ReadFile(kind + ".schema.json")
+}
+
+// Get returns the decoded JSON Schema of the claims kind
+func Get(kind string) (map[string]interface{}, error) {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+
+	if schema, ok := schemas[kind]; ok {
+		return schema, nil
+	}
+	raw, err := Raw(kind)
+	if err != nil {
+		return nil, err
+	}
+	schema := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err = decoder.Decode(&schema)
+	if err != nil {
+		return nil, err
+	}
+	schemas[kind] = schema
+	return schema, nil
+}
+
+// Option changes the validation
+type Option func(*validator)
+
+// IgnoreStrings skips the pattern and enum checks of strings matched by
+// ignore, e.g. strings with template variables
+func IgnoreStrings(ignore func(string) bool) Option {
+	return func(v *validator) {
+		v.ignore = ignore
+	}
+}
+
+// Validate validates the claims, any value encoding to JSON, against the
+// schema of the claims kind. Every error carries the path of the offending
+// field, e.g. user.times[0].start.
+func Validate(kind string, claims interface{}, opts ...Option) error {
+	schema, err := Get(kind)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	if err != nil {
+		return err
+	}
+
+	v := &validator{}
+	for _, opt := range opts {
+		opt(v)
+	}
+	v.validate(value, schema, "")
+	if len(v.errs) > 0 {
+		sort.Strings(v.errs)
+		return fmt.Errorf("%s: %s", validate.InvalidClaimsError, strings.Join(v.errs, "; "))
+	}
+	return nil
+}
+
+func isKind(kind string) bool {
+	for _, k := range Kinds() {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// validator checks the subset of JSON Schema written by the generator
+type validator struct {
+	ignore func(string) bool
+	errs   []string
+}
+
+func (v *validator) fail(path string, format string, args ...interface{}) {
+	if path == "" {
+		path = "claims"
+	}
+	v.errs = append(v.errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) validate(value interface{}, schema map[string]interface{}, path string) {
+	if !v.checkType(value, schema, path) || value == nil {
+		return
+	}
+
+	if s, ok := value.(string); ok && (v.ignore == nil || !v.ignore(s)) {
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := compile(pattern)
+			if err != nil {
+				v.fail(path, "invalid pattern %q in schema: %s", pattern, err)
+			} else if !re.MatchString(s) {
+				v.fail(path, "%q does not match %s", s, pattern)
+			}
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok && !contains(enum, s) {
+			v.fail(path, "%q is not one of %s", s, joinEnum(enum))
+		}
+	}
+	if number, ok := value.(json.Number); ok {
+		f, _ := number.Float64()
+		if minimum, ok := schema["minimum"].(json.Number); ok {
+			if m, _ := minimum.Float64(); f < m {
+				v.fail(path, "must be at least %s", minimum)
+			}
+		}
+		if maximum, ok := schema["maximum"].(json.Number); ok {
+			if m, _ := maximum.Float64(); f > m {
+				v.fail(path, "must be at most %s", maximum)
+			}
+		}
+	}
+
+	switch value := value.(type) {
+	case []interface{}:
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			return
+		}
+		for i, item := range value {
+			v.validate(item, items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					v.fail(joinPath(path, name.(string)), "required")
+				}
+			}
+		}
+		for key, field := range value {
+			if property, ok := properties[key].(map[string]interface{}); ok {
+				v.validate(field, property, joinPath(path, key))
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					v.fail(joinPath(path, key), "unknown field")
+				}
+			case map[string]interface{}:
+				v.validate(field, additional, joinPath(path, key))
+			}
+		}
+	}
+}
+
+// checkType reports values not matching the type of the schema
+func (v *validator) checkType(value interface{}, schema map[string]interface{}, path string) bool {
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, name := range t {
+			types = append(types, name.(string))
+		}
+	default:
+		return true
+	}
+
+	actual := typeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	v.fail(path, "expected %s, got %s", strings.Join(types, " or "), actual)
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
+
+func contains(enum []interface{}, s string) bool {
+	for _, e := range enum {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func joinEnum(enum []interface{}) string {
+	var values []string
+	for _, e := range enum {
+		values = append(values, fmt.Sprint(e))
+	}
+	return strings.Join(values, ", ")
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
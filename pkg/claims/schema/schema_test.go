@@ -0,0 +1,81 @@
+package schema
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	accountv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/account/v1alpha1"
+	userv1 "github.com/edgefarm/vault-plugin-secrets-nats/pkg/claims/user/v1alpha1"
+)
+
+func TestExampleData(t *testing.T) {
+	for kind, field := range map[string]string{
+		Operator: "claims",
+		Account:  "claims",
+		User:     "claimsTemplate",
+	} {
+		data, err := os.ReadFile("../../../example_data/" + kind + ".json")
+		require.NoError(t, err)
+		issue := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(data, &issue))
+		assert.NoError(t, Validate(kind, issue[field], IgnoreStrings(hasVariable)), kind)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("valid claims", func(t *testing.T) {
+		claims := userv1.UserClaims{}
+		claims.AllowedConnectionTypes = []string{"STANDARD", "MQTT"}
+		claims.Times = []userv1.TimeRange{{Start: "08:00:00", End: "17:30:00"}}
+		assert.NoError(t, Validate(User, claims))
+
+		account := accountv1.AccountClaims{}
+		account.Exports = []accountv1.Export{{Name: "orders", Subject: "orders.>", Type: "Stream"}}
+		account.Mappings = map[string][]accountv1.WeightedMapping{"a": {{Subject: "b", Weight: 100}}}
+		assert.NoError(t, Validate(Account, account))
+	})
+
+	t.Run("invalid claims", func(t *testing.T) {
+		claims := userv1.UserClaims{}
+		claims.AllowedConnectionTypes = []string{"STANDARD", "mqtt"}
+		claims.Times = []userv1.TimeRange{{Start: "8am"}}
+		err := Validate(User, claims)
+		assert.EqualError(t, err, `invalid claims: user.allowedConnectionTypes[1]: "mqtt" is not one of STANDARD, WEBSOCKET, LEAFNODE, LEAFNODE_WS, MQTT, MQTT_WS; `+
+			`user.times[0].start: "8am" does not match ^(((([0-1][0-9])|(2[0-3])):?[0-5][0-9]:?[0-5][0-9]+$))`)
+	})
+
+	t.Run("generic documents", func(t *testing.T) {
+		err := Validate(Operator, map[string]interface{}{
+			"operator": map[string]interface{}{
+				"signingKeys": "opsk1",
+				"unknown":     true,
+			},
+		})
+		assert.EqualError(t, err, "invalid claims: operator.signingKeys: expected array, got string; operator.unknown: unknown field")
+
+		err = Validate(User, map[string]interface{}{"user": map[string]interface{}{"resp": nil}})
+		assert.NoError(t, err)
+		err = Validate(User, map[string]interface{}{"user": map[string]interface{}{"resp": map[string]interface{}{"max": 1}}})
+		assert.EqualError(t, err, "invalid claims: user.resp.ttl: required")
+	})
+
+	t.Run("ignored strings", func(t *testing.T) {
+		claims := userv1.UserClaims{}
+		claims.AllowedConnectionTypes = []string{"{{type}}"}
+		assert.Error(t, Validate(User, claims))
+		assert.NoError(t, Validate(User, claims, IgnoreStrings(hasVariable)))
+	})
+
+	t.Run("unknown kind", func(t *testing.T) {
+		assert.Error(t, Validate("link", nil))
+	})
+}
+
+func hasVariable(s string) bool {
+	return strings.Contains(s, "{{")
+}
@@ -83,3 +83,37 @@ func Convert(claims *UserClaims) (*jwt.UserClaims, error) {
 	nats.GenericFields = common.ConvertGenericFields(&claims.GenericFields)
 	return nats, nil
 }
+
+// ConvertFromNats converts nats user claims into user claims
+func ConvertFromNats(nats *jwt.UserClaims) *UserClaims {
+	claims := &UserClaims{
+		User: User{
+			IssuerAccount: nats.IssuerAccount,
+			UserPermissionLimits: UserPermissionLimits{
+				Permissions:            common.ConvertPermissionsFromNats(&nats.Permissions),
+				BearerToken:            nats.BearerToken,
+				AllowedConnectionTypes: []string(nats.AllowedConnectionTypes),
+				Limits: Limits{
+					UserLimits: UserLimits{
+						Src:    []string(nats.Src),
+						Locale: nats.Locale,
+					},
+					NatsLimits: common.NatsLimits{
+						Subs:    nats.NatsLimits.Subs,
+						Data:    nats.NatsLimits.Data,
+						Payload: nats.NatsLimits.Payload,
+					},
+				},
+			},
+		},
+	}
+	for _, e := range nats.Times {
+		claims.Times = append(claims.Times, TimeRange{
+			Start: e.Start,
+			End:   e.End,
+		})
+	}
+	claims.ClaimsData = common.ConvertClaimsDataFromNats(&nats.ClaimsData)
+	claims.GenericFields = common.ConvertGenericFieldsFromNats(&nats.GenericFields)
+	return claims
+}
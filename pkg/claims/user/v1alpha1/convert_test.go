@@ -90,3 +90,46 @@ func TestConvert(t *testing.T) {
 	assert.Equal(nats.UserPermissionLimits.BearerToken, true)
 	assert.Equal(nats.UserPermissionLimits.AllowedConnectionTypes, jwt.StringList{"STANDARD", "WEBSOCKET"})
 }
+
+func TestConvertFromNats(t *testing.T) {
+	assert := assert.New(t)
+	claims := &UserClaims{
+		ClaimsData: common.ClaimsData{
+			Name:    "myuser",
+			Subject: "subject",
+			Expires: 1675804527,
+		},
+		User: User{
+			UserPermissionLimits: UserPermissionLimits{
+				Permissions: common.Permissions{
+					Pub: common.Permission{
+						Allow: []string{"pub1"},
+						Deny:  []string{"pub2"},
+					},
+					Sub: common.Permission{
+						Allow: []string{"sub1"},
+					},
+					Resp: &common.ResponsePermission{
+						MaxMsgs: 100,
+						Expires: "5m0s",
+					},
+				},
+				Limits: Limits{
+					UserLimits: UserLimits{
+						Src:    []string{"192.0.2.0/24"},
+						Times:  []TimeRange{{Start: "01:15:00", End: "03:15:00"}},
+						Locale: "Europe/Berlin",
+					},
+					NatsLimits: common.NatsLimits{Subs: -1, Data: -1, Payload: -1},
+				},
+				BearerToken:            true,
+				AllowedConnectionTypes: []string{"STANDARD"},
+			},
+			IssuerAccount: "issueraccount",
+		},
+	}
+
+	nats, err := Convert(claims)
+	assert.NoError(err)
+	assert.Equal(claims, ConvertFromNats(nats))
+}
@@ -0,0 +1,178 @@
+package nsc
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nkeys"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Archives are rooted like the nsc data directory, holding the store below
+// StoresDir and the keys directory below KeysDir
+const (
+	StoresDir = "stores"
+	KeysDir   = "keys"
+)
+
+// OperatorJWTPath is the path of the operator JWT in an archive
+func OperatorJWTPath(operator string) string {
+	return path.Join(StoresDir, operator, operator+".jwt")
+}
+
+// OperatorInfoPath is the path of the nsc info file of the operator in an archive
+func OperatorInfoPath(operator string) string {
+	return path.Join(StoresDir, operator, ".nsc")
+}
+
+// AccountJWTPath is the path of an account JWT in an archive
+func AccountJWTPath(operator string, account string) string {
+	return path.Join(StoresDir, operator, "accounts", account, account+".jwt")
+}
+
+// UserJWTPath is the path of a user JWT in an archive
+func UserJWTPath(operator string, account string, user string) string {
+	return path.Join(StoresDir, operator, "accounts", account, "users", user+".jwt")
+}
+
+// KeyPath is the path of the seed of a public key in an archive
+func KeyPath(publicKey string) string {
+	return path.Join(KeysDir, "keys", publicKey[:1], publicKey[1:3], publicKey+".nk")
+}
+
+// CredsPath is the path of the creds file of a user in an archive
+func CredsPath(operator string, account string, user string) string {
+	return path.Join(KeysDir, "creds", operator, account, user+".creds")
+}
+
+// OperatorInfo is the content of the nsc info file of an operator
+func OperatorInfo(operator string) []byte {
+	info, _ := json.Marshal(map[string]string{"name": operator})
+	return info
+}
+
+// WriteArchive writes the files as tar archive. Seeds and creds are only
+// readable by the owner.
+func WriteArchive(files map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	for _, name := range names {
+		mode := int64(0o644)
+		if strings.HasPrefix(name, KeysDir+"/") {
+			mode = 0o600
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    mode,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = tw.Write(files[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := tw.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ExtractArchive writes the files of the tar archive below dir
+func ExtractArchive(archive []byte, dir string) error {
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(target), 0o700)
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		if closeErr != nil {
+			return closeErr
+		}
+	}
+}
+
+// SealArchive encrypts the archive for the curve (xkey) public key of the
+// recipient as NaCl anonymous box
+func SealArchive(archive []byte, recipient string) ([]byte, error) {
+	raw, err := nkeys.Decode(nkeys.PrefixByteCurve, []byte(recipient))
+	if err != nil {
+		return nil, fmt.Errorf("invalid curve public key: %w", err)
+	}
+	var key [32]byte
+	if len(raw) != len(key) {
+		return nil, fmt.Errorf("invalid curve public key")
+	}
+	copy(key[:], raw)
+	return box.SealAnonymous(nil, archive, &key, rand.Reader)
+}
+
+// OpenArchive decrypts an archive sealed for the public key of the curve (xkey) seed
+func OpenArchive(sealed []byte, seed []byte) ([]byte, error) {
+	kp, err := nkeys.FromCurveSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	_, raw, err := nkeys.DecodeSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	rawPublic, err := nkeys.Decode(nkeys.PrefixByteCurve, []byte(publicKey))
+	if err != nil {
+		return nil, err
+	}
+	var private, public [32]byte
+	copy(private[:], raw)
+	copy(public[:], rawPublic)
+	archive, ok := box.OpenAnonymous(nil, sealed, &public, &private)
+	if !ok {
+		return nil, fmt.Errorf("cannot decrypt archive")
+	}
+	return archive, nil
+}
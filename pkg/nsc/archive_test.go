@@ -0,0 +1,56 @@
+package nsc
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	archive, err := WriteArchive(map[string][]byte{
+		OperatorJWTPath("op"): []byte("jwt"),
+		KeyPath("OABC"):       []byte("seed"),
+	})
+	require.NoError(t, err)
+
+	curve, err := nkeys.CreateCurveKeys()
+	require.NoError(t, err)
+	publicKey, err := curve.PublicKey()
+	require.NoError(t, err)
+	seed, err := curve.Seed()
+	require.NoError(t, err)
+	sealed, err := SealArchive(archive, publicKey)
+	require.NoError(t, err)
+	opened, err := OpenArchive(sealed, seed)
+	require.NoError(t, err)
+	assert.Equal(t, archive, opened)
+
+	dir := t.TempDir()
+	require.NoError(t, ExtractArchive(opened, dir))
+	content, err := os.ReadFile(filepath.Join(dir, "stores", "op", "op.jwt"))
+	require.NoError(t, err)
+	assert.Equal(t, "jwt", string(content))
+	info, err := os.Stat(filepath.Join(dir, "keys", "keys", "O", "AB", "OABC.nk"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	_, err = SealArchive(archive, "invalid")
+	assert.Error(t, err)
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0o644, Size: 1}))
+	_, err := tw.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	assert.Error(t, ExtractArchive(buf.Bytes(), t.TempDir()))
+}
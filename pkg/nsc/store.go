@@ -0,0 +1,144 @@
+// Package nsc reads the operator stores and the keys directory written by the nsc tool
+package nsc
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// Store is the content of an operator of an nsc store
+type Store struct {
+	Operator string
+	JWT      string
+	Accounts []string
+	Users    []string
+	// Seeds of the keys referenced by the JWTs
+	Seeds []string
+}
+
+// ReadStore reads the JWTs of the operator from the nsc store directory and
+// the seeds of their keys from the nsc keys directory. The layout is
+//
+//	<storeDir>/<operator>/<operator>.jwt
+//	<storeDir>/<operator>/accounts/<account>/<account>.jwt
+//	<storeDir>/<operator>/accounts/<account>/users/<user>.jwt
+//	<keysDir>/**/<public key>.nk
+func ReadStore(storeDir string, keysDir string, operator string) (*Store, error) {
+	operatorDir := filepath.Join(storeDir, operator)
+	token, err := readJWT(filepath.Join(operatorDir, operator+".jwt"))
+	if err != nil {
+		return nil, err
+	}
+	operatorClaims, err := jwt.DecodeOperatorClaims(token)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode operator jwt: %w", err)
+	}
+	store := &Store{
+		Operator: operator,
+		JWT:      token,
+	}
+	keys := map[string]bool{operatorClaims.Subject: true}
+	for _, key := range operatorClaims.SigningKeys {
+		keys[key] = true
+	}
+
+	accountDirs, err := os.ReadDir(filepath.Join(operatorDir, "accounts"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, dir := range accountDirs {
+		if !dir.IsDir() {
+			continue
+		}
+		accountDir := filepath.Join(operatorDir, "accounts", dir.Name())
+		token, err := readJWT(filepath.Join(accountDir, dir.Name()+".jwt"))
+		if err != nil {
+			return nil, err
+		}
+		accountClaims, err := jwt.DecodeAccountClaims(token)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode account jwt of %s: %w", dir.Name(), err)
+		}
+		store.Accounts = append(store.Accounts, token)
+		keys[accountClaims.Subject] = true
+		for _, key := range accountClaims.SigningKeys.Keys() {
+			keys[key] = true
+		}
+
+		userFiles, err := filepath.Glob(filepath.Join(accountDir, "users", "*.jwt"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range userFiles {
+			token, err := readJWT(file)
+			if err != nil {
+				return nil, err
+			}
+			userClaims, err := jwt.DecodeUserClaims(token)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode user jwt %s: %w", file, err)
+			}
+			store.Users = append(store.Users, token)
+			keys[userClaims.Subject] = true
+		}
+	}
+
+	store.Seeds, err = readSeeds(keysDir, keys)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func readJWT(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readSeeds reads the seeds of the given public keys from the keys directory,
+// keys of other operators are left out
+func readSeeds(keysDir string, keys map[string]bool) ([]string, error) {
+	var seeds []string
+	err := filepath.WalkDir(keysDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".nk" {
+			return nil
+		}
+		if !keys[strings.TrimSuffix(d.Name(), ".nk")] {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		seed := strings.TrimSpace(string(data))
+		kp, err := nkeys.FromSeed([]byte(seed))
+		if err != nil {
+			return fmt.Errorf("invalid seed in %s: %w", path, err)
+		}
+		publicKey, err := kp.PublicKey()
+		if err != nil {
+			return err
+		}
+		if !keys[publicKey] {
+			return fmt.Errorf("seed in %s does not match its file name", path)
+		}
+		seeds = append(seeds, seed)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seeds, nil
+}
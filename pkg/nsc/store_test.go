@@ -0,0 +1,71 @@
+package nsc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestReadStore(t *testing.T) {
+	storeDir := t.TempDir()
+	keysDir := t.TempDir()
+
+	// writeKey stores the seed the way nsc does: keys/<prefix>/<pub[1:3]>/<pub>.nk
+	writeKey := func(kp nkeys.KeyPair) string {
+		pub, err := kp.PublicKey()
+		require.NoError(t, err)
+		seed, err := kp.Seed()
+		require.NoError(t, err)
+		writeFile(t, filepath.Join(keysDir, "keys", pub[:1], pub[1:3], pub+".nk"), string(seed))
+		return string(seed)
+	}
+	pair := func(prefix nkeys.PrefixByte) nkeys.KeyPair {
+		kp, err := nkeys.CreatePair(prefix)
+		require.NoError(t, err)
+		return kp
+	}
+	public := func(kp nkeys.KeyPair) string {
+		pub, err := kp.PublicKey()
+		require.NoError(t, err)
+		return pub
+	}
+
+	operator := pair(nkeys.PrefixByteOperator)
+	account := pair(nkeys.PrefixByteAccount)
+	user := pair(nkeys.PrefixByteUser)
+	other := pair(nkeys.PrefixByteOperator)
+	seeds := []string{writeKey(operator), writeKey(account), writeKey(user)}
+	writeKey(other)
+
+	operatorJWT, err := jwt.NewOperatorClaims(public(operator)).Encode(operator)
+	require.NoError(t, err)
+	accountJWT, err := jwt.NewAccountClaims(public(account)).Encode(operator)
+	require.NoError(t, err)
+	userJWT, err := jwt.NewUserClaims(public(user)).Encode(account)
+	require.NoError(t, err)
+	writeFile(t, filepath.Join(storeDir, "op", "op.jwt"), operatorJWT+"\n")
+	writeFile(t, filepath.Join(storeDir, "op", "accounts", "ac1", "ac1.jwt"), accountJWT)
+	writeFile(t, filepath.Join(storeDir, "op", "accounts", "ac1", "users", "us1.jwt"), userJWT)
+
+	store, err := ReadStore(storeDir, keysDir, "op")
+	require.NoError(t, err)
+	assert.Equal(t, operatorJWT, store.JWT)
+	assert.Equal(t, []string{accountJWT}, store.Accounts)
+	assert.Equal(t, []string{userJWT}, store.Users)
+	// the key of the other operator is not read
+	assert.ElementsMatch(t, seeds, store.Seeds)
+
+	_, err = ReadStore(storeDir, keysDir, "missing")
+	assert.Error(t, err)
+}
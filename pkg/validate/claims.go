@@ -0,0 +1,24 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Claims runs the nats-io/jwt validation of the claims. Blocking issues
+// are returned as error, non-blocking issues as warnings.
+func Claims(claims jwt.Claims) ([]string, error) {
+	vr := jwt.CreateValidationResults()
+	claims.Validate(vr)
+
+	var errs []string
+	for _, err := range vr.Errors() {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return vr.Warnings(), fmt.Errorf("%s: %s", InvalidClaimsError, strings.Join(errs, "; "))
+	}
+	return vr.Warnings(), nil
+}
@@ -0,0 +1,38 @@
+package validate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimsPositive(t *testing.T) {
+	kp, _ := nkeys.CreateAccount()
+	pub, _ := kp.PublicKey()
+	claims := jwt.NewAccountClaims(pub)
+	warnings, err := Claims(claims)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestClaimsWarnings(t *testing.T) {
+	kp, _ := nkeys.CreateAccount()
+	pub, _ := kp.PublicKey()
+	claims := jwt.NewAccountClaims(pub)
+	claims.NotBefore = time.Now().Add(time.Hour).Unix()
+	warnings, err := Claims(claims)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"claim is not yet valid"}, warnings)
+}
+
+func TestClaimsNegative(t *testing.T) {
+	kp, _ := nkeys.CreateAccount()
+	pub, _ := kp.PublicKey()
+	claims := jwt.NewAccountClaims(pub)
+	claims.Exports.Add(&jwt.Export{Subject: "foo bar", Type: jwt.Stream})
+	_, err := Claims(claims)
+	assert.ErrorContains(t, err, InvalidClaimsError)
+}
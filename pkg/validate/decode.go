@@ -0,0 +1,207 @@
+package validate
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// StrictDecode decodes data into out. Unlike json.Unmarshal it rejects
+// fields that are not part of out and values of the wrong type. Every
+// error carries the path of the offending field, e.g.
+// claimsTemplate.user.pub.allow[2].
+func StrictDecode(data map[string]interface{}, out interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// normalize the data to the types produced by encoding/json
+	var generic interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	checkValue(generic, reflect.TypeOf(out), "", &errs)
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s: %s", InvalidKeysError, strings.Join(errs, "; "))
+	}
+
+	return json.Unmarshal(raw, out)
+}
+
+func checkValue(value interface{}, t reflect.Type, path string, errs *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if value == nil {
+		return
+	}
+	// types decoding themselves are not inspected
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, typeError(path, "object", value))
+			return
+		}
+		fields := structFields(t)
+		for key, v := range object {
+			field, ok := lookupField(fields, key)
+			if !ok {
+				*errs = append(*errs, fmt.Sprintf("%s: unknown field", joinPath(path, key)))
+				continue
+			}
+			checkValue(v, field, joinPath(path, key), errs)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			*errs = append(*errs, typeError(path, "object", value))
+			return
+		}
+		for key, v := range object {
+			checkValue(v, t.Elem(), joinPath(path, key), errs)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// []byte is encoded as base64 string
+			if _, ok := value.(string); !ok {
+				*errs = append(*errs, typeError(path, "string", value))
+			}
+			return
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			*errs = append(*errs, typeError(path, "array", value))
+			return
+		}
+		for i, v := range list {
+			checkValue(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			*errs = append(*errs, typeError(path, "string", value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			*errs = append(*errs, typeError(path, "boolean", value))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(json.Number)
+		if !ok {
+			*errs = append(*errs, typeError(path, "integer", value))
+			return
+		}
+		if _, err := number.Int64(); err != nil {
+			*errs = append(*errs, typeError(path, "integer", value))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if !ok || strings.HasPrefix(number.String(), "-") {
+			*errs = append(*errs, typeError(path, "unsigned integer", value))
+			return
+		}
+		if _, err := number.Int64(); err != nil {
+			*errs = append(*errs, typeError(path, "unsigned integer", value))
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			*errs = append(*errs, typeError(path, "number", value))
+		}
+	}
+}
+
+// structFields returns the json names of the fields of a struct
+// including the fields of embedded structs without a json name
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for k, v := range structFields(embedded) {
+					if _, ok := fields[k]; !ok {
+						fields[k] = v
+					}
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// lookupField matches keys like encoding/json, preferring an exact match
+// over a case-insensitive one
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeError(path string, expected string, value interface{}) string {
+	var got string
+	switch value.(type) {
+	case map[string]interface{}:
+		got = "object"
+	case []interface{}:
+		got = "array"
+	case string:
+		got = "string"
+	case bool:
+		got = "boolean"
+	case json.Number:
+		got = "number"
+	default:
+		got = fmt.Sprintf("%T", value)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", path, expected, got)
+}
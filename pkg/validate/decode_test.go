@@ -0,0 +1,85 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type decodeInline struct {
+	Allow []string `json:"allow,omitempty"`
+}
+
+type decodeNested struct {
+	decodeInline `json:",inline"`
+	Max          int64 `json:"max,omitempty"`
+}
+
+type decodeTarget struct {
+	Name   string                  `json:"name"`
+	Flag   bool                    `json:"flag,omitempty"`
+	Nested decodeNested            `json:"nested,omitempty"`
+	List   []decodeNested          `json:"list,omitempty"`
+	Map    map[string]decodeNested `json:"map,omitempty"`
+}
+
+func TestStrictDecodePositive(t *testing.T) {
+	var out decodeTarget
+	err := StrictDecode(map[string]interface{}{
+		"name": "a",
+		"flag": true,
+		"nested": map[string]interface{}{
+			"allow": []string{"foo", "bar"},
+			"max":   -1,
+		},
+		"list": []interface{}{
+			map[string]interface{}{"max": 1},
+		},
+		"map": map[string]interface{}{
+			"key": map[string]interface{}{"allow": []interface{}{"baz"}},
+		},
+	}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", out.Name)
+	assert.True(t, out.Flag)
+	assert.Equal(t, []string{"foo", "bar"}, out.Nested.Allow)
+	assert.Equal(t, int64(-1), out.Nested.Max)
+	assert.Equal(t, int64(1), out.List[0].Max)
+	assert.Equal(t, []string{"baz"}, out.Map["key"].Allow)
+}
+
+func TestStrictDecodeNegative(t *testing.T) {
+	for _, tc := range []struct {
+		data     map[string]interface{}
+		expected string
+	}{
+		{
+			data:     map[string]interface{}{"nmae": "a"},
+			expected: "nmae: unknown field",
+		},
+		{
+			data:     map[string]interface{}{"nested": map[string]interface{}{"alow": []string{}}},
+			expected: "nested.alow: unknown field",
+		},
+		{
+			data:     map[string]interface{}{"nested": map[string]interface{}{"allow": []interface{}{"a", "b", 3}}},
+			expected: "nested.allow[2]: expected string, got number",
+		},
+		{
+			data:     map[string]interface{}{"list": []interface{}{map[string]interface{}{"max": "1"}}},
+			expected: "list[0].max: expected integer, got string",
+		},
+		{
+			data:     map[string]interface{}{"map": map[string]interface{}{"key": map[string]interface{}{"foo": 1}}},
+			expected: "map.key.foo: unknown field",
+		},
+		{
+			data:     map[string]interface{}{"flag": "true"},
+			expected: "flag: expected boolean, got string",
+		},
+	} {
+		var out decodeTarget
+		err := StrictDecode(tc.data, &out)
+		assert.ErrorContains(t, err, tc.expected)
+	}
+}
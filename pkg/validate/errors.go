@@ -1,5 +1,6 @@
 package validate
 
 const (
-	InvalidKeysError = "invalid keys"
+	InvalidKeysError   = "invalid keys"
+	InvalidClaimsError = "invalid claims"
 )
@@ -0,0 +1,488 @@
+package natsbackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/rs/zerolog/log"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+const walIssueKind = "issue"
+
+type walOperation string
+
+const (
+	walOperationWrite  walOperation = "write"
+	walOperationDelete walOperation = "delete"
+)
+
+// issueWALEntry is written to the write-ahead log before an issue is
+// written or deleted and removed once the operation returned. Entries of
+// interrupted operations are handled by rollbackIssueWAL:
+//
+//   - a write that created the issue is rolled back by deleting the issue
+//     together with the nkeys and jwt it created
+//   - a write that updated the issue is rolled back by restoring the
+//     previous issue, nkeys and jwt and reissuing them
+//   - a delete is completed
+//
+// Links are handled like issues. Their entries own the issues and JWTs of
+// the linked accounts, which are restored and pushed again.
+type issueWALEntry struct {
+	// ID identifies the entry, the framework passes it to walRollback without its WAL id
+	ID        string       `json:"id,omitempty"`
+	Operation walOperation `json:"operation"`
+	Operator  string       `json:"operator"`
+	Account   string       `json:"account,omitempty"`
+	User      string       `json:"user,omitempty"`
+	Link      string       `json:"link,omitempty"`
+	// Accounts are the accounts linked by a link write
+	Accounts []string `json:"accounts,omitempty"`
+	// Cascade is set for deletes including the issues below
+	Cascade bool `json:"cascade,omitempty"`
+	// Tombstone is set for deletes moving the entries to the tombstone
+	Tombstone bool `json:"tombstone,omitempty"`
+	// Previous is the stored issue before a write, empty if the write created it
+	Previous []byte `json:"previous,omitempty"`
+	// Entries are the nkeys and jwt of the issue stored before a write, see
+	// ownedKeys. Keys missing here were created by the write.
+	Entries map[string][]byte `json:"entries,omitempty"`
+}
+
+// withIssueWAL runs fn guarded by the WAL entry. If fn fails, the operation
+// is rolled back right away like an interrupted one, unless it waits for a
+// missing issuer (see errPending). The entry is removed
+// once fn succeeded or the rollback did, otherwise it stays in the log for
+// the periodic rollback. The caller must hold the lock of the issue.
+func withIssueWAL(ctx context.Context, storage logical.Storage, entry *issueWALEntry, fn func() error) error {
+	// settle interrupted operations on the same issue first, so
+	// they can't be rolled back after this operation succeeded
+	err := rollbackPendingIssueWAL(ctx, storage, entry.issuePath())
+	if err != nil {
+		return err
+	}
+
+	if entry.Operation == walOperationWrite {
+		previous, err := storage.Get(ctx, entry.issuePath())
+		if err != nil {
+			return err
+		}
+		if previous != nil {
+			entry.Previous = previous.Value
+		}
+		entry.Entries, err = entry.storedEntries(ctx, storage)
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.ID, err = uuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	id, err := framework.PutWAL(ctx, storage, walIssueKind, entry)
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	if err != nil && !isPending(err) {
+		rollbackErr := rollbackIssueWAL(ctx, storage, entry)
+		if rollbackErr != nil {
+			log.Error().Err(rollbackErr).Str("operator", entry.Operator).Str("account", entry.Account).Str("user", entry.User).
+				Msg("cannot roll back failed issue operation, left to the wal rollback")
+			return err
+		}
+	}
+	deleteErr := framework.DeleteWAL(ctx, storage, id)
+	if err != nil {
+		if deleteErr != nil {
+			log.Error().Err(deleteErr).Msg("cannot delete wal entry")
+		}
+		return err
+	}
+	return deleteErr
+}
+
+// rollbackPendingIssueWAL rolls back the WAL entries left for the issue at path
+func rollbackPendingIssueWAL(ctx context.Context, storage logical.Storage, path string) error {
+	ids, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		wal, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if wal == nil || wal.Kind != walIssueKind {
+			continue
+		}
+		entry, err := decodeIssueWALEntry(wal.Data)
+		if err != nil {
+			return err
+		}
+		if entry.issuePath() != path {
+			continue
+		}
+		err = rollbackIssueWAL(ctx, storage, entry)
+		if err != nil {
+			return err
+		}
+		err = framework.DeleteWAL(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardIssueWAL deletes the WAL entries left for the issues of the
+// operator without rolling them back
+func discardIssueWAL(ctx context.Context, storage logical.Storage, operator string) error {
+	ids, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		wal, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+		if wal == nil || wal.Kind != walIssueKind {
+			continue
+		}
+		entry, err := decodeIssueWALEntry(wal.Data)
+		if err != nil {
+			return err
+		}
+		if entry.Operator != operator {
+			continue
+		}
+		err = framework.DeleteWAL(ctx, storage, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walRollback is called by the framework for WAL entries of operations
+// that did not finish within WALRollbackMinAge
+func (b *NatsBackend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
+	if kind != walIssueKind {
+		return fmt.Errorf("unknown wal entry kind: %s", kind)
+	}
+	entry, err := decodeIssueWALEntry(data)
+	if err != nil {
+		return err
+	}
+
+	defer b.lockOperator(entry.Operator)()
+
+	// the operation may have finished while waiting for the lock
+	if entry.ID != "" {
+		pending, err := hasIssueWAL(ctx, req.Storage, entry.ID)
+		if err != nil || !pending {
+			return err
+		}
+	}
+	return rollbackIssueWAL(ctx, req.Storage, entry)
+}
+
+// hasIssueWAL reports whether the WAL entry with the ID still exists
+func hasIssueWAL(ctx context.Context, storage logical.Storage, entryID string) (bool, error) {
+	ids, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		wal, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			return false, err
+		}
+		if wal == nil || wal.Kind != walIssueKind {
+			continue
+		}
+		entry, err := decodeIssueWALEntry(wal.Data)
+		if err != nil {
+			return false, err
+		}
+		if entry.ID == entryID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func decodeIssueWALEntry(data interface{}) (*issueWALEntry, error) {
+	raw, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid wal entry: %v", data)
+	}
+	entry := &issueWALEntry{}
+	err := stm.MapToStruct(raw, entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// rollbackIssueWAL rolls back or completes the operation of the entry
+func rollbackIssueWAL(ctx context.Context, storage logical.Storage, entry *issueWALEntry) error {
+	log.Info().
+		Str("operator", entry.Operator).Str("account", entry.Account).Str("user", entry.User).
+		Msgf("rollback issue %s", entry.Operation)
+
+	switch entry.Operation {
+	case walOperationDelete:
+		_, err := entry.deleteIssue(ctx, storage)
+		return err
+	case walOperationWrite:
+		var err error
+		switch {
+		case entry.Previous != nil:
+			err = storage.Put(ctx, &logical.StorageEntry{
+				Key:   entry.issuePath(),
+				Value: entry.Previous,
+			})
+		case entry.Link != "":
+			// the accounts are restored below
+			err = storage.Delete(ctx, entry.issuePath())
+		default:
+			_, err = entry.deleteIssue(ctx, storage)
+			if err != nil {
+				return err
+			}
+			// nkeys stored before the issue are kept
+			return entry.restoreEntries(ctx, storage)
+		}
+		if err != nil {
+			return err
+		}
+		// signing nkeys removed by the write are restored with their seeds
+		err = entry.restoreEntries(ctx, storage)
+		if err != nil {
+			return err
+		}
+		return entry.refreshIssue(ctx, storage)
+	default:
+		return fmt.Errorf("unknown wal operation: %s", entry.Operation)
+	}
+}
+
+func (e *issueWALEntry) issuePath() string {
+	switch {
+	case e.Link != "":
+		return getLinkIssuePath(e.Operator, e.Link)
+	case e.User != "":
+		return getUserIssuePath(e.Operator, e.Account, e.User)
+	case e.Account != "":
+		return getAccountIssuePath(e.Operator, e.Account)
+	default:
+		return getOperatorIssuePath(e.Operator)
+	}
+}
+
+// ownedKeys returns the storage keys of the nkeys and jwt of the issue
+func (e *issueWALEntry) ownedKeys(ctx context.Context, storage logical.Storage) ([]string, error) {
+	var keys []string
+	var signingPrefix string
+	switch {
+	case e.Link != "":
+		accounts, err := e.linkedAccounts()
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range accounts {
+			keys = append(keys, getAccountIssuePath(e.Operator, account), getAccountJWTPath(e.Operator, account))
+		}
+		return keys, nil
+	case e.User != "":
+		return []string{getUserNkeyPath(e.Operator, e.Account, e.User)}, nil
+	case e.Account != "":
+		keys = []string{getAccountNkeyPath(e.Operator, e.Account), getAccountJWTPath(e.Operator, e.Account)}
+		signingPrefix = getAccountSigningNkeyPath(e.Operator, e.Account, "")
+	default:
+		keys = []string{getOperatorNkeyPath(e.Operator), getOperatorJWTPath(e.Operator)}
+		signingPrefix = getOperatorSigningNkeyPath(e.Operator, "")
+	}
+	signingKeys, err := storage.List(ctx, signingPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, signingKey := range signingKeys {
+		keys = append(keys, signingPrefix+signingKey)
+	}
+	return keys, nil
+}
+
+// linkedAccounts returns the accounts of the link write and of the link
+// stored before it
+func (e *issueWALEntry) linkedAccounts() ([]string, error) {
+	accounts := append([]string{}, e.Accounts...)
+	if e.Previous != nil {
+		previous := IssueLinkStorage{}
+		err := json.Unmarshal(e.Previous, &previous)
+		if err != nil {
+			return nil, err
+		}
+		for _, account := range []string{previous.Exporter, previous.Importer} {
+			if !containsString(accounts, account) {
+				accounts = append(accounts, account)
+			}
+		}
+	}
+	return accounts, nil
+}
+
+// storedEntries returns the values of the owned keys of the issue
+func (e *issueWALEntry) storedEntries(ctx context.Context, storage logical.Storage) (map[string][]byte, error) {
+	keys, err := e.ownedKeys(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string][]byte{}
+	for _, key := range keys {
+		entry, err := storage.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries[key] = entry.Value
+		}
+	}
+	return entries, nil
+}
+
+// restoreEntries deletes the owned keys created by the write and puts
+// back the entries stored before it
+func (e *issueWALEntry) restoreEntries(ctx context.Context, storage logical.Storage) error {
+	keys, err := e.ownedKeys(ctx, storage)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, ok := e.Entries[key]; ok {
+			continue
+		}
+		err = storage.Delete(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	for key, value := range e.Entries {
+		err = storage.Put(ctx, &logical.StorageEntry{
+			Key:   key,
+			Value: value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIssue deletes the issue with all related nkeys and jwt. Cascading
+// deletes return the removed storage entries.
+func (e *issueWALEntry) deleteIssue(ctx context.Context, storage logical.Storage) ([]string, error) {
+	if e.Tombstone {
+		tombstone, err := openTombstoneStorage(ctx, storage, e.Operator, e.Account)
+		if err != nil {
+			return nil, err
+		}
+		if tombstone != nil {
+			storage = tombstone
+		}
+	}
+
+	switch {
+	case e.Link != "":
+		return nil, deleteLinkIssue(ctx, storage, IssueLinkParameters{
+			Operator: e.Operator,
+			Link:     e.Link,
+		})
+	case e.User != "":
+		return nil, deleteUserIssue(ctx, storage, IssueUserParameters{
+			Operator: e.Operator,
+			Account:  e.Account,
+			User:     e.User,
+		})
+	case e.Account != "":
+		if e.Cascade {
+			return deleteAccountIssueCascade(ctx, storage, e.Operator, e.Account)
+		}
+		return nil, deleteAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: e.Operator,
+			Account:  e.Account,
+		})
+	default:
+		if e.Cascade {
+			return deleteOperatorIssueCascade(ctx, storage, e.Operator)
+		}
+		return nil, deleteOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: e.Operator,
+		})
+	}
+}
+
+// refreshIssue reissues the nkeys and jwt of the stored issue
+func (e *issueWALEntry) refreshIssue(ctx context.Context, storage logical.Storage) error {
+	switch {
+	case e.Link != "":
+		accounts, err := e.linkedAccounts()
+		if err != nil {
+			return err
+		}
+		for _, account := range accounts {
+			issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+				Operator: e.Operator,
+				Account:  account,
+			})
+			if err != nil {
+				return err
+			}
+			if issue == nil {
+				continue
+			}
+			err = refreshAccount(ctx, storage, issue)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case e.User != "":
+		issue, err := readUserIssue(ctx, storage, IssueUserParameters{
+			Operator: e.Operator,
+			Account:  e.Account,
+			User:     e.User,
+		})
+		if err != nil || issue == nil {
+			return err
+		}
+		return refreshUser(ctx, storage, issue)
+	case e.Account != "":
+		issue, err := readAccountIssue(ctx, storage, IssueAccountParameters{
+			Operator: e.Operator,
+			Account:  e.Account,
+		})
+		if err != nil || issue == nil {
+			return err
+		}
+		return refreshAccount(ctx, storage, issue)
+	default:
+		issue, err := readOperatorIssue(ctx, storage, IssueOperatorParameters{
+			Operator: e.Operator,
+		})
+		if err != nil || issue == nil {
+			return err
+		}
+		return refreshOperator(ctx, storage, issue)
+	}
+}
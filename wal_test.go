@@ -0,0 +1,265 @@
+package natsbackend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/edgefarm/vault-plugin-secrets-nats/pkg/stm"
+)
+
+func TestIssueWALRollback(t *testing.T) {
+	b, reqStorage := getTestBackend(t)
+	ctx := context.Background()
+
+	for _, path := range []string{
+		"issue/operator/op1",
+		"issue/operator/op1/account/ac1",
+		"issue/operator/op1/account/ac1/user/us1",
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      path,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+	}
+
+	rollback := func() {
+		_, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.RollbackOperation,
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{"immediate": true},
+		})
+		require.NoError(t, err)
+
+		ids, err := framework.ListWAL(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	}
+
+	t.Run("interrupted create is rolled back", func(t *testing.T) {
+		_, err := framework.PutWAL(ctx, reqStorage, walIssueKind, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac2",
+		})
+		require.NoError(t, err)
+		err = addAccountIssue(ctx, reqStorage, IssueAccountParameters{
+			Operator: "op1",
+			Account:  "ac2",
+		})
+		require.NoError(t, err)
+
+		rollback()
+
+		params := IssueAccountParameters{Operator: "op1", Account: "ac2"}
+		issue, err := readAccountIssue(ctx, reqStorage, params)
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+		nkey, err := readAccountNkey(ctx, reqStorage, NkeyParameters{Operator: "op1", Account: "ac2"})
+		require.NoError(t, err)
+		assert.Nil(t, nkey)
+		accJWT, err := readAccountJWT(ctx, reqStorage, JWTParameters{Operator: "op1", Account: "ac2"})
+		require.NoError(t, err)
+		assert.Nil(t, accJWT)
+	})
+
+	t.Run("interrupted update is rolled back", func(t *testing.T) {
+		previous, err := reqStorage.Get(ctx, getAccountIssuePath("op1", "ac1"))
+		require.NoError(t, err)
+		_, err = framework.PutWAL(ctx, reqStorage, walIssueKind, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac1",
+			Previous:  previous.Value,
+		})
+		require.NoError(t, err)
+		params := IssueAccountParameters{Operator: "op1", Account: "ac1"}
+		params.Claims.Account.Limits.Subs = 10
+		err = addAccountIssue(ctx, reqStorage, params)
+		require.NoError(t, err)
+
+		rollback()
+
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		require.NotNil(t, issue)
+		assert.Equal(t, int64(1), issue.Version)
+		assert.Equal(t, int64(0), issue.Claims.Account.Limits.Subs)
+
+		accJWT, err := readAccountJWT(ctx, reqStorage, JWTParameters{Operator: "op1", Account: "ac1"})
+		require.NoError(t, err)
+		require.NotNil(t, accJWT)
+		claims, err := jwt.DecodeAccountClaims(accJWT.JWT)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), claims.Limits.Subs)
+	})
+
+	t.Run("interrupted delete is completed", func(t *testing.T) {
+		_, err := framework.PutWAL(ctx, reqStorage, walIssueKind, &issueWALEntry{
+			Operation: walOperationDelete,
+			Operator:  "op1",
+			Account:   "ac1",
+			User:      "us1",
+		})
+		require.NoError(t, err)
+		err = deleteUserNkey(ctx, reqStorage, NkeyParameters{Operator: "op1", Account: "ac1", User: "us1"})
+		require.NoError(t, err)
+
+		rollback()
+
+		issue, err := readUserIssue(ctx, reqStorage, IssueUserParameters{Operator: "op1", Account: "ac1", User: "us1"})
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+	})
+
+	t.Run("pending entries are settled before the next write", func(t *testing.T) {
+		_, err := framework.PutWAL(ctx, reqStorage, walIssueKind, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac3",
+		})
+		require.NoError(t, err)
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "issue/operator/op1/account/ac3",
+			Storage:   reqStorage,
+			Data:      map[string]interface{}{},
+		})
+		require.NoError(t, err)
+		require.False(t, resp.IsError())
+
+		ids, err := framework.ListWAL(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+
+		// the framework finds nothing left to roll back
+		rollback()
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac3"})
+		require.NoError(t, err)
+		assert.NotNil(t, issue)
+	})
+
+	t.Run("failed write is rolled back", func(t *testing.T) {
+		failing := &failingStorage{Storage: reqStorage, prefix: "jwt/"}
+		err := withIssueWAL(ctx, failing, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac4",
+		}, func() error {
+			return addAccountIssue(ctx, failing, IssueAccountParameters{Operator: "op1", Account: "ac4"})
+		})
+		require.Error(t, err)
+
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac4"})
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+		nkey, err := readAccountNkey(ctx, reqStorage, NkeyParameters{Operator: "op1", Account: "ac4"})
+		require.NoError(t, err)
+		assert.Nil(t, nkey)
+		ids, err := framework.ListWAL(ctx, reqStorage)
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+
+	t.Run("failed create keeps nkeys stored before the issue", func(t *testing.T) {
+		// stored without creating the issue, like an import does
+		key, err := nkeys.CreateAccount()
+		require.NoError(t, err)
+		seed, err := key.Seed()
+		require.NoError(t, err)
+		nkeyParams := NkeyParameters{Operator: "op1", Account: "ac5"}
+		require.NoError(t, storeInStorage(ctx, reqStorage, getAccountNkeyPath("op1", "ac5"), &NKeyStorage{Seed: seed}))
+
+		failing := &failingStorage{Storage: reqStorage, prefix: "jwt/"}
+		err = withIssueWAL(ctx, failing, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac5",
+		}, func() error {
+			return addAccountIssue(ctx, failing, IssueAccountParameters{Operator: "op1", Account: "ac5"})
+		})
+		require.Error(t, err)
+
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac5"})
+		require.NoError(t, err)
+		assert.Nil(t, issue)
+		nkey, err := readAccountNkey(ctx, reqStorage, nkeyParams)
+		require.NoError(t, err)
+		require.NotNil(t, nkey)
+		assert.Equal(t, seed, nkey.Seed)
+	})
+
+	t.Run("failed update restores removed signing keys", func(t *testing.T) {
+		params := IssueAccountParameters{Operator: "op1", Account: "ac6"}
+		params.Claims.Account.SigningKeys = []string{"sk1"}
+		require.NoError(t, addAccountIssue(ctx, reqStorage, params))
+		signingParams := NkeyParameters{Operator: "op1", Account: "ac6", Signing: "sk1"}
+		before, err := readAccountSigningNkey(ctx, reqStorage, signingParams)
+		require.NoError(t, err)
+		require.NotNil(t, before)
+
+		failing := &failingStorage{Storage: reqStorage, prefix: "jwt/"}
+		err = withIssueWAL(ctx, reqStorage, &issueWALEntry{
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac6",
+		}, func() error {
+			params.Claims.Account.SigningKeys = []string{"sk2"}
+			return addAccountIssue(ctx, failing, params)
+		})
+		require.Error(t, err)
+
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac6"})
+		require.NoError(t, err)
+		require.NotNil(t, issue)
+		assert.Equal(t, []string{"sk1"}, issue.Claims.SigningKeys)
+		nkey, err := readAccountSigningNkey(ctx, reqStorage, signingParams)
+		require.NoError(t, err)
+		assert.Equal(t, before, nkey)
+		nkey, err = readAccountSigningNkey(ctx, reqStorage, NkeyParameters{Operator: "op1", Account: "ac6", Signing: "sk2"})
+		require.NoError(t, err)
+		assert.Nil(t, nkey)
+	})
+
+	t.Run("finished operation is not rolled back", func(t *testing.T) {
+		// the framework read the entry before the handler holding the lock finished
+		data := map[string]interface{}{}
+		require.NoError(t, stm.StructToMap(&issueWALEntry{
+			ID:        "finished",
+			Operation: walOperationWrite,
+			Operator:  "op1",
+			Account:   "ac3",
+		}, &data))
+		require.NoError(t, b.walRollback(ctx, &logical.Request{Storage: reqStorage}, walIssueKind, data))
+
+		issue, err := readAccountIssue(ctx, reqStorage, IssueAccountParameters{Operator: "op1", Account: "ac3"})
+		require.NoError(t, err)
+		assert.NotNil(t, issue)
+	})
+}
+
+// failingStorage fails writes of keys with the prefix
+type failingStorage struct {
+	logical.Storage
+	prefix string
+}
+
+func (s *failingStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if strings.HasPrefix(entry.Key, s.prefix) {
+		return fmt.Errorf("put %s failed", entry.Key)
+	}
+	return s.Storage.Put(ctx, entry)
+}
@@ -27,6 +27,11 @@ import ()
 func (in *IssueAccountParameters) DeepCopyInto(out *IssueAccountParameters) {
 	*out = *in
 	in.Claims.DeepCopyInto(&out.Claims)
+	if in.CAS != nil {
+		in, out := &in.CAS, &out.CAS
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssueAccountParameters.
@@ -43,6 +48,11 @@ func (in *IssueAccountParameters) DeepCopy() *IssueAccountParameters {
 func (in *IssueOperatorParameters) DeepCopyInto(out *IssueOperatorParameters) {
 	*out = *in
 	in.Claims.DeepCopyInto(&out.Claims)
+	if in.CAS != nil {
+		in, out := &in.CAS, &out.CAS
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssueOperatorParameters.
@@ -59,6 +69,11 @@ func (in *IssueOperatorParameters) DeepCopy() *IssueOperatorParameters {
 func (in *IssueUserParameters) DeepCopyInto(out *IssueUserParameters) {
 	*out = *in
 	in.ClaimsTemplate.DeepCopyInto(&out.ClaimsTemplate)
+	if in.CAS != nil {
+		in, out := &in.CAS, &out.CAS
+		*out = new(int64)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssueUserParameters.